@@ -0,0 +1,112 @@
+package asset
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/liornabat/gcp_inventory_exporter/collector"
+	"github.com/liornabat/gcp_inventory_exporter/inventory"
+	"github.com/liornabat/gcp_inventory_exporter/project"
+	"google.golang.org/api/cloudasset/v1"
+	"google.golang.org/api/option"
+	"strings"
+)
+
+// Client lists the Cloud Asset Inventory assets of a type under a parent such
+// as "organizations/123". It is an interface so that tests can stub it.
+type Client interface {
+	ListAssets(ctx context.Context, parent, assetType string, fn func(asset *cloudasset.Asset) error) error
+}
+
+type client struct {
+	service *cloudasset.Service
+}
+
+func NewClient(ctx context.Context, clientOptions ...option.ClientOption) (Client, error) {
+	service, err := cloudasset.NewService(ctx, clientOptions...)
+	if err != nil {
+		return nil, err
+	}
+	return &client{
+		service: service,
+	}, nil
+}
+
+func (c *client) ListAssets(ctx context.Context, parent, assetType string, fn func(asset *cloudasset.Asset) error) error {
+	req := c.service.Assets.List(parent).AssetTypes(assetType).ContentType("RESOURCE")
+	return req.Pages(ctx, func(page *cloudasset.ListAssetsResponse) error {
+		for _, asset := range page.Assets {
+			if err := fn(asset); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Collector runs an AssetCollector over the assets of the organization, in
+// place of the per project API calls of its Collect.
+type Collector struct {
+	collector.AssetCollector
+	client Client
+	opts   *collector.Options
+}
+
+func NewCollector(c collector.AssetCollector, client Client, opts *collector.Options) *Collector {
+	return &Collector{
+		AssetCollector: c,
+		client:         client,
+		opts:           opts,
+	}
+}
+
+func (c *Collector) RequiredAPIs() []string {
+	return []string{"cloudasset.googleapis.com"}
+}
+
+func (c *Collector) Collect(ctx context.Context, projects []*project.Project) ([]*inventory.Resource, error) {
+	log := c.opts.Log
+	log.Infof("Getting %s inventory from Cloud Asset Inventory", c.Name())
+	defer log.Infof("Done getting %s inventory from Cloud Asset Inventory", c.Name())
+	byNumber := map[string]*project.Project{}
+	for _, p := range projects {
+		byNumber["projects/"+p.Number] = p
+	}
+	parent := "organizations/" + c.opts.Config.OrgId
+	var assets []*collector.Asset
+	for _, assetType := range c.AssetTypes() {
+		start := len(assets)
+		if err := c.opts.Call(ctx, "cloudasset.assets.list", func() error {
+			assets = assets[:start]
+			return c.client.ListAssets(ctx, parent, assetType, func(asset *cloudasset.Asset) error {
+				p := assetProject(asset, byNumber)
+				if p == nil || asset.Resource == nil {
+					return nil
+				}
+				assets = append(assets, &collector.Asset{
+					Project:  p,
+					Type:     asset.AssetType,
+					Name:     asset.Name,
+					Location: asset.Resource.Location,
+					Data:     json.RawMessage(asset.Resource.Data),
+				})
+				return nil
+			})
+		}); err != nil {
+			log.Errorf("Failed to list %s assets of %s, error: %s", assetType, parent, err.Error())
+			c.opts.Errors.Add(inventory.NewError(c.Name(), "", parent, "cloudasset.assets.list", err))
+		}
+	}
+	log.Infof("Found %d %s assets", len(assets), c.Name())
+	return c.CollectAssets(ctx, assets)
+}
+
+// assetProject returns the exported project that contains the asset, or nil
+// for assets of projects that are not exported.
+func assetProject(asset *cloudasset.Asset, byNumber map[string]*project.Project) *project.Project {
+	for _, ancestor := range asset.Ancestors {
+		if strings.HasPrefix(ancestor, "projects/") {
+			return byNumber[ancestor]
+		}
+	}
+	return nil
+}
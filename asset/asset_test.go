@@ -0,0 +1,207 @@
+package asset
+
+import (
+	"context"
+	"errors"
+	"github.com/liornabat/gcp_inventory_exporter/collector"
+	"github.com/liornabat/gcp_inventory_exporter/config"
+	"github.com/liornabat/gcp_inventory_exporter/inventory"
+	"github.com/liornabat/gcp_inventory_exporter/pkg/limiter"
+	"github.com/liornabat/gcp_inventory_exporter/pkg/logger"
+	"github.com/liornabat/gcp_inventory_exporter/pkg/retry"
+	"github.com/liornabat/gcp_inventory_exporter/project"
+	"google.golang.org/api/cloudasset/v1"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// stubClient serves the assets of each asset type, failing the listed number
+// of attempts of a type with its error after the assets before failAfter.
+type stubClient struct {
+	assets    map[string][]*cloudasset.Asset
+	errs      map[string]error
+	failures  map[string]int
+	failAfter int
+	parents   []string
+}
+
+func (c *stubClient) ListAssets(ctx context.Context, parent, assetType string, fn func(asset *cloudasset.Asset) error) error {
+	c.parents = append(c.parents, parent)
+	for i, a := range c.assets[assetType] {
+		if c.failures[assetType] > 0 && i == c.failAfter {
+			c.failures[assetType]--
+			return c.errs[assetType]
+		}
+		if err := fn(a); err != nil {
+			return err
+		}
+	}
+	if c.failures[assetType] > 0 {
+		c.failures[assetType]--
+		return c.errs[assetType]
+	}
+	return nil
+}
+
+// stubCollector records the assets it is given.
+type stubCollector struct {
+	types  []string
+	assets []*collector.Asset
+}
+
+func (c *stubCollector) Name() string              { return "stub" }
+func (c *stubCollector) Sheet() string             { return "Stub" }
+func (c *stubCollector) Schema() *inventory.Schema { return &inventory.Schema{} }
+func (c *stubCollector) RequiredAPIs() []string    { return nil }
+func (c *stubCollector) AssetTypes() []string      { return c.types }
+
+func (c *stubCollector) Collect(ctx context.Context, projects []*project.Project) ([]*inventory.Resource, error) {
+	return nil, errors.New("Collect called in place of CollectAssets")
+}
+
+func (c *stubCollector) CollectAssets(ctx context.Context, assets []*collector.Asset) ([]*inventory.Resource, error) {
+	c.assets = assets
+	var resources []*inventory.Resource
+	for _, a := range assets {
+		resources = append(resources, inventory.NewResource(a.Type, a.Project.ID, a.Location, a.Name))
+	}
+	return resources, nil
+}
+
+func newAsset(name, assetType, location string, ancestors ...string) *cloudasset.Asset {
+	return &cloudasset.Asset{
+		Name:      name,
+		AssetType: assetType,
+		Ancestors: ancestors,
+		Resource:  &cloudasset.Resource{Location: location, Data: []byte(`{"name":"` + name + `"}`)},
+	}
+}
+
+func newTestOptions() *collector.Options {
+	log := logger.NewLogger("test", "error")
+	return &collector.Options{
+		Config:  &config.Config{OrgId: "111111111111"},
+		Log:     log,
+		Errors:  inventory.NewErrors(),
+		Limiter: limiter.NewLimiter(4, 2),
+		Retrier: retry.NewRetrier(&retry.Policy{MaxAttempts: 3, InitialBackoff: time.Millisecond, Multiplier: 2}, log),
+	}
+}
+
+// assetNames returns the project ID and name of each asset.
+func assetNames(assets []*collector.Asset) []string {
+	names := []string{}
+	for _, a := range assets {
+		names = append(names, a.Project.ID+" "+a.Name)
+	}
+	return names
+}
+
+func TestCollect(t *testing.T) {
+	const instanceType = "compute.googleapis.com/Instance"
+	const diskType = "compute.googleapis.com/Disk"
+	projects := []*project.Project{
+		{ID: "demo-project", Number: "123456789012"},
+		{ID: "other-project", Number: "210987654321"},
+	}
+
+	t.Run("assets are mapped to their project", func(t *testing.T) {
+		client := &stubClient{assets: map[string][]*cloudasset.Asset{
+			instanceType: {
+				// The project is the nearest ancestor, under folders.
+				newAsset("web-1", instanceType, "me-west1-a",
+					"projects/123456789012", "folders/222222222222", "organizations/111111111111"),
+				newAsset("web-2", instanceType, "me-west1-b",
+					"projects/210987654321", "organizations/111111111111"),
+				// Assets of projects that are not exported are skipped.
+				newAsset("unexported", instanceType, "me-west1-a",
+					"projects/999999999999", "organizations/111111111111"),
+				// As are assets outside any project and without a resource.
+				newAsset("org-level", instanceType, "global", "organizations/111111111111"),
+				{Name: "no-resource", AssetType: instanceType, Ancestors: []string{"projects/123456789012"}},
+			},
+			diskType: {
+				newAsset("disk-1", diskType, "me-west1-a", "projects/123456789012"),
+			},
+		}}
+		stub := &stubCollector{types: []string{instanceType, diskType}}
+		opts := newTestOptions()
+		resources, err := NewCollector(stub, client, opts).Collect(context.Background(), projects)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := []string{"demo-project web-1", "other-project web-2", "demo-project disk-1"}
+		if got := assetNames(stub.assets); !reflect.DeepEqual(got, want) {
+			t.Errorf("got assets %v, expected %v", got, want)
+		}
+		if len(resources) != len(want) {
+			t.Errorf("got %d resources, expected %d", len(resources), len(want))
+		}
+		if a := stub.assets[0]; a.Location != "me-west1-a" || a.Type != instanceType || string(a.Data) != `{"name":"web-1"}` {
+			t.Errorf("got asset %s of type %s in %s with data %s", a.Name, a.Type, a.Location, a.Data)
+		}
+		if want := []string{"organizations/111111111111", "organizations/111111111111"}; !reflect.DeepEqual(client.parents, want) {
+			t.Errorf("listed assets of %v, expected %v", client.parents, want)
+		}
+		if opts.Errors.Len() > 0 {
+			t.Errorf("got errors %s", opts.Errors.Summary())
+		}
+	})
+
+	t.Run("list error is recorded", func(t *testing.T) {
+		client := &stubClient{
+			assets: map[string][]*cloudasset.Asset{
+				instanceType: {newAsset("web-1", instanceType, "me-west1-a", "projects/123456789012")},
+				diskType:     {newAsset("disk-1", diskType, "me-west1-a", "projects/123456789012")},
+			},
+			errs:     map[string]error{instanceType: errors.New("permission denied")},
+			failures: map[string]int{instanceType: 1},
+		}
+		stub := &stubCollector{types: []string{instanceType, diskType}}
+		opts := newTestOptions()
+		if _, err := NewCollector(stub, client, opts).Collect(context.Background(), projects); err != nil {
+			t.Fatal(err)
+		}
+		// The other asset types are still collected.
+		if got, want := assetNames(stub.assets), []string{"demo-project disk-1"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("got assets %v, expected %v", got, want)
+		}
+		list := opts.Errors.List()
+		if len(list) != 1 {
+			t.Fatalf("got %d errors, expected 1", len(list))
+		}
+		e := list[0]
+		if e.Collector != "stub" || e.Project != "" || e.Location != "organizations/111111111111" || e.API != "cloudasset.assets.list" {
+			t.Errorf("got error %+v", *e)
+		}
+	})
+
+	t.Run("retried list discards the failed attempt", func(t *testing.T) {
+		client := &stubClient{
+			assets: map[string][]*cloudasset.Asset{
+				instanceType: {
+					newAsset("web-1", instanceType, "me-west1-a", "projects/123456789012"),
+					newAsset("web-2", instanceType, "me-west1-a", "projects/123456789012"),
+				},
+			},
+			errs:      map[string]error{instanceType: retry.Retryable(errors.New("unavailable"))},
+			failures:  map[string]int{instanceType: 1},
+			failAfter: 1,
+		}
+		stub := &stubCollector{types: []string{instanceType}}
+		opts := newTestOptions()
+		if _, err := NewCollector(stub, client, opts).Collect(context.Background(), projects); err != nil {
+			t.Fatal(err)
+		}
+		if got, want := assetNames(stub.assets), []string{"demo-project web-1", "demo-project web-2"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("got assets %v, expected %v", got, want)
+		}
+		if opts.Errors.Len() > 0 {
+			t.Errorf("got errors %s", opts.Errors.Summary())
+		}
+		if count := opts.Retrier.Counts()["cloudasset.assets.list"]; count != 1 {
+			t.Errorf("got %d retries, expected 1", count)
+		}
+	})
+}
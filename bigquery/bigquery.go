@@ -0,0 +1,339 @@
+package bigquery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/liornabat/gcp_inventory_exporter/inventory"
+	"github.com/liornabat/gcp_inventory_exporter/pkg/logger"
+	"github.com/liornabat/gcp_inventory_exporter/pkg/retry"
+	"google.golang.org/api/bigquery/v2"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
+	"net/http"
+	"strings"
+	"time"
+	"unicode"
+)
+
+const (
+	SnapshotIDField   = "snapshot_id"
+	SnapshotDateField = "snapshot_date"
+	LabelsField       = "labels"
+
+	// insertBatchSize is the number of rows sent per insertAll request,
+	// within the recommended maximum of 500.
+	insertBatchSize = 500
+)
+
+var fieldTypes = map[inventory.ColumnType]string{
+	inventory.StringColumn:     "STRING",
+	inventory.IntColumn:        "INTEGER",
+	inventory.BoolColumn:       "BOOLEAN",
+	inventory.TimestampColumn:  "TIMESTAMP",
+	inventory.StringListColumn: "STRING",
+}
+
+// Sink appends the tables of each snapshot to a BigQuery dataset, a table per
+// collector partitioned by the snapshot date.
+type Sink struct {
+	service   *bigquery.Service
+	projectID string
+	dataset   string
+	location  string
+	retrier   *retry.Retrier
+	log       *logger.Logger
+}
+
+func NewSink(ctx context.Context, log *logger.Logger, projectID, dataset, location string, retrier *retry.Retrier, clientOptions ...option.ClientOption) (*Sink, error) {
+	service, err := bigquery.NewService(ctx, clientOptions...)
+	if err != nil {
+		return nil, err
+	}
+	return &Sink{
+		service:   service,
+		projectID: projectID,
+		dataset:   dataset,
+		location:  location,
+		retrier:   retrier,
+		log:       log,
+	}, nil
+}
+
+// TableID returns the table of a collector, e.g. ip_addresses.
+func TableID(collector string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' {
+			return r
+		}
+		return '_'
+	}, collector)
+}
+
+// TableSchema returns the fields of the table of a collector schema: the
+// snapshot id and date, a field per column named in snake case and the
+// resource labels.
+func TableSchema(schema *inventory.Schema) *bigquery.TableSchema {
+	fields := []*bigquery.TableFieldSchema{
+		{Name: SnapshotIDField, Type: "STRING", Mode: "REQUIRED"},
+		{Name: SnapshotDateField, Type: "DATE", Mode: "REQUIRED"},
+	}
+	names := schema.FieldNames(SnapshotIDField, SnapshotDateField, LabelsField)
+	for i, column := range schema.Columns {
+		mode := "NULLABLE"
+		if column.Type == inventory.StringListColumn {
+			mode = "REPEATED"
+		}
+		fields = append(fields, &bigquery.TableFieldSchema{
+			Name:        names[i],
+			Type:        fieldTypes[column.Type],
+			Mode:        mode,
+			Description: column.Name,
+		})
+	}
+	fields = append(fields, &bigquery.TableFieldSchema{
+		Name: LabelsField,
+		Type: "RECORD",
+		Mode: "REPEATED",
+		Fields: []*bigquery.TableFieldSchema{
+			{Name: "key", Type: "STRING", Mode: "REQUIRED"},
+			{Name: "value", Type: "STRING", Mode: "NULLABLE"},
+		},
+	})
+	return &bigquery.TableSchema{Fields: fields}
+}
+
+func isNotFound(err error) bool {
+	var apiErr *googleapi.Error
+	return errors.As(err, &apiErr) && apiErr.Code == http.StatusNotFound
+}
+
+func isConflict(err error) bool {
+	var apiErr *googleapi.Error
+	return errors.As(err, &apiErr) && apiErr.Code == http.StatusConflict
+}
+
+// Write appends the rows of every table of the snapshot, including the
+// collection errors, creating the dataset and tables or adding new columns
+// to the tables as needed.
+func (s *Sink) Write(ctx context.Context, snapshot *inventory.Snapshot) error {
+	if err := s.ensureDataset(ctx); err != nil {
+		return fmt.Errorf("dataset %s: %s", s.dataset, err.Error())
+	}
+	for _, table := range append(snapshot.Tables, snapshot.ErrorsTable()) {
+		tableID := TableID(table.Collector)
+		changed, err := s.ensureTable(ctx, tableID, table)
+		if err != nil {
+			return fmt.Errorf("table %s: %s", tableID, err.Error())
+		}
+		if err := s.insertRows(ctx, tableID, snapshot, table, changed); err != nil {
+			return fmt.Errorf("table %s: %s", tableID, err.Error())
+		}
+		s.log.Infof("Appended %d rows to BigQuery table %s.%s.%s", len(table.Resources), s.projectID, s.dataset, tableID)
+	}
+	return nil
+}
+
+func (s *Sink) ensureDataset(ctx context.Context) error {
+	err := s.retrier.Do(ctx, "bigquery.datasets.get", func() error {
+		_, err := s.service.Datasets.Get(s.projectID, s.dataset).Context(ctx).Do()
+		return err
+	})
+	if !isNotFound(err) {
+		return err
+	}
+	s.log.Infof("Creating BigQuery dataset %s.%s", s.projectID, s.dataset)
+	err = s.retrier.Do(ctx, "bigquery.datasets.insert", func() error {
+		_, err := s.service.Datasets.Insert(s.projectID, &bigquery.Dataset{
+			DatasetReference: &bigquery.DatasetReference{ProjectId: s.projectID, DatasetId: s.dataset},
+			Location:         s.location,
+		}).Context(ctx).Do()
+		return err
+	})
+	if isConflict(err) {
+		return nil
+	}
+	return err
+}
+
+// ensureTable creates the table of a collector, or adds the fields of new
+// columns to an existing one, and reports whether it did. BigQuery cannot
+// change the type of a field, so a column whose type changed fails the write.
+func (s *Sink) ensureTable(ctx context.Context, tableID string, table *inventory.Table) (bool, error) {
+	schema := TableSchema(table.Schema)
+	var existing *bigquery.Table
+	err := s.retrier.Do(ctx, "bigquery.tables.get", func() error {
+		var err error
+		existing, err = s.service.Tables.Get(s.projectID, s.dataset, tableID).Context(ctx).Do()
+		return err
+	})
+	if isNotFound(err) {
+		s.log.Infof("Creating BigQuery table %s.%s.%s", s.projectID, s.dataset, tableID)
+		err = s.retrier.Do(ctx, "bigquery.tables.insert", func() error {
+			_, err := s.service.Tables.Insert(s.projectID, s.dataset, &bigquery.Table{
+				TableReference:   &bigquery.TableReference{ProjectId: s.projectID, DatasetId: s.dataset, TableId: tableID},
+				Description:      fmt.Sprintf("%s inventory, a row per resource and snapshot", table.Sheet),
+				Schema:           schema,
+				TimePartitioning: &bigquery.TimePartitioning{Type: "DAY", Field: SnapshotDateField},
+			}).Context(ctx).Do()
+			return err
+		})
+		if isConflict(err) {
+			return true, nil
+		}
+		return err == nil, err
+	}
+	if err != nil {
+		return false, err
+	}
+	merged, added, err := mergeSchema(existing.Schema, schema)
+	if err != nil || len(added) == 0 {
+		return false, err
+	}
+	s.log.Infof("Adding fields %s to BigQuery table %s.%s.%s", strings.Join(added, ", "), s.projectID, s.dataset, tableID)
+	err = s.retrier.Do(ctx, "bigquery.tables.patch", func() error {
+		_, err := s.service.Tables.Patch(s.projectID, s.dataset, tableID, &bigquery.Table{Schema: merged}).Context(ctx).Do()
+		return err
+	})
+	return err == nil, err
+}
+
+// mergeSchema returns the existing fields followed by the fields of schema
+// they lack, and the names of the added fields.
+func mergeSchema(existing, schema *bigquery.TableSchema) (*bigquery.TableSchema, []string, error) {
+	if existing == nil {
+		existing = &bigquery.TableSchema{}
+	}
+	fields := map[string]*bigquery.TableFieldSchema{}
+	for _, field := range existing.Fields {
+		fields[field.Name] = field
+	}
+	merged := &bigquery.TableSchema{Fields: append([]*bigquery.TableFieldSchema{}, existing.Fields...)}
+	var added []string
+	for _, field := range schema.Fields {
+		current, ok := fields[field.Name]
+		if !ok {
+			if field.Mode == "REQUIRED" {
+				return nil, nil, fmt.Errorf("cannot add required field %s", field.Name)
+			}
+			merged.Fields = append(merged.Fields, field)
+			added = append(added, field.Name)
+			continue
+		}
+		if current.Type != field.Type || mode(current) != mode(field) {
+			return nil, nil, fmt.Errorf("field %s is %s %s, expected %s %s", field.Name, mode(current), current.Type, mode(field), field.Type)
+		}
+	}
+	return merged, added, nil
+}
+
+func mode(field *bigquery.TableFieldSchema) string {
+	if field.Mode == "" {
+		return "NULLABLE"
+	}
+	return field.Mode
+}
+
+// value converts an attribute to the JSON value of its field. Values of
+// another type, such as timestamps that could not be parsed, are written as
+// null.
+func value(column inventory.Column, value interface{}) interface{} {
+	switch column.Type {
+	case inventory.IntColumn:
+		switch v := value.(type) {
+		case int, int64:
+			return v
+		}
+	case inventory.BoolColumn:
+		if v, ok := value.(bool); ok {
+			return v
+		}
+	case inventory.TimestampColumn:
+		if v, ok := value.(time.Time); ok {
+			return v.UTC().Format(time.RFC3339Nano)
+		}
+	case inventory.StringListColumn:
+		switch v := value.(type) {
+		case []string:
+			return v
+		case string:
+			return []string{v}
+		}
+		return []string{}
+	default:
+		if value != nil {
+			return column.Format(value)
+		}
+	}
+	return nil
+}
+
+// insertError returns the first row error of an insertAll response, or nil.
+// Fields added to a table are not seen by insertAll right away, so rows
+// rejected for a missing field are retried.
+func insertError(response *bigquery.TableDataInsertAllResponse, start int) error {
+	if len(response.InsertErrors) == 0 {
+		return nil
+	}
+	insertError := response.InsertErrors[0]
+	message := "unknown error"
+	if len(insertError.Errors) > 0 {
+		message = insertError.Errors[0].Message
+	}
+	err := fmt.Errorf("%d rows failed to insert, row %d: %s", len(response.InsertErrors), int64(start)+insertError.Index, message)
+	if strings.HasPrefix(message, "no such field") {
+		return retry.Retryable(err)
+	}
+	return err
+}
+
+// insertRows appends the rows of a table. A table just created or patched may
+// not be found by insertAll for a short while, so a changed table that is not
+// found is retried.
+func (s *Sink) insertRows(ctx context.Context, tableID string, snapshot *inventory.Snapshot, table *inventory.Table, changed bool) error {
+	names := table.Schema.FieldNames(SnapshotIDField, SnapshotDateField, LabelsField)
+	snapshotDate := snapshot.StartTime.UTC().Format("2006-01-02")
+	var rows []*bigquery.TableDataInsertAllRequestRows
+	for i, r := range table.Resources {
+		row := map[string]bigquery.JsonValue{
+			SnapshotIDField:   snapshot.RunID,
+			SnapshotDateField: snapshotDate,
+		}
+		for j, column := range table.Schema.Columns {
+			v, _ := r.Get(column.Name)
+			row[names[j]] = value(column, v)
+		}
+		labels := []map[string]string{}
+		for key, v := range r.Labels {
+			labels = append(labels, map[string]string{"key": key, "value": v})
+		}
+		row[LabelsField] = labels
+		rows = append(rows, &bigquery.TableDataInsertAllRequestRows{
+			// The insert id lets BigQuery drop the rows of a retried request.
+			InsertId: fmt.Sprintf("%s/%d", snapshot.RunID, i),
+			Json:     row,
+		})
+	}
+	for start := 0; start < len(rows); start += insertBatchSize {
+		end := start + insertBatchSize
+		if end > len(rows) {
+			end = len(rows)
+		}
+		err := s.retrier.Do(ctx, "bigquery.tabledata.insertAll", func() error {
+			response, err := s.service.Tabledata.InsertAll(s.projectID, s.dataset, tableID, &bigquery.TableDataInsertAllRequest{
+				Rows: rows[start:end],
+			}).Context(ctx).Do()
+			if changed && isNotFound(err) {
+				return retry.Retryable(err)
+			}
+			if err != nil {
+				return err
+			}
+			return insertError(response, start)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
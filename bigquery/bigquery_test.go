@@ -0,0 +1,115 @@
+package bigquery
+
+import (
+	"context"
+	"github.com/liornabat/gcp_inventory_exporter/inventory"
+	"github.com/liornabat/gcp_inventory_exporter/pkg/fakegcp"
+	"github.com/liornabat/gcp_inventory_exporter/pkg/gcpclient"
+	"github.com/liornabat/gcp_inventory_exporter/pkg/logger"
+	"github.com/liornabat/gcp_inventory_exporter/pkg/retry"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	testProject = "demo-project"
+	testDataset = "inventory"
+)
+
+func newTestSink(t *testing.T) (*Sink, *fakegcp.Server, *retry.Retrier) {
+	t.Helper()
+	srv := fakegcp.NewServer(nil)
+	endpoint := srv.Start()
+	t.Cleanup(srv.Close)
+	log := logger.NewLogger("test", "error")
+	retrier := retry.NewRetrier(&retry.Policy{MaxAttempts: 3, InitialBackoff: time.Millisecond, Multiplier: 2}, log)
+	sink, err := NewSink(context.Background(), log, testProject, testDataset, "EU", retrier, gcpclient.NewOptions(endpoint).For(gcpclient.BigQuery)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sink, srv, retrier
+}
+
+// newSnapshot returns a snapshot with a compute table of the columns, a
+// resource per name.
+func newSnapshot(startTime time.Time, columns []inventory.Column, names ...string) *inventory.Snapshot {
+	snapshot := inventory.NewSnapshot("111111111111", startTime)
+	table := &inventory.Table{
+		Collector: "compute",
+		Sheet:     "Compute",
+		Schema:    &inventory.Schema{Kind: "compute#instance", Key: []string{"Name"}, Columns: columns},
+	}
+	for _, name := range names {
+		r := inventory.NewResource("compute#instance", "demo-project", "me-west1-a", name).
+			Set("Name", name).
+			Set("CPU", 2).
+			Set("Zone", "me-west1-a").
+			SetLabels(map[string]string{"env": "dev"})
+		table.Resources = append(table.Resources, r)
+	}
+	snapshot.AddTable(table)
+	return snapshot
+}
+
+func TestSinkWrite(t *testing.T) {
+	sink, srv, retrier := newTestSink(t)
+	ctx := context.Background()
+	columns := []inventory.Column{
+		{Name: "Name", Type: inventory.StringColumn},
+		{Name: "CPU", Type: inventory.IntColumn},
+	}
+
+	// The first write creates the dataset and tables, whose first insert
+	// is not found. The errors table is empty, so nothing is inserted.
+	first := newSnapshot(time.Date(2026, 10, 15, 10, 0, 0, 0, time.UTC), columns, "web-1", "web-2")
+	if err := sink.Write(ctx, first); err != nil {
+		t.Fatalf("first write failed: %s", err.Error())
+	}
+	rows, ok := srv.TableRows(testProject, testDataset, "compute")
+	if !ok || len(rows) != 2 {
+		t.Fatalf("got %d compute rows, expected 2", len(rows))
+	}
+	if rows[0][SnapshotIDField] != first.RunID || rows[0][SnapshotDateField] != "2026-10-15" || rows[0]["name"] != "web-1" {
+		t.Errorf("got row %v", rows[0])
+	}
+	if _, ok := srv.TableRows(testProject, testDataset, "errors"); !ok {
+		t.Errorf("errors table was not created")
+	}
+	if count := retrier.Counts()["bigquery.tabledata.insertAll"]; count != 1 {
+		t.Errorf("got %d insert retries, expected 1", count)
+	}
+
+	// A new column is added to the table, whose field the first insert
+	// does not find.
+	second := newSnapshot(time.Date(2026, 10, 16, 10, 0, 0, 0, time.UTC),
+		append(columns, inventory.Column{Name: "Zone", Type: inventory.StringColumn}), "web-1")
+	if err := sink.Write(ctx, second); err != nil {
+		t.Fatalf("write with a new column failed: %s", err.Error())
+	}
+	rows, _ = srv.TableRows(testProject, testDataset, "compute")
+	if len(rows) != 3 || rows[2]["zone"] != "me-west1-a" {
+		t.Errorf("got rows %v, expected the new column in the third", rows)
+	}
+	if count := retrier.Counts()["bigquery.tabledata.insertAll"]; count != 2 {
+		t.Errorf("got %d insert retries, expected 2", count)
+	}
+
+	// Writing the same snapshot again adds no rows.
+	if err := sink.Write(ctx, second); err != nil {
+		t.Fatalf("repeated write failed: %s", err.Error())
+	}
+	if rows, _ = srv.TableRows(testProject, testDataset, "compute"); len(rows) != 3 {
+		t.Errorf("got %d rows after a repeated write, expected 3", len(rows))
+	}
+
+	// BigQuery cannot change the type of a field.
+	changed := newSnapshot(time.Date(2026, 10, 17, 10, 0, 0, 0, time.UTC), []inventory.Column{
+		{Name: "Name", Type: inventory.StringColumn},
+		{Name: "CPU", Type: inventory.StringColumn},
+	}, "web-1")
+	err := sink.Write(ctx, changed)
+	if err == nil || !strings.Contains(err.Error(), "field cpu") {
+		t.Errorf("got error %v, expected a type conflict on cpu", err)
+	}
+}
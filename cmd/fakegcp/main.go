@@ -0,0 +1,26 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+	"os"
+
+	"github.com/liornabat/gcp_inventory_exporter/pkg/fakegcp"
+)
+
+// fakegcp serves the fake GCP APIs so that the exporter can be run offline
+// with API_ENDPOINT=http://localhost:9090.
+func main() {
+	addr := flag.String("addr", ":9090", "address to listen on")
+	fixtures := flag.String("fixtures", "", "directory of JSON fixtures, defaults to the built-in fixtures")
+	flag.Parse()
+	server := fakegcp.NewServer(nil)
+	if *fixtures != "" {
+		server = fakegcp.NewServer(os.DirFS(*fixtures))
+	}
+	log.Printf("Serving fake GCP APIs on %s", *addr)
+	if err := http.ListenAndServe(*addr, server); err != nil {
+		log.Fatalf("http.ListenAndServe: %v\n", err)
+	}
+}
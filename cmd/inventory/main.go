@@ -0,0 +1,288 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+
+	"github.com/liornabat/gcp_inventory_exporter/config"
+	"github.com/liornabat/gcp_inventory_exporter/diff"
+	"github.com/liornabat/gcp_inventory_exporter/exporter"
+	"github.com/liornabat/gcp_inventory_exporter/output"
+	"github.com/liornabat/gcp_inventory_exporter/pkg/logger"
+)
+
+const usage = `Usage: inventory <command> [flags]
+
+Commands:
+  export    collect the inventory and write it to a local file or stdout
+  config    print the effective configuration as YAML
+  diff      compare two xlsx exports and write the changes
+  schema    print the JSON Schema of the json and ndjson formats
+
+Run "inventory <command> -h" for the flags of a command. The configuration is
+read from the file given by -config or CONFIG_FILE, then overridden by the
+environment variables (ORG_ID, REGIONS, ZONES, ...) and finally by the flags.
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	var err error
+	switch os.Args[1] {
+	case "export":
+		err = runExport(ctx, os.Args[2:])
+	case "config":
+		err = runConfig(os.Args[2:])
+	case "diff":
+		err = runDiff(os.Args[2:])
+	case "schema":
+		_, err = os.Stdout.Write(output.JSONSchema)
+	case "-h", "-help", "--help", "help":
+		fmt.Fprint(os.Stdout, usage)
+		return
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", os.Args[1], usage)
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "inventory %s: %s\n", os.Args[1], err.Error())
+		os.Exit(1)
+	}
+}
+
+type listFlag []string
+
+func (l *listFlag) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *listFlag) Set(value string) error {
+	*l = nil
+	if value != "" {
+		*l = strings.Split(value, ",")
+	}
+	return nil
+}
+
+// bindConfigFlags registers the flags that override cfg, with the current
+// values of cfg as defaults.
+func bindConfigFlags(flags *flag.FlagSet, cfg *config.Config) {
+	flags.StringVar(&cfg.OrgId, "org", cfg.OrgId, "organization id (ORG_ID)")
+	flags.Var((*listFlag)(&cfg.Regions), "regions", "comma separated regions, or auto to discover them (REGIONS)")
+	flags.Var((*listFlag)(&cfg.Zones), "zones", "comma separated zones, or auto to discover them (ZONES)")
+	flags.Var((*listFlag)(&cfg.LocationInclude), "location-include", "comma separated region and zone patterns to include (LOCATION_INCLUDE)")
+	flags.Var((*listFlag)(&cfg.LocationExclude), "location-exclude", "comma separated region and zone patterns to exclude (LOCATION_EXCLUDE)")
+	flags.Var((*listFlag)(&cfg.Projects), "projects", "comma separated project ids to export, all when empty (PROJECTS)")
+	flags.Func("project-include", "comma separated regular expressions, export only the projects whose ID or name matches one (PROJECT_INCLUDE)", func(v string) error {
+		cfg.ProjectFilter().Include = strings.Split(v, ",")
+		return nil
+	})
+	flags.Func("project-exclude", "comma separated regular expressions, skip the projects whose ID or name matches one (PROJECT_EXCLUDE)", func(v string) error {
+		cfg.ProjectFilter().Exclude = strings.Split(v, ",")
+		return nil
+	})
+	flags.Func("project-labels", "comma separated label selectors such as env=prod, env!=dev, team or !team (PROJECT_LABELS)", func(v string) error {
+		cfg.ProjectFilter().Labels = strings.Split(v, ",")
+		return nil
+	})
+	flags.Func("project-folders", "comma separated folder ids, export only the projects under them (PROJECT_FOLDERS)", func(v string) error {
+		cfg.ProjectFilter().Folders = strings.Split(v, ",")
+		return nil
+	})
+	flags.Func("project-states", "comma separated lifecycle states to export, ACTIVE by default (PROJECT_STATES)", func(v string) error {
+		cfg.ProjectFilter().States = strings.Split(v, ",")
+		return nil
+	})
+	flags.Var((*listFlag)(&cfg.Collectors), "collectors", "comma separated collectors to run, all when empty (COLLECTORS)")
+	flags.Var((*listFlag)(&cfg.AssetCollectors), "asset-collectors", "comma separated collectors that read from Cloud Asset Inventory (ASSET_COLLECTORS)")
+	flags.StringVar(&cfg.OutputFormat, "format", cfg.OutputFormat, fmt.Sprintf("output format, one of %s (OUTPUT_FORMAT)", strings.Join(output.Names(), ", ")))
+	flags.StringVar(&cfg.CSVDelimiter, "csv-delimiter", cfg.CSVDelimiter, `CSV field delimiter, a single character or "tab" (CSV_DELIMITER)`)
+	flags.BoolVar(&cfg.CSVBOM, "csv-bom", cfg.CSVBOM, "start the CSV files with a UTF-8 byte order mark (CSV_BOM)")
+	flags.IntVar(&cfg.MaxErrors, "max-errors", cfg.MaxErrors, "fail when collection errors exceed this count, -1 for no limit (MAX_ERRORS)")
+	flags.BoolVar(&cfg.ParallelCollectors, "parallel", cfg.ParallelCollectors, "run the collectors in parallel (PARALLEL_COLLECTORS)")
+	flags.StringVar(&cfg.APIEndpoint, "api-endpoint", cfg.APIEndpoint, "send API requests to this endpoint instead of Google APIs (API_ENDPOINT)")
+}
+
+// configFile returns the value of the -config flag found in args, falling back
+// to CONFIG_FILE. It is read before the other flags are bound, since their
+// defaults come from the loaded config.
+func configFile(args []string) string {
+	for i, arg := range args {
+		name := strings.TrimLeft(arg, "-")
+		if arg == name {
+			continue
+		}
+		if name == "config" && i+1 < len(args) {
+			return args[i+1]
+		}
+		if strings.HasPrefix(name, "config=") {
+			return strings.TrimPrefix(name, "config=")
+		}
+	}
+	return os.Getenv(config.ConfigFileEnv)
+}
+
+// parseConfig loads the config and parses args, with the config flags
+// overriding the loaded values.
+func parseConfig(flags *flag.FlagSet, args []string) (*config.Config, error) {
+	cfg, err := config.Load(configFile(args))
+	if err != nil {
+		return nil, err
+	}
+	flags.String("config", "", "YAML or JSON config file (CONFIG_FILE)")
+	bindConfigFlags(flags, cfg)
+	if err := flags.Parse(args); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+func runConfig(args []string) error {
+	flags := flag.NewFlagSet("config", flag.ExitOnError)
+	cfg, err := parseConfig(flags, args)
+	if err != nil {
+		return err
+	}
+	data, err := cfg.YAML()
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stdout.Write(data); err != nil {
+		return err
+	}
+	if err := cfg.ValidateCollect(); err != nil {
+		fmt.Fprintf(os.Stderr, "warning: %s\n", err.Error())
+	}
+	return nil
+}
+
+func runExport(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	out := flags.String("out", "", `output file, "-" for stdout (default inventory-<time>.<format>)`)
+	logLevel := "info"
+	flags.Func("log-level", "log level written to stderr (default info)", func(v string) error {
+		if err := logger.ValidateLevel(v); err != nil {
+			return err
+		}
+		logLevel = v
+		return nil
+	})
+	cfg, err := parseConfig(flags, args)
+	if err != nil {
+		return err
+	}
+	if err := cfg.ValidateCollect(); err != nil {
+		return err
+	}
+	format, err := output.ForConfig(cfg)
+	if err != nil {
+		return err
+	}
+	log := logger.NewLogger("inventory", logLevel)
+	snapshot, err := exporter.NewExporter(cfg, log).Collect(ctx)
+	if err != nil {
+		return err
+	}
+	fileName := *out
+	if fileName == "" {
+		fileName = output.ObjectName(snapshot, format)
+	}
+	if err := writeFile(fileName, func(w io.Writer) error {
+		return format.Write(w, snapshot)
+	}); err != nil {
+		return err
+	}
+	log.Infof("Inventory written to %s with %s", fileName, snapshot.Summary())
+	return nil
+}
+
+func runDiff(args []string) error {
+	flags := flag.NewFlagSet("diff", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: inventory diff [flags] <from.xlsx> <to.xlsx>\n")
+		flags.PrintDefaults()
+	}
+	formatName := flags.String("format", "xlsx", fmt.Sprintf("changes format, one of %s", strings.Join(diff.RendererNames(), ", ")))
+	out := flags.String("out", "", `output file, "-" for stdout (default changes-<to>.<format>)`)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 2 {
+		flags.Usage()
+		return fmt.Errorf("expected 2 exports, got %d", flags.NArg())
+	}
+	renderer, err := diff.GetRenderer(*formatName)
+	if err != nil {
+		return err
+	}
+	keys := diff.Keys()
+	var sheets [2][]*diff.Sheet
+	for i, fileName := range flags.Args() {
+		file, err := os.Open(fileName)
+		if err != nil {
+			return err
+		}
+		sheets[i], err = diff.ReadXlsx(file, keys)
+		file.Close()
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %s", fileName, err.Error())
+		}
+	}
+	changes := diff.Compare(exportID(flags.Arg(0)), sheets[0], exportID(flags.Arg(1)), sheets[1])
+	fileName := *out
+	if fileName == "" {
+		fileName = diff.ObjectName(changes, renderer)
+	}
+	if err := writeFile(fileName, func(w io.Writer) error {
+		return renderer.Write(w, changes)
+	}); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "%s written to %s\n", changes.Summary(), fileName)
+	return nil
+}
+
+// exportID returns the run id of an export file name, e.g.
+// 2023-03-01-10-00-00 for inventory-2023-03-01-10-00-00.xlsx.
+func exportID(fileName string) string {
+	name := strings.TrimSuffix(filepath.Base(fileName), filepath.Ext(fileName))
+	return strings.TrimPrefix(name, output.ObjectPrefix)
+}
+
+// writeFile calls write with the named file, or with stdout for "-". The file
+// is written to a temporary file next to it and renamed once complete, so a
+// failed write leaves no partial file behind.
+func writeFile(fileName string, write func(w io.Writer) error) error {
+	if fileName == "-" {
+		return write(os.Stdout)
+	}
+	file, err := os.CreateTemp(filepath.Dir(fileName), "."+filepath.Base(fileName)+"-*")
+	if err != nil {
+		return err
+	}
+	// Removing the temporary file fails harmlessly once it is renamed.
+	defer os.Remove(file.Name())
+	// CreateTemp makes the file readable by its owner only.
+	if err := file.Chmod(0o644); err != nil {
+		file.Close()
+		return err
+	}
+	if err := write(file); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), fileName)
+}
@@ -0,0 +1,28 @@
+package collector
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/liornabat/gcp_inventory_exporter/inventory"
+	"github.com/liornabat/gcp_inventory_exporter/project"
+)
+
+// Asset is a resource read from Cloud Asset Inventory. Data holds the
+// resource as returned by its own API, e.g. a compute#instance.
+type Asset struct {
+	Project  *project.Project
+	Type     string
+	Name     string
+	Location string
+	Data     json.RawMessage
+}
+
+// AssetCollector is a Collector that can also build its resources from Cloud
+// Asset Inventory assets, instead of calling the API of every project.
+type AssetCollector interface {
+	Collector
+	// AssetTypes are the asset types the collector reads, e.g.
+	// "compute.googleapis.com/Instance".
+	AssetTypes() []string
+	CollectAssets(ctx context.Context, assets []*Asset) ([]*inventory.Resource, error)
+}
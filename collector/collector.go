@@ -0,0 +1,85 @@
+package collector
+
+import (
+	"context"
+	"github.com/liornabat/gcp_inventory_exporter/config"
+	"github.com/liornabat/gcp_inventory_exporter/inventory"
+	"github.com/liornabat/gcp_inventory_exporter/pkg/gcpclient"
+	"github.com/liornabat/gcp_inventory_exporter/pkg/limiter"
+	"github.com/liornabat/gcp_inventory_exporter/pkg/logger"
+	"github.com/liornabat/gcp_inventory_exporter/pkg/retry"
+	"github.com/liornabat/gcp_inventory_exporter/project"
+)
+
+type Collector interface {
+	Name() string
+	Sheet() string
+	Schema() *inventory.Schema
+	RequiredAPIs() []string
+	Collect(ctx context.Context, projects []*project.Project) ([]*inventory.Resource, error)
+}
+
+// Locations returns the regions and zones to inventory in a project.
+type Locations interface {
+	Regions(ctx context.Context, p *project.Project) []string
+	Zones(ctx context.Context, p *project.Project) []string
+	MatchRegion(ctx context.Context, p *project.Project, region string) bool
+	MatchZone(ctx context.Context, p *project.Project, zone string) bool
+}
+
+type Options struct {
+	Config    *config.Config
+	Log       *logger.Logger
+	Errors    *inventory.Errors
+	Limiter   *limiter.Limiter
+	Retrier   *retry.Retrier
+	Clients   *gcpclient.Options
+	Locations Locations
+}
+
+type Factory func(opts *Options) Collector
+
+// Call runs an API request through the retrier and the shared limiter, method
+// being the API method name, e.g. "compute.instances.list". fn is called again
+// on retryable errors, so it must discard the results of a failed attempt.
+func (o *Options) Call(ctx context.Context, method string, fn func() error) error {
+	return o.Retrier.Do(ctx, method, func() error {
+		return o.Limiter.Do(ctx, method, fn)
+	})
+}
+
+// Regions returns the regions to inventory in the project, falling back to
+// the configured regions when no Locations is set.
+func (o *Options) Regions(ctx context.Context, p *project.Project) []string {
+	if o.Locations == nil {
+		return o.Config.Regions
+	}
+	return o.Locations.Regions(ctx, p)
+}
+
+// Zones returns the zones to inventory in the project, falling back to the
+// configured zones when no Locations is set.
+func (o *Options) Zones(ctx context.Context, p *project.Project) []string {
+	if o.Locations == nil {
+		return o.Config.Zones
+	}
+	return o.Locations.Zones(ctx, p)
+}
+
+// MatchRegion reports whether resources listed across regions, e.g. by an
+// aggregated list, in region should be inventoried in the project.
+func (o *Options) MatchRegion(ctx context.Context, p *project.Project, region string) bool {
+	if o.Locations == nil {
+		return contains(o.Config.Regions, region)
+	}
+	return o.Locations.MatchRegion(ctx, p, region)
+}
+
+// MatchZone reports whether resources listed across zones, e.g. by an
+// aggregated list, in zone should be inventoried in the project.
+func (o *Options) MatchZone(ctx context.Context, p *project.Project, zone string) bool {
+	if o.Locations == nil {
+		return contains(o.Config.Zones, zone)
+	}
+	return o.Locations.MatchZone(ctx, p, zone)
+}
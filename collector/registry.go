@@ -0,0 +1,85 @@
+package collector
+
+import (
+	"fmt"
+	"sync"
+)
+
+type registration struct {
+	name    string
+	factory Factory
+}
+
+var (
+	registryMutex sync.RWMutex
+	registry      []*registration
+)
+
+// Register makes a collector available by name. Collectors are run in the
+// order they were registered. It panics if the same name is registered twice.
+func Register(name string, factory Factory) {
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+	if factory == nil {
+		panic(fmt.Sprintf("collector %s: register factory is nil", name))
+	}
+	for _, r := range registry {
+		if r.name == name {
+			panic(fmt.Sprintf("collector %s: registered twice", name))
+		}
+	}
+	registry = append(registry, &registration{
+		name:    name,
+		factory: factory,
+	})
+}
+
+func Names() []string {
+	registryMutex.RLock()
+	defer registryMutex.RUnlock()
+	var names []string
+	for _, r := range registry {
+		names = append(names, r.name)
+	}
+	return names
+}
+
+func IsRegistered(name string) bool {
+	registryMutex.RLock()
+	defer registryMutex.RUnlock()
+	for _, r := range registry {
+		if r.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Enabled builds the collectors listed in names, keeping registration order.
+// An empty list enables every registered collector.
+func Enabled(names []string, opts *Options) ([]Collector, error) {
+	for _, name := range names {
+		if !IsRegistered(name) {
+			return nil, fmt.Errorf("unknown collector %s", name)
+		}
+	}
+	registryMutex.RLock()
+	defer registryMutex.RUnlock()
+	var collectors []Collector
+	for _, r := range registry {
+		if len(names) > 0 && !contains(names, r.name) {
+			continue
+		}
+		collectors = append(collectors, r.factory(opts))
+	}
+	return collectors, nil
+}
+
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
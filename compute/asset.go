@@ -0,0 +1,36 @@
+package compute
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/liornabat/gcp_inventory_exporter/collector"
+	"github.com/liornabat/gcp_inventory_exporter/inventory"
+	"google.golang.org/api/compute/v1"
+)
+
+const InstanceAssetType = "compute.googleapis.com/Instance"
+
+// GetComputeAssetInventory builds the compute inventory from instance assets.
+// The machine types are still listed per zone, once for each zone that has
+// instances.
+func GetComputeAssetInventory(ctx context.Context, assets []*collector.Asset, opts *collector.Options) ([]*inventory.Resource, error) {
+	var resources []*inventory.Resource
+	machineTypes := map[string]MachineTypes{}
+	for _, asset := range assets {
+		instance := &compute.Instance{}
+		if err := json.Unmarshal(asset.Data, instance); err != nil {
+			opts.Errors.Add(inventory.NewError(InstanceCollectorName, asset.Project.ID, asset.Location, "cloudasset.assets.list", err))
+			continue
+		}
+		zone := removeUrlPrefix(instance.Zone)
+		if !opts.MatchZone(ctx, asset.Project, zone) {
+			continue
+		}
+		key := asset.Project.ID + "/" + zone
+		if _, ok := machineTypes[key]; !ok {
+			machineTypes[key] = FetchMachineTypes(ctx, asset.Project.ID, zone, opts)
+		}
+		resources = append(resources, newInstanceResource(asset.Project, zone, instance, machineTypes[key]))
+	}
+	return resources, nil
+}
@@ -0,0 +1,52 @@
+package compute
+
+import (
+	"context"
+	"github.com/liornabat/gcp_inventory_exporter/collector"
+	"github.com/liornabat/gcp_inventory_exporter/inventory"
+	"github.com/liornabat/gcp_inventory_exporter/project"
+)
+
+const InstanceCollectorName = "compute"
+
+func init() {
+	collector.Register(InstanceCollectorName, NewInstanceCollector)
+}
+
+type InstanceCollector struct {
+	opts *collector.Options
+}
+
+func NewInstanceCollector(opts *collector.Options) collector.Collector {
+	return &InstanceCollector{
+		opts: opts,
+	}
+}
+
+func (c *InstanceCollector) Name() string {
+	return InstanceCollectorName
+}
+
+func (c *InstanceCollector) Sheet() string {
+	return "Compute"
+}
+
+func (c *InstanceCollector) Schema() *inventory.Schema {
+	return InstanceSchema
+}
+
+func (c *InstanceCollector) RequiredAPIs() []string {
+	return []string{"compute.googleapis.com"}
+}
+
+func (c *InstanceCollector) Collect(ctx context.Context, projects []*project.Project) ([]*inventory.Resource, error) {
+	return GetComputeInventory(ctx, projects, c.opts)
+}
+
+func (c *InstanceCollector) AssetTypes() []string {
+	return []string{InstanceAssetType}
+}
+
+func (c *InstanceCollector) CollectAssets(ctx context.Context, assets []*collector.Asset) ([]*inventory.Resource, error) {
+	return GetComputeAssetInventory(ctx, assets, c.opts)
+}
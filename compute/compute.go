@@ -3,93 +3,108 @@ package compute
 import (
 	"context"
 	"fmt"
-	"github.com/liornabat/gcp_inventory_exporter/config"
-	"github.com/liornabat/gcp_inventory_exporter/pkg/logger"
+	"github.com/liornabat/gcp_inventory_exporter/collector"
+	"github.com/liornabat/gcp_inventory_exporter/inventory"
+	"github.com/liornabat/gcp_inventory_exporter/pkg/gcpclient"
 	"github.com/liornabat/gcp_inventory_exporter/project"
 	"google.golang.org/api/compute/v1"
 	"strings"
 	"sync"
 )
 
-var computeHeader = []string{
-	"Project",
-	"Zone",
-	"Name",
-	"Status",
-	"Machine Type",
-	"CPU",
-	"Memory (MB)",
-	"IP Address",
-	"Disks (GB)",
-	"Creation Time",
+const InstanceKind = "compute#instance"
+
+var InstanceSchema = &inventory.Schema{
+	Kind: InstanceKind,
+	Key:  []string{"Project ID", "Zone", "Name"},
+	Columns: []inventory.Column{
+		{Name: "Project", Type: inventory.StringColumn},
+		{Name: "Project ID", Type: inventory.StringColumn},
+		{Name: "Zone", Type: inventory.StringColumn},
+		{Name: "Name", Type: inventory.StringColumn},
+		{Name: "Status", Type: inventory.StringColumn},
+		{Name: "Machine Type", Type: inventory.StringColumn},
+		{Name: "CPU", Type: inventory.IntColumn},
+		{Name: "Memory (MB)", Type: inventory.IntColumn},
+		{Name: "IP Address", Type: inventory.StringListColumn, Separator: ", "},
+		{Name: "Disks (GB)", Type: inventory.StringListColumn, Separator: ", "},
+		{Name: "Creation Time", Type: inventory.TimestampColumn},
+	},
 }
 
-func getNetworkInterfaces(instance *compute.Instance) string {
+func getNetworkInterfaces(instance *compute.Instance) []string {
 	var networkInterfaces []string
 	for _, networkInterface := range instance.NetworkInterfaces {
 		networkInterfaces = append(networkInterfaces, networkInterface.NetworkIP)
 	}
-	return strings.Join(networkInterfaces, ", ")
+	return networkInterfaces
 }
-func getDisksSizes(instance *compute.Instance) string {
+func getDisksSizes(instance *compute.Instance) []string {
 	var disksSizes []string
 	for _, disk := range instance.Disks {
 		disksSizes = append(disksSizes, fmt.Sprintf("%dGB", disk.DiskSizeGb))
 	}
-	return strings.Join(disksSizes, ", ")
+	return disksSizes
 }
 func removeUrlPrefix(url string) string {
 	return strings.Split(url, "/")[len(strings.Split(url, "/"))-1]
 }
-func GetComputeInventory(ctx context.Context, projectsId []*project.Project, zones config.Zones, log *logger.Logger) ([][]string, error) {
+
+func newInstanceResource(projectId *project.Project, zone string, instance *compute.Instance, machineTypes MachineTypes) *inventory.Resource {
+	mt := removeUrlPrefix(instance.MachineType)
+	r := inventory.NewResource(InstanceKind, projectId.ID, zone, instance.Name).
+		Set("Project", projectId.Name).
+		Set("Project ID", projectId.ID).
+		Set("Zone", zone).
+		Set("Name", instance.Name).
+		Set("Status", instance.Status).
+		Set("Machine Type", mt).
+		Set("IP Address", getNetworkInterfaces(instance)).
+		Set("Disks (GB)", getDisksSizes(instance)).
+		Set("Creation Time", inventory.ParseTimestamp(instance.CreationTimestamp)).
+		SetLabels(instance.Labels).
+		SetRaw(instance)
+	if machineType := machineTypes[mt]; machineType != nil {
+		r.Set("CPU", machineType.CPU)
+		r.Set("Memory (MB)", machineType.Memory)
+	}
+	return r
+}
+
+func GetComputeInventory(ctx context.Context, projectsId []*project.Project, opts *collector.Options) ([]*inventory.Resource, error) {
+	log := opts.Log
 	log.Infof("Getting compute inventory")
 	defer log.Infof("Done getting compute inventory")
-	service, err := compute.NewService(ctx)
+	service, err := compute.NewService(ctx, opts.Clients.For(gcpclient.Compute)...)
 	if err != nil {
 		return nil, err
 	}
-	var inventory [][]string
-	inventory = append(inventory, computeHeader)
+	var resources []*inventory.Resource
 	mutex := &sync.Mutex{}
 	wg := &sync.WaitGroup{}
 	wg.Add(len(projectsId))
 	for _, projectId := range projectsId {
 		go func(projectId *project.Project) {
 			defer wg.Done()
-			var localInventory [][]string
+			var localResources []*inventory.Resource
 			log.Infof("Getting compute inventory for project %s", projectId.Name)
-			for _, zone := range zones {
-				log.Infof("Getting compute inventory for compute instances in zone %s", zone)
-				instances, err := service.Instances.List(projectId.ID, zone).Do()
-				if err != nil {
-					log.Errorf("Failed to get compute inventory for project %s and zone %s, error: %s", projectId.Name, zone, err.Error())
-					continue
-				}
-
-				machineTypes := FetchMachineTypes(ctx, projectId.ID, zone, log)
-
-				for _, instance := range instances.Items {
-					mt := removeUrlPrefix(instance.MachineType)
-					localInventory = append(localInventory, []string{
-						projectId.Name,
-						zone,
-						instance.Name,
-						instance.Status,
-						mt,
-						machineTypes.GetCPU(mt),
-						machineTypes.GetMemory(mt),
-						getNetworkInterfaces(instance),
-						getDisksSizes(instance),
-						instance.CreationTimestamp,
-					})
+			zones, err := ListInstances(ctx, service, projectId, opts)
+			if err != nil {
+				log.Errorf("Failed to get compute inventory for project %s, error: %s", projectId.Name, err.Error())
+				opts.Errors.Add(inventory.NewError(InstanceCollectorName, projectId.ID, "", "compute.instances.aggregatedList", err))
+			}
+			for _, zone := range zones.Names() {
+				machineTypes := FetchMachineTypes(ctx, projectId.ID, zone, opts)
+				for _, instance := range zones[zone] {
+					localResources = append(localResources, newInstanceResource(projectId, zone, instance, machineTypes))
 				}
 			}
 			mutex.Lock()
-			inventory = append(inventory, localInventory...)
+			resources = append(resources, localResources...)
 			mutex.Unlock()
 			log.Infof("Done getting compute inventory for project %s", projectId.Name)
 		}(projectId)
 	}
 	wg.Wait()
-	return inventory, nil
+	return resources, nil
 }
@@ -0,0 +1,49 @@
+package compute
+
+import (
+	"context"
+	"github.com/liornabat/gcp_inventory_exporter/collector"
+	"github.com/liornabat/gcp_inventory_exporter/project"
+	"google.golang.org/api/compute/v1"
+	"sort"
+	"strings"
+)
+
+// ZoneInstances holds the instances of a project by zone name.
+type ZoneInstances map[string][]*compute.Instance
+
+// Names returns the zones that have instances, sorted.
+func (z ZoneInstances) Names() []string {
+	var names []string
+	for name := range z {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ListInstances lists the instances of all the project zones in one
+// aggregated request, following every page, and keeps the zones that match
+// the configured zones.
+func ListInstances(ctx context.Context, service *compute.Service, projectId *project.Project, opts *collector.Options) (ZoneInstances, error) {
+	opts.Log.Infof("Getting compute instances for project %s with Aggregated List", projectId.Name)
+	var zones ZoneInstances
+	req := service.Instances.AggregatedList(projectId.ID)
+	err := opts.Call(ctx, "compute.instances.aggregatedList", func() error {
+		zones = ZoneInstances{}
+		return req.Pages(ctx, func(page *compute.InstanceAggregatedList) error {
+			for scope, item := range page.Items {
+				zone := strings.TrimPrefix(scope, "zones/")
+				if len(item.Instances) == 0 || !opts.MatchZone(ctx, projectId, zone) {
+					continue
+				}
+				zones[zone] = append(zones[zone], item.Instances...)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return zones, nil
+}
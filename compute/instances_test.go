@@ -0,0 +1,115 @@
+package compute
+
+import (
+	"context"
+	"fmt"
+	"github.com/liornabat/gcp_inventory_exporter/collector"
+	"github.com/liornabat/gcp_inventory_exporter/config"
+	"github.com/liornabat/gcp_inventory_exporter/inventory"
+	"github.com/liornabat/gcp_inventory_exporter/pkg/fakegcp"
+	"github.com/liornabat/gcp_inventory_exporter/pkg/gcpclient"
+	"github.com/liornabat/gcp_inventory_exporter/pkg/logger"
+	"github.com/liornabat/gcp_inventory_exporter/pkg/retry"
+	"github.com/liornabat/gcp_inventory_exporter/project"
+	"google.golang.org/api/compute/v1"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var demoProject = &project.Project{ID: "demo-project", Name: "Demo Project"}
+
+// listInstances lists the instances of demo-project from the default
+// fixtures, whose aggregated list has a page per instance. The second page
+// fails with the given status the first failures times it is requested.
+func listInstances(t *testing.T, zones config.Zones, status int, failures int32) (ZoneInstances, *collector.Options, error) {
+	t.Helper()
+	srv := fakegcp.NewServer(nil)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("pageToken") == "page-2" && atomic.AddInt32(&failures, -1) >= 0 {
+			http.Error(w, fmt.Sprintf(`{"error": {"code": %d, "message": %q}}`, status, http.StatusText(status)), status)
+			return
+		}
+		srv.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+	cfg := config.NewConfig()
+	cfg.Zones = zones
+	log := logger.NewLogger("test", "error")
+	opts := &collector.Options{
+		Config:  cfg,
+		Log:     log,
+		Errors:  inventory.NewErrors(),
+		Retrier: retry.NewRetrier(&retry.Policy{MaxAttempts: 3, InitialBackoff: time.Millisecond, Multiplier: 2}, log),
+		Clients: gcpclient.NewOptions(server.URL),
+	}
+	service, err := compute.NewService(context.Background(), opts.Clients.For(gcpclient.Compute)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	instances, err := ListInstances(context.Background(), service, demoProject, opts)
+	return instances, opts, err
+}
+
+// instanceNames returns the names of the instances of each zone.
+func instanceNames(zones ZoneInstances) map[string][]string {
+	names := map[string][]string{}
+	for zone, instances := range zones {
+		for _, instance := range instances {
+			names[zone] = append(names[zone], instance.Name)
+		}
+	}
+	return names
+}
+
+func TestListInstances(t *testing.T) {
+	tests := []struct {
+		name     string
+		zones    config.Zones
+		failures int32
+		want     map[string][]string
+		retries  int
+	}{
+		{
+			name:  "all pages",
+			zones: config.Zones{"me-west1-a", "me-west1-b", "me-west1-c"},
+			want:  map[string][]string{"me-west1-a": {"web-1", "db-1"}},
+		},
+		{
+			name:  "zones that are not configured are left out",
+			zones: config.Zones{"me-west1-b"},
+			want:  map[string][]string{},
+		},
+		{
+			name:     "a retried page does not repeat the first page",
+			zones:    config.Zones{"me-west1-a"},
+			failures: 1,
+			want:     map[string][]string{"me-west1-a": {"web-1", "db-1"}},
+			retries:  1,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			zones, opts, err := listInstances(t, test.zones, http.StatusServiceUnavailable, test.failures)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := instanceNames(zones); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got instances %v, expected %v", got, test.want)
+			}
+			if count := opts.Retrier.Counts()["compute.instances.aggregatedList"]; count != test.retries {
+				t.Errorf("got %d retries, expected %d", count, test.retries)
+			}
+		})
+	}
+}
+
+func TestListInstancesPageError(t *testing.T) {
+	zones, _, err := listInstances(t, config.Zones{"me-west1-a"}, http.StatusForbidden, 1)
+	if err == nil || zones != nil {
+		t.Errorf("got instances %v and error %v, expected a failed list", instanceNames(zones), err)
+	}
+}
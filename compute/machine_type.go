@@ -2,8 +2,9 @@ package compute
 
 import (
 	"context"
-	"fmt"
-	"github.com/liornabat/gcp_inventory_exporter/pkg/logger"
+	"github.com/liornabat/gcp_inventory_exporter/collector"
+	"github.com/liornabat/gcp_inventory_exporter/inventory"
+	"github.com/liornabat/gcp_inventory_exporter/pkg/gcpclient"
 	"google.golang.org/api/compute/v1"
 )
 
@@ -15,16 +16,24 @@ type machineType struct {
 
 type MachineTypes map[string]*machineType
 
-func FetchMachineTypes(ctx context.Context, projectsId string, zone string, log *logger.Logger) MachineTypes {
+func FetchMachineTypes(ctx context.Context, projectsId string, zone string, opts *collector.Options) MachineTypes {
+	log := opts.Log
 	log.Infof("Getting Machine Types inventory for project %s and zone %s", projectsId, zone)
 	defer log.Infof("Done getting Machine Types inventory for project %s and zone %s", projectsId, zone)
 	machineTypes := make(MachineTypes)
-	service, err := compute.NewService(ctx)
+	service, err := compute.NewService(ctx, opts.Clients.For(gcpclient.Compute)...)
 	if err != nil {
 		return machineTypes
 	}
-	mt, err := service.MachineTypes.List(projectsId, zone).Do()
+	var mt *compute.MachineTypeList
+	err = opts.Call(ctx, "compute.machineTypes.list", func() error {
+		var err error
+		mt, err = service.MachineTypes.List(projectsId, zone).Context(ctx).Do()
+		return err
+	})
 	if err != nil {
+		log.Errorf("Failed to get machine types for project %s and zone %s, error: %s", projectsId, zone, err.Error())
+		opts.Errors.Add(inventory.NewError(InstanceCollectorName, projectsId, zone, "compute.machineTypes.list", err))
 		return machineTypes
 	}
 	for _, item := range mt.Items {
@@ -36,16 +45,3 @@ func FetchMachineTypes(ctx context.Context, projectsId string, zone string, log
 	}
 	return machineTypes
 }
-func (m MachineTypes) GetCPU(name string) string {
-	if m[name] != nil {
-		return fmt.Sprintf("%d", m[name].CPU)
-	}
-	return ""
-}
-
-func (m MachineTypes) GetMemory(name string) string {
-	if m[name] != nil {
-		return fmt.Sprintf("%d", m[name].Memory)
-	}
-	return ""
-}
@@ -1,60 +1,321 @@
 package config
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
-	"os"
-	"strings"
+	"github.com/liornabat/gcp_inventory_exporter/pkg/csv"
+	"github.com/liornabat/gcp_inventory_exporter/pkg/retention"
+	"github.com/liornabat/gcp_inventory_exporter/pkg/retry"
+	"github.com/liornabat/gcp_inventory_exporter/project"
+	"path"
+	"regexp"
+	"time"
 )
 
 type Config struct {
-	OrgId            string
-	Regions          Regions
-	Zones            Zones
-	ExportProjectId  string
-	ExportBucketName string
+	OrgId                       string          `json:"orgId" yaml:"orgId"`
+	Regions                     Regions         `json:"regions" yaml:"regions"`
+	Zones                       Zones           `json:"zones" yaml:"zones"`
+	ExportProjectId             string          `json:"exportProjectId" yaml:"exportProjectId"`
+	ExportBucketName            string          `json:"exportBucketName" yaml:"exportBucketName"`
+	Collectors                  []string        `json:"collectors" yaml:"collectors"`
+	MaxErrors                   int             `json:"maxErrors" yaml:"maxErrors"`
+	MaxConcurrentRequests       int             `json:"maxConcurrentRequests" yaml:"maxConcurrentRequests"`
+	MaxConcurrentRequestsPerAPI int             `json:"maxConcurrentRequestsPerApi" yaml:"maxConcurrentRequestsPerApi"`
+	ParallelCollectors          bool            `json:"parallelCollectors" yaml:"parallelCollectors"`
+	RetryMaxAttempts            int             `json:"retryMaxAttempts" yaml:"retryMaxAttempts"`
+	RetryInitialBackoff         time.Duration   `json:"retryInitialBackoff" yaml:"retryInitialBackoff"`
+	RetryMaxBackoff             time.Duration   `json:"retryMaxBackoff" yaml:"retryMaxBackoff"`
+	APIEndpoint                 string          `json:"apiEndpoint" yaml:"apiEndpoint"`
+	OutputFormat                string          `json:"outputFormat" yaml:"outputFormat"`
+	Projects                    []string        `json:"projects" yaml:"projects"`
+	LocationInclude             []string        `json:"locationInclude" yaml:"locationInclude"`
+	LocationExclude             []string        `json:"locationExclude" yaml:"locationExclude"`
+	ProjectFilters              []ProjectFilter `json:"projectFilters" yaml:"projectFilters"`
+	AssetCollectors             []string        `json:"assetCollectors" yaml:"assetCollectors"`
+	RetentionKeepLast           int             `json:"retentionKeepLast" yaml:"retentionKeepLast"`
+	RetentionKeepDaily          int             `json:"retentionKeepDaily" yaml:"retentionKeepDaily"`
+	RetentionKeepMonthly        int             `json:"retentionKeepMonthly" yaml:"retentionKeepMonthly"`
+	RetentionDryRun             bool            `json:"retentionDryRun" yaml:"retentionDryRun"`
+	DiffPrevious                bool            `json:"diffPrevious" yaml:"diffPrevious"`
+	DiffFormat                  string          `json:"diffFormat" yaml:"diffFormat"`
+	CSVDelimiter                string          `json:"csvDelimiter" yaml:"csvDelimiter"`
+	CSVBOM                      bool            `json:"csvBom" yaml:"csvBom"`
+	ParquetAlongside            bool            `json:"parquetAlongside" yaml:"parquetAlongside"`
+	BigQueryProjectId           string          `json:"bigQueryProjectId" yaml:"bigQueryProjectId"`
+	BigQueryDataset             string          `json:"bigQueryDataset" yaml:"bigQueryDataset"`
+	BigQueryLocation            string          `json:"bigQueryLocation" yaml:"bigQueryLocation"`
+	BigQueryEndpoint            string          `json:"bigQueryEndpoint" yaml:"bigQueryEndpoint"`
+	// RegionFilter and ZoneFilter narrow the regions and zones discovered in
+	// auto mode, e.g. to the regions and zones of an export request.
+	RegionFilter []string `json:"regionFilter,omitempty" yaml:"regionFilter,omitempty"`
+	ZoneFilter   []string `json:"zoneFilter,omitempty" yaml:"zoneFilter,omitempty"`
+}
+
+// ProjectFilter selects the projects to export, see project.NewFilter. A
+// project is exported only when it passes every filter of ProjectFilters.
+type ProjectFilter struct {
+	Include []string `json:"include,omitempty" yaml:"include,omitempty"`
+	Exclude []string `json:"exclude,omitempty" yaml:"exclude,omitempty"`
+	Labels  []string `json:"labels,omitempty" yaml:"labels,omitempty"`
+	Folders []string `json:"folders,omitempty" yaml:"folders,omitempty"`
+	States  []string `json:"states,omitempty" yaml:"states,omitempty"`
+}
+
+func (f *ProjectFilter) Filter() (*project.Filter, error) {
+	return project.NewFilter(f.Include, f.Exclude, f.Labels, f.Folders, f.States)
 }
 
 func NewConfig() *Config {
 	return &Config{
-		OrgId:            "",
-		Regions:          nil,
-		Zones:            nil,
-		ExportProjectId:  "",
-		ExportBucketName: "",
+		OrgId:                       "",
+		Regions:                     DefaultRegions,
+		Zones:                       DefaultZones,
+		ExportProjectId:             "",
+		ExportBucketName:            "",
+		Collectors:                  nil,
+		MaxErrors:                   -1,
+		MaxConcurrentRequests:       20,
+		MaxConcurrentRequestsPerAPI: 10,
+		ParallelCollectors:          false,
+		RetryMaxAttempts:            5,
+		RetryInitialBackoff:         time.Second,
+		RetryMaxBackoff:             30 * time.Second,
+		APIEndpoint:                 "",
+		OutputFormat:                "xlsx",
+		Projects:                    nil,
+		LocationInclude:             nil,
+		LocationExclude:             nil,
+		ProjectFilters:              nil,
+		AssetCollectors:             nil,
+		RetentionKeepLast:           0,
+		RetentionKeepDaily:          0,
+		RetentionKeepMonthly:        0,
+		RetentionDryRun:             false,
+		DiffPrevious:                false,
+		DiffFormat:                  "xlsx",
+		CSVDelimiter:                ",",
+		CSVBOM:                      false,
+		ParquetAlongside:            false,
+		BigQueryProjectId:           "",
+		BigQueryDataset:             "",
+		BigQueryLocation:            "",
+		BigQueryEndpoint:            "",
+		RegionFilter:                nil,
+		ZoneFilter:                  nil,
+	}
+}
+
+func (c *Config) RetryPolicy() *retry.Policy {
+	policy := retry.DefaultPolicy()
+	policy.MaxAttempts = c.RetryMaxAttempts
+	policy.InitialBackoff = c.RetryInitialBackoff
+	policy.MaxBackoff = c.RetryMaxBackoff
+	return policy
+}
+
+// Hash identifies the settings of a run, so runs with the same config can be
+// told apart from the others.
+func (c *Config) Hash() string {
+	data, _ := json.Marshal(c)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// CSVDelimiterRune returns the CSV field delimiter, a single character or
+// "tab" or "\t" for a tab.
+func (c *Config) CSVDelimiterRune() (rune, error) {
+	switch c.CSVDelimiter {
+	case "":
+		return ',', nil
+	case "tab", `\t`:
+		return '\t', nil
+	}
+	runes := []rune(c.CSVDelimiter)
+	if len(runes) != 1 || !csv.ValidDelimiter(runes[0]) {
+		return 0, fmt.Errorf("must be a single character other than a quote or a line break, got %q", c.CSVDelimiter)
 	}
+	return runes[0], nil
 }
 
-var DefaultConfig = &Config{
-	OrgId:            os.Getenv("ORG_ID"),
-	Regions:          getStringListFromEnv("REGIONS"),
-	Zones:            getStringListFromEnv("ZONES"),
-	ExportProjectId:  os.Getenv("EXPORT_PROJECT_ID"),
-	ExportBucketName: os.Getenv("EXPORT_BUCKET_NAME"),
+var bigQueryDataset = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+
+// BigQueryEnabled reports whether the snapshots are appended to the tables of
+// a BigQuery dataset.
+func (c *Config) BigQueryEnabled() bool {
+	return c.BigQueryDataset != ""
+}
+
+// BigQueryProject returns the project of the BigQuery dataset, the export
+// project unless set.
+func (c *Config) BigQueryProject() string {
+	if c.BigQueryProjectId != "" {
+		return c.BigQueryProjectId
+	}
+	return c.ExportProjectId
+}
+
+// RetentionPolicy returns the policy pruning old exports from the bucket. It
+// keeps every export when no retention is configured.
+func (c *Config) RetentionPolicy() *retention.Policy {
+	return &retention.Policy{
+		KeepLast:    c.RetentionKeepLast,
+		KeepDaily:   c.RetentionKeepDaily,
+		KeepMonthly: c.RetentionKeepMonthly,
+		DryRun:      c.RetentionDryRun,
+	}
+}
+
+// MatchLocation reports whether a region or zone passes the LocationInclude
+// and LocationExclude glob patterns, e.g. "europe-*" or "us-central1-?".
+func (c *Config) MatchLocation(location string) bool {
+	if len(c.LocationInclude) > 0 && !matchAny(c.LocationInclude, location) {
+		return false
+	}
+	return !matchAny(c.LocationExclude, location)
+}
+
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+func matchAny(patterns []string, value string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, value); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// ProjectFilter returns the first project filter, which the PROJECT_*
+// environment variables override, adding it when there is none.
+func (c *Config) ProjectFilter() *ProjectFilter {
+	if len(c.ProjectFilters) == 0 {
+		c.ProjectFilters = append(c.ProjectFilters, ProjectFilter{})
+	}
+	return &c.ProjectFilters[0]
 }
 
-func getStringListFromEnv(key string) []string {
-	value := os.Getenv(key)
-	if value == "" {
-		return nil
+// Filters compiles the project filters. Without any filter, only the active
+// projects are exported.
+func (c *Config) Filters() ([]*project.Filter, error) {
+	projectFilters := c.ProjectFilters
+	if len(projectFilters) == 0 {
+		projectFilters = []ProjectFilter{{}}
 	}
-	return strings.Split(value, ",")
+	var filters []*project.Filter
+	for _, f := range projectFilters {
+		filter, err := f.Filter()
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, filter)
+	}
+	return filters, nil
+}
+
+// ErrorsExceeded reports whether the number of collection errors is above
+// MaxErrors. A negative MaxErrors disables the check.
+func (c *Config) ErrorsExceeded(count int) bool {
+	return c.MaxErrors >= 0 && count > c.MaxErrors
 }
 
 func (c *Config) Validate() error {
+	if err := c.ValidateCollect(); err != nil {
+		return err
+	}
+	if c.ExportProjectId == "" {
+		return missing("exportProjectId")
+	}
+	if c.ExportBucketName == "" {
+		return missing("exportBucketName")
+	}
+	if c.RetentionKeepLast < 0 {
+		return invalid("retentionKeepLast", "must not be negative, got %d", c.RetentionKeepLast)
+	}
+	if c.RetentionKeepDaily < 0 {
+		return invalid("retentionKeepDaily", "must not be negative, got %d", c.RetentionKeepDaily)
+	}
+	if c.RetentionKeepMonthly < 0 {
+		return invalid("retentionKeepMonthly", "must not be negative, got %d", c.RetentionKeepMonthly)
+	}
+	if c.DiffPrevious && c.DiffFormat == "" {
+		return missing("diffFormat")
+	}
+	// The changes are computed from the previous xlsx export, the only
+	// format the diff reads back.
+	if c.DiffPrevious && c.OutputFormat != "xlsx" {
+		return invalid("diffPrevious", "needs outputFormat xlsx, got %q", c.OutputFormat)
+	}
+	if c.BigQueryDataset != "" && (len(c.BigQueryDataset) > 1024 || !bigQueryDataset.MatchString(c.BigQueryDataset)) {
+		return invalid("bigQueryDataset", "must be at most 1024 letters, digits and underscores, got %q", c.BigQueryDataset)
+	}
+	return nil
+}
+
+// ValidateCollect checks only the settings needed to collect the inventory,
+// for runs that do not export to a bucket.
+func (c *Config) ValidateCollect() error {
 	if c.OrgId == "" {
-		return fmt.Errorf("ORG_ID is missing")
+		return missing("orgId")
 	}
 	if len(c.Regions) == 0 {
-		return fmt.Errorf("REGIONS is missing")
+		return missing("regions")
 	}
 	if len(c.Zones) == 0 {
-		return fmt.Errorf("ZONES is missing")
+		return missing("zones")
 	}
-	if c.ExportProjectId == "" {
-		return fmt.Errorf("EXPORT_PROJECT_ID is missing")
+	if !c.Regions.IsAuto() && contains(c.Regions, Auto) {
+		return invalid("regions", "must be either %q or a list of regions", Auto)
 	}
-	if c.ExportBucketName == "" {
-		return fmt.Errorf("EXPORT_BUCKET_NAME is missing")
+	if !c.Zones.IsAuto() && contains(c.Zones, Auto) {
+		return invalid("zones", "must be either %q or a list of zones", Auto)
+	}
+	if c.RetryMaxAttempts < 1 {
+		return invalid("retryMaxAttempts", "must be at least 1, got %d", c.RetryMaxAttempts)
+	}
+	if c.RetryInitialBackoff <= 0 {
+		return invalid("retryInitialBackoff", "must be positive, got %s", c.RetryInitialBackoff)
+	}
+	if c.RetryMaxBackoff < c.RetryInitialBackoff {
+		return invalid("retryMaxBackoff", "must not be less than retryInitialBackoff %s, got %s", c.RetryInitialBackoff, c.RetryMaxBackoff)
+	}
+	if c.OutputFormat == "" {
+		return missing("outputFormat")
+	}
+	if _, err := c.CSVDelimiterRune(); err != nil {
+		return invalid("csvDelimiter", "%s", err.Error())
+	}
+	for _, pattern := range c.LocationInclude {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return invalid("locationInclude", "has a bad pattern %q", pattern)
+		}
+	}
+	for _, pattern := range c.LocationExclude {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return invalid("locationExclude", "has a bad pattern %q", pattern)
+		}
+	}
+	for i, f := range c.ProjectFilters {
+		if _, err := f.Filter(); err != nil {
+			return invalid("projectFilters", "filter %d: %s", i+1, err.Error())
+		}
 	}
 	return nil
 }
+
+func missing(key string) error {
+	return fmt.Errorf("%s is missing", describeKey(key))
+}
+
+func invalid(key string, format string, a ...interface{}) error {
+	return fmt.Errorf("%s %s", describeKey(key), fmt.Sprintf(format, a...))
+}
@@ -0,0 +1,164 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ConfigFileEnv names the environment variable holding the config file path.
+const ConfigFileEnv = "CONFIG_FILE"
+
+type envVar struct {
+	name string
+	key  string
+	set  func(c *Config, value string) error
+}
+
+// envVars maps each environment variable to the config file key it
+// overrides.
+var envVars = []envVar{
+	{"ORG_ID", "orgId", func(c *Config, v string) error { c.OrgId = v; return nil }},
+	{"REGIONS", "regions", func(c *Config, v string) error { c.Regions = parseList(v); return nil }},
+	{"ZONES", "zones", func(c *Config, v string) error { c.Zones = parseList(v); return nil }},
+	{"EXPORT_PROJECT_ID", "exportProjectId", func(c *Config, v string) error { c.ExportProjectId = v; return nil }},
+	{"EXPORT_BUCKET_NAME", "exportBucketName", func(c *Config, v string) error { c.ExportBucketName = v; return nil }},
+	{"COLLECTORS", "collectors", func(c *Config, v string) error { c.Collectors = parseList(v); return nil }},
+	{"MAX_ERRORS", "maxErrors", func(c *Config, v string) error { return parseInt(v, &c.MaxErrors) }},
+	{"MAX_CONCURRENT_REQUESTS", "maxConcurrentRequests", func(c *Config, v string) error { return parseInt(v, &c.MaxConcurrentRequests) }},
+	{"MAX_CONCURRENT_REQUESTS_PER_API", "maxConcurrentRequestsPerApi", func(c *Config, v string) error { return parseInt(v, &c.MaxConcurrentRequestsPerAPI) }},
+	{"PARALLEL_COLLECTORS", "parallelCollectors", func(c *Config, v string) error { return parseBool(v, &c.ParallelCollectors) }},
+	{"RETRY_MAX_ATTEMPTS", "retryMaxAttempts", func(c *Config, v string) error { return parseInt(v, &c.RetryMaxAttempts) }},
+	{"RETRY_INITIAL_BACKOFF", "retryInitialBackoff", func(c *Config, v string) error { return parseDuration(v, &c.RetryInitialBackoff) }},
+	{"RETRY_MAX_BACKOFF", "retryMaxBackoff", func(c *Config, v string) error { return parseDuration(v, &c.RetryMaxBackoff) }},
+	{"API_ENDPOINT", "apiEndpoint", func(c *Config, v string) error { c.APIEndpoint = v; return nil }},
+	{"OUTPUT_FORMAT", "outputFormat", func(c *Config, v string) error { c.OutputFormat = v; return nil }},
+	{"PROJECTS", "projects", func(c *Config, v string) error { c.Projects = parseList(v); return nil }},
+	{"LOCATION_INCLUDE", "locationInclude", func(c *Config, v string) error { c.LocationInclude = parseList(v); return nil }},
+	{"LOCATION_EXCLUDE", "locationExclude", func(c *Config, v string) error { c.LocationExclude = parseList(v); return nil }},
+	{"PROJECT_INCLUDE", "projectFilters.include", func(c *Config, v string) error { c.ProjectFilter().Include = parseList(v); return nil }},
+	{"PROJECT_EXCLUDE", "projectFilters.exclude", func(c *Config, v string) error { c.ProjectFilter().Exclude = parseList(v); return nil }},
+	{"PROJECT_LABELS", "projectFilters.labels", func(c *Config, v string) error { c.ProjectFilter().Labels = parseList(v); return nil }},
+	{"PROJECT_FOLDERS", "projectFilters.folders", func(c *Config, v string) error { c.ProjectFilter().Folders = parseList(v); return nil }},
+	{"PROJECT_STATES", "projectFilters.states", func(c *Config, v string) error { c.ProjectFilter().States = parseList(v); return nil }},
+	{"ASSET_COLLECTORS", "assetCollectors", func(c *Config, v string) error { c.AssetCollectors = parseList(v); return nil }},
+	{"RETENTION_KEEP_LAST", "retentionKeepLast", func(c *Config, v string) error { return parseInt(v, &c.RetentionKeepLast) }},
+	{"RETENTION_KEEP_DAILY", "retentionKeepDaily", func(c *Config, v string) error { return parseInt(v, &c.RetentionKeepDaily) }},
+	{"RETENTION_KEEP_MONTHLY", "retentionKeepMonthly", func(c *Config, v string) error { return parseInt(v, &c.RetentionKeepMonthly) }},
+	{"RETENTION_DRY_RUN", "retentionDryRun", func(c *Config, v string) error { return parseBool(v, &c.RetentionDryRun) }},
+	{"DIFF_PREVIOUS", "diffPrevious", func(c *Config, v string) error { return parseBool(v, &c.DiffPrevious) }},
+	{"DIFF_FORMAT", "diffFormat", func(c *Config, v string) error { c.DiffFormat = v; return nil }},
+	{"CSV_DELIMITER", "csvDelimiter", func(c *Config, v string) error { c.CSVDelimiter = v; return nil }},
+	{"CSV_BOM", "csvBom", func(c *Config, v string) error { return parseBool(v, &c.CSVBOM) }},
+	{"PARQUET_ALONGSIDE", "parquetAlongside", func(c *Config, v string) error { return parseBool(v, &c.ParquetAlongside) }},
+	{"BIGQUERY_PROJECT_ID", "bigQueryProjectId", func(c *Config, v string) error { c.BigQueryProjectId = v; return nil }},
+	{"BIGQUERY_DATASET", "bigQueryDataset", func(c *Config, v string) error { c.BigQueryDataset = v; return nil }},
+	{"BIGQUERY_LOCATION", "bigQueryLocation", func(c *Config, v string) error { c.BigQueryLocation = v; return nil }},
+	{"BIGQUERY_ENDPOINT", "bigQueryEndpoint", func(c *Config, v string) error { c.BigQueryEndpoint = v; return nil }},
+}
+
+func parseList(value string) []string {
+	return strings.Split(value, ",")
+}
+
+func parseInt(value string, target *int) error {
+	number, err := strconv.Atoi(value)
+	if err != nil {
+		return fmt.Errorf("invalid integer %q", value)
+	}
+	*target = number
+	return nil
+}
+
+func parseBool(value string, target *bool) error {
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return fmt.Errorf("invalid boolean %q", value)
+	}
+	*target = b
+	return nil
+}
+
+func parseDuration(value string, target *time.Duration) error {
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return fmt.Errorf("invalid duration %q, expected a value such as 500ms or 2s", value)
+	}
+	*target = d
+	return nil
+}
+
+// describeKey names a setting by its config file key and environment variable,
+// e.g. "orgId (ORG_ID)".
+func describeKey(key string) string {
+	for _, env := range envVars {
+		if env.key == key {
+			return fmt.Sprintf("%s (%s)", key, env.name)
+		}
+	}
+	return key
+}
+
+// Load returns the defaults, overridden by the config file at path, if any,
+// and then by the environment variables that are set.
+func Load(path string) (*Config, error) {
+	c := NewConfig()
+	if path != "" {
+		if err := c.LoadFile(path); err != nil {
+			return nil, err
+		}
+	}
+	if err := c.LoadEnv(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// LoadDefault loads the config file named by CONFIG_FILE and the environment.
+func LoadDefault() (*Config, error) {
+	return Load(os.Getenv(ConfigFileEnv))
+}
+
+// LoadFile overrides c with the settings of a YAML or JSON file. Unknown keys
+// and values of the wrong type are reported with their line number.
+func (c *Config) LoadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("config file %s: %s", path, err.Error())
+	}
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("config file %s: %s", path, strings.TrimPrefix(err.Error(), "yaml: "))
+	}
+	return nil
+}
+
+// LoadEnv overrides c with the environment variables that are set.
+func (c *Config) LoadEnv() error {
+	var errs []string
+	for _, env := range envVars {
+		value, ok := os.LookupEnv(env.name)
+		if !ok || value == "" {
+			continue
+		}
+		if err := env.set(c, value); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %s", env.name, err.Error()))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid environment: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// YAML returns the config as a YAML document, as accepted by LoadFile.
+func (c *Config) YAML() ([]byte, error) {
+	return yaml.Marshal(c)
+}
@@ -1,7 +1,15 @@
 package config
 
+// Auto as the only region or zone discovers the regions or zones enabled in
+// each project.
+const Auto = "auto"
+
 type Regions []string
 
 var DefaultRegions = Regions{
-	"me-west1",
+	Auto,
+}
+
+func (r Regions) IsAuto() bool {
+	return len(r) == 1 && r[0] == Auto
 }
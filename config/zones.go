@@ -3,7 +3,9 @@ package config
 type Zones []string
 
 var DefaultZones = Zones{
-	"me-west1-a",
-	"me-west1-b",
-	"me-west1-c",
+	Auto,
+}
+
+func (z Zones) IsAuto() bool {
+	return len(z) == 1 && z[0] == Auto
 }
@@ -0,0 +1,278 @@
+package diff
+
+import (
+	"fmt"
+	"github.com/liornabat/gcp_inventory_exporter/collector"
+	"github.com/liornabat/gcp_inventory_exporter/inventory"
+	"github.com/liornabat/gcp_inventory_exporter/project"
+	"sort"
+	"strings"
+)
+
+type ChangeType string
+
+const (
+	Added    ChangeType = "added"
+	Removed  ChangeType = "removed"
+	Modified ChangeType = "modified"
+)
+
+// Sheet is a sheet of a snapshot as rendered to an export, the header first.
+// Key lists the columns identifying a resource, the whole row when empty.
+type Sheet struct {
+	Name string
+	Key  []string
+	Rows [][]string
+}
+
+// FieldChange is a column whose value differs between the snapshots.
+type FieldChange struct {
+	Field string `json:"field"`
+	From  string `json:"from"`
+	To    string `json:"to"`
+}
+
+// Change is a resource that was added, removed or modified. Values holds the
+// row of an added or removed resource, Fields the changes of a modified one.
+type Change struct {
+	Type   ChangeType        `json:"type"`
+	Key    string            `json:"key"`
+	Values map[string]string `json:"values,omitempty"`
+	Fields []*FieldChange    `json:"fields,omitempty"`
+}
+
+type SheetDiff struct {
+	Sheet   string    `json:"sheet"`
+	Key     []string  `json:"key"`
+	Changes []*Change `json:"changes"`
+}
+
+// Diff is the set of changes from one snapshot to another. Sheets found in
+// only one of the snapshots, e.g. of a collector that was not enabled in
+// both runs, are listed in SkippedSheets and not compared. ExcludedProjects
+// lists the projects left out of the comparison by ExcludeProjects.
+type Diff struct {
+	From             string       `json:"from"`
+	To               string       `json:"to"`
+	Sheets           []*SheetDiff `json:"sheets"`
+	SkippedSheets    []string     `json:"skippedSheets,omitempty"`
+	ExcludedProjects []string     `json:"excludedProjects,omitempty"`
+}
+
+// ProjectIDColumn holds the project of a resource in every sheet.
+const ProjectIDColumn = "Project ID"
+
+// FromSnapshot returns the sheets of the snapshot tables, without the errors.
+func FromSnapshot(snapshot *inventory.Snapshot) []*Sheet {
+	var sheets []*Sheet
+	for _, table := range snapshot.Tables {
+		sheets = append(sheets, &Sheet{
+			Name: table.Sheet,
+			Key:  table.Schema.Key,
+			Rows: table.Rows(),
+		})
+	}
+	return sheets
+}
+
+// ExcludeProjects returns the sheets without the rows of the projects, e.g. of
+// projects that failed or were skipped in one of the runs, so that their
+// missing resources are not taken for removed ones. Sheets without a project
+// id column are returned whole.
+func ExcludeProjects(sheets []*Sheet, projectIDs map[string]bool) []*Sheet {
+	if len(projectIDs) == 0 {
+		return sheets
+	}
+	var filtered []*Sheet
+	for _, sheet := range sheets {
+		column := -1
+		for i, name := range header(sheet) {
+			if name == ProjectIDColumn {
+				column = i
+			}
+		}
+		if column < 0 {
+			filtered = append(filtered, sheet)
+			continue
+		}
+		rows := [][]string{sheet.Rows[0]}
+		for _, values := range sheet.Rows[1:] {
+			if column < len(values) && projectIDs[values[column]] {
+				continue
+			}
+			rows = append(rows, values)
+		}
+		filtered = append(filtered, &Sheet{Name: sheet.Name, Key: sheet.Key, Rows: rows})
+	}
+	return filtered
+}
+
+// Keys returns the key columns of the sheets of every registered collector
+// and of the Projects sheet, for reading exports without a snapshot at hand.
+func Keys() map[string][]string {
+	keys := map[string][]string{
+		project.ProjectsSheet: project.ProjectSchema.Key,
+	}
+	collectors, _ := collector.Enabled(nil, &collector.Options{})
+	for _, c := range collectors {
+		keys[c.Sheet()] = c.Schema().Key
+	}
+	return keys
+}
+
+// Compare returns the changes from the sheets of one snapshot to the sheets
+// of another, matching the sheets by name and the resources by key.
+func Compare(fromID string, from []*Sheet, toID string, to []*Sheet) *Diff {
+	d := &Diff{
+		From: fromID,
+		To:   toID,
+	}
+	fromSheets := map[string]*Sheet{}
+	for _, sheet := range from {
+		fromSheets[sheet.Name] = sheet
+	}
+	toSheets := map[string]bool{}
+	for _, sheet := range to {
+		toSheets[sheet.Name] = true
+		previous, ok := fromSheets[sheet.Name]
+		if !ok {
+			d.SkippedSheets = append(d.SkippedSheets, sheet.Name)
+			continue
+		}
+		d.Sheets = append(d.Sheets, compareSheet(previous, sheet))
+	}
+	for _, sheet := range from {
+		if !toSheets[sheet.Name] {
+			d.SkippedSheets = append(d.SkippedSheets, sheet.Name)
+		}
+	}
+	return d
+}
+
+// row is a sheet row by column name.
+type row map[string]string
+
+func rowsByKey(sheet *Sheet, key []string) ([]string, map[string]row) {
+	rows := map[string]row{}
+	var keys []string
+	if len(sheet.Rows) == 0 {
+		return nil, rows
+	}
+	header := sheet.Rows[0]
+	for _, values := range sheet.Rows[1:] {
+		r := row{}
+		for i, column := range header {
+			if i < len(values) {
+				r[column] = values[i]
+			} else {
+				r[column] = ""
+			}
+		}
+		var parts []string
+		for _, column := range key {
+			parts = append(parts, r[column])
+		}
+		k := strings.Join(parts, "/")
+		// Rows sharing a key are matched in order of appearance.
+		for n := 2; rows[k] != nil; n++ {
+			k = fmt.Sprintf("%s#%d", strings.Join(parts, "/"), n)
+		}
+		rows[k] = r
+		keys = append(keys, k)
+	}
+	return keys, rows
+}
+
+func header(sheet *Sheet) []string {
+	if len(sheet.Rows) == 0 {
+		return nil
+	}
+	return sheet.Rows[0]
+}
+
+func compareSheet(from, to *Sheet) *SheetDiff {
+	key := to.Key
+	if len(key) == 0 {
+		key = from.Key
+	}
+	// Key columns missing from either sheet, e.g. from an export written
+	// before the column was added, are left out of the key.
+	var shared []string
+	for _, column := range key {
+		if contains(header(from), column) && contains(header(to), column) {
+			shared = append(shared, column)
+		}
+	}
+	key = shared
+	if len(key) == 0 {
+		key = header(to)
+	}
+	columns := append([]string{}, header(to)...)
+	for _, column := range header(from) {
+		if !contains(columns, column) {
+			columns = append(columns, column)
+		}
+	}
+	fromKeys, fromRows := rowsByKey(from, key)
+	toKeys, toRows := rowsByKey(to, key)
+	sheetDiff := &SheetDiff{
+		Sheet:   to.Name,
+		Key:     key,
+		Changes: []*Change{},
+	}
+	for _, k := range toKeys {
+		previous, ok := fromRows[k]
+		if !ok {
+			sheetDiff.Changes = append(sheetDiff.Changes, &Change{Type: Added, Key: k, Values: toRows[k]})
+			continue
+		}
+		var fields []*FieldChange
+		for _, column := range columns {
+			if contains(key, column) {
+				continue
+			}
+			if previous[column] != toRows[k][column] {
+				fields = append(fields, &FieldChange{Field: column, From: previous[column], To: toRows[k][column]})
+			}
+		}
+		if len(fields) > 0 {
+			sheetDiff.Changes = append(sheetDiff.Changes, &Change{Type: Modified, Key: k, Fields: fields})
+		}
+	}
+	for _, k := range fromKeys {
+		if _, ok := toRows[k]; !ok {
+			sheetDiff.Changes = append(sheetDiff.Changes, &Change{Type: Removed, Key: k, Values: fromRows[k]})
+		}
+	}
+	sort.SliceStable(sheetDiff.Changes, func(i, j int) bool {
+		return sheetDiff.Changes[i].Key < sheetDiff.Changes[j].Key
+	})
+	return sheetDiff
+}
+
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Counts returns the number of changes of each type.
+func (d *Diff) Counts() map[ChangeType]int {
+	counts := map[ChangeType]int{}
+	for _, sheet := range d.Sheets {
+		for _, change := range sheet.Changes {
+			counts[change.Type]++
+		}
+	}
+	return counts
+}
+
+// Summary describes the changes, e.g. "2 added, 1 removed and 0 modified
+// resources since 2023-03-01-10-00-00".
+func (d *Diff) Summary() string {
+	counts := d.Counts()
+	return fmt.Sprintf("%d added, %d removed and %d modified resources since %s", counts[Added], counts[Removed], counts[Modified], d.From)
+}
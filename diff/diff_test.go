@@ -0,0 +1,183 @@
+package diff
+
+import (
+	"reflect"
+	"testing"
+)
+
+// changes returns the changes of a sheet diff as "<type> <key>" strings,
+// with the changed fields of modified resources.
+func changes(sheet *SheetDiff) []string {
+	list := []string{}
+	for _, change := range sheet.Changes {
+		description := string(change.Type) + " " + change.Key
+		for _, field := range change.Fields {
+			description += " " + field.Field + ":" + field.From + "->" + field.To
+		}
+		list = append(list, description)
+	}
+	return list
+}
+
+func TestCompareSheet(t *testing.T) {
+	tests := []struct {
+		name string
+		from *Sheet
+		to   *Sheet
+		key  []string
+		want []string
+	}{
+		{
+			name: "added, removed and modified",
+			from: &Sheet{Name: "Compute", Key: []string{"Project ID", "Name"}, Rows: [][]string{
+				{"Project ID", "Name", "Status"},
+				{"p1", "web-1", "RUNNING"},
+				{"p1", "db-1", "RUNNING"},
+			}},
+			to: &Sheet{Name: "Compute", Key: []string{"Project ID", "Name"}, Rows: [][]string{
+				{"Project ID", "Name", "Status"},
+				{"p1", "web-1", "TERMINATED"},
+				{"p2", "web-1", "RUNNING"},
+			}},
+			key: []string{"Project ID", "Name"},
+			want: []string{
+				"removed p1/db-1",
+				"modified p1/web-1 Status:RUNNING->TERMINATED",
+				"added p2/web-1",
+			},
+		},
+		{
+			name: "unchanged",
+			from: &Sheet{Name: "Routes", Key: []string{"Name"}, Rows: [][]string{
+				{"Name", "Network"},
+				{"default", "vpc"},
+			}},
+			to: &Sheet{Name: "Routes", Key: []string{"Name"}, Rows: [][]string{
+				{"Name", "Network"},
+				{"default", "vpc"},
+			}},
+			key:  []string{"Name"},
+			want: []string{},
+		},
+		{
+			name: "duplicate keys are matched in order",
+			from: &Sheet{Name: "Routes", Key: []string{"Name"}, Rows: [][]string{
+				{"Name", "Priority"},
+				{"r", "1"},
+				{"r", "2"},
+			}},
+			to: &Sheet{Name: "Routes", Key: []string{"Name"}, Rows: [][]string{
+				{"Name", "Priority"},
+				{"r", "1"},
+				{"r", "3"},
+			}},
+			key:  []string{"Name"},
+			want: []string{"modified r#2 Priority:2->3"},
+		},
+		{
+			name: "key columns missing from the previous export are left out",
+			from: &Sheet{Name: "VPC", Rows: [][]string{
+				{"Region", "Subnetwork", "CIDR"},
+				{"me-west1", "subnet-a", "10.0.0.0/24"},
+			}},
+			to: &Sheet{Name: "VPC", Key: []string{"Project ID", "Region", "Subnetwork"}, Rows: [][]string{
+				{"Project ID", "Region", "Subnetwork", "CIDR"},
+				{"p1", "me-west1", "subnet-a", "10.0.0.0/24"},
+			}},
+			key:  []string{"Region", "Subnetwork"},
+			want: []string{"modified me-west1/subnet-a Project ID:->p1"},
+		},
+		{
+			name: "whole row key",
+			from: &Sheet{Name: "Other", Rows: [][]string{
+				{"A", "B"},
+				{"1", "2"},
+			}},
+			to: &Sheet{Name: "Other", Rows: [][]string{
+				{"A", "B"},
+				{"1", "3"},
+			}},
+			key:  []string{"A", "B"},
+			want: []string{"removed 1/2", "added 1/3"},
+		},
+		{
+			name: "short rows are padded",
+			from: &Sheet{Name: "Compute", Key: []string{"Name"}, Rows: [][]string{
+				{"Name", "IP"},
+				{"web-1"},
+			}},
+			to: &Sheet{Name: "Compute", Key: []string{"Name"}, Rows: [][]string{
+				{"Name", "IP"},
+				{"web-1", "10.0.0.2"},
+			}},
+			key:  []string{"Name"},
+			want: []string{"modified web-1 IP:->10.0.0.2"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sheet := compareSheet(test.from, test.to)
+			if !reflect.DeepEqual(sheet.Key, test.key) {
+				t.Errorf("got key %v, expected %v", sheet.Key, test.key)
+			}
+			if got := changes(sheet); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got changes %v, expected %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestCompare(t *testing.T) {
+	from := []*Sheet{
+		{Name: "Compute", Key: []string{"Name"}, Rows: [][]string{{"Name"}, {"web-1"}}},
+		{Name: "Routes", Key: []string{"Name"}, Rows: [][]string{{"Name"}, {"default"}}},
+		{Name: "Errors", Rows: [][]string{{"Collector"}}},
+	}
+	to := []*Sheet{
+		{Name: "Compute", Key: []string{"Name"}, Rows: [][]string{{"Name"}, {"web-1"}, {"web-2"}}},
+		{Name: "VPC", Key: []string{"Name"}, Rows: [][]string{{"Name"}, {"vpc"}}},
+	}
+	d := Compare("run-1", from, "run-2", to)
+	if len(d.Sheets) != 1 || d.Sheets[0].Sheet != "Compute" {
+		t.Fatalf("got sheets %v, expected only Compute", d.Sheets)
+	}
+	if want := []string{"VPC", "Routes", "Errors"}; !reflect.DeepEqual(d.SkippedSheets, want) {
+		t.Errorf("got skipped sheets %v, expected %v", d.SkippedSheets, want)
+	}
+	if want := "1 added, 0 removed and 0 modified resources since run-1"; d.Summary() != want {
+		t.Errorf("got summary %q, expected %q", d.Summary(), want)
+	}
+}
+
+func TestExcludeProjects(t *testing.T) {
+	from := []*Sheet{
+		{Name: "Compute", Key: []string{"Project ID", "Name"}, Rows: [][]string{
+			{"Project ID", "Name"},
+			{"p1", "web-1"},
+			{"failed", "web-1"},
+			{"skipped", "web-1"},
+		}},
+		{Name: "Other", Rows: [][]string{{"Name"}, {"x"}}},
+	}
+	to := []*Sheet{
+		{Name: "Compute", Key: []string{"Project ID", "Name"}, Rows: [][]string{
+			{"Project ID", "Name"},
+			{"p1", "web-1"},
+			{"failed", "db-1"},
+		}},
+		{Name: "Other", Rows: [][]string{{"Name"}, {"x"}}},
+	}
+	excluded := map[string]bool{"failed": true, "skipped": true}
+	d := Compare("run-1", ExcludeProjects(from, excluded), "run-2", ExcludeProjects(to, excluded))
+	for _, sheet := range d.Sheets {
+		if got := changes(sheet); len(got) > 0 {
+			t.Errorf("%s: got changes %v, expected none", sheet.Sheet, got)
+		}
+	}
+	if len(from[0].Rows) != 4 {
+		t.Errorf("ExcludeProjects modified the sheet it was given")
+	}
+	if got := ExcludeProjects(from, nil); !reflect.DeepEqual(got, from) {
+		t.Errorf("ExcludeProjects without projects changed the sheets")
+	}
+}
@@ -0,0 +1,134 @@
+package diff
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/liornabat/gcp_inventory_exporter/inventory"
+	"github.com/liornabat/gcp_inventory_exporter/pkg/xls"
+	"io"
+	"sort"
+)
+
+const (
+	ChangesSheet = "Changes"
+	SummarySheet = "Summary"
+	// ObjectPrefix starts the name of the changes saved next to the exports.
+	ObjectPrefix = "changes-"
+)
+
+// Renderer writes a diff to a single file.
+type Renderer struct {
+	Name        string
+	Extension   string
+	ContentType string
+	Write       func(w io.Writer, d *Diff) error
+}
+
+var renderers = map[string]*Renderer{
+	"xlsx": {
+		Name:        "xlsx",
+		Extension:   "xlsx",
+		ContentType: "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+		Write:       WriteXlsx,
+	},
+	"json": {
+		Name:        "json",
+		Extension:   "json",
+		ContentType: "application/json",
+		Write:       WriteJSON,
+	},
+}
+
+func GetRenderer(name string) (*Renderer, error) {
+	renderer, ok := renderers[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown diff format %s", name)
+	}
+	return renderer, nil
+}
+
+func RendererNames() []string {
+	var names []string
+	for name := range renderers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ObjectName returns the name of the changes of a diff, e.g.
+// changes-2023-03-01-10-00-00.xlsx for the changes up to that run.
+func ObjectName(d *Diff, renderer *Renderer) string {
+	return fmt.Sprintf("%s%s.%s", ObjectPrefix, d.To, renderer.Extension)
+}
+
+func WriteJSON(w io.Writer, d *Diff) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(d)
+}
+
+// WriteXlsx writes a workbook with the change counts of each sheet and a
+// Changes sheet listing every added and removed resource and every modified
+// field.
+func WriteXlsx(w io.Writer, d *Diff) error {
+	summary := [][]string{{"Sheet", "Added", "Removed", "Modified"}}
+	changes := [][]string{{"Sheet", "Change", "Key", "Field", "From", "To"}}
+	for _, sheet := range d.Sheets {
+		counts := map[ChangeType]int{}
+		for _, change := range sheet.Changes {
+			counts[change.Type]++
+			if change.Type != Modified {
+				changes = append(changes, []string{sheet.Sheet, string(change.Type), change.Key, "", "", ""})
+				continue
+			}
+			for _, field := range change.Fields {
+				changes = append(changes, []string{sheet.Sheet, string(change.Type), change.Key, field.Field, field.From, field.To})
+			}
+		}
+		summary = append(summary, []string{
+			sheet.Sheet,
+			fmt.Sprintf("%d", counts[Added]),
+			fmt.Sprintf("%d", counts[Removed]),
+			fmt.Sprintf("%d", counts[Modified]),
+		})
+	}
+	xlsFile := xls.NewXls()
+	defer xlsFile.Close()
+	if err := xlsFile.SetDataToSheet(SummarySheet, summary); err != nil {
+		return err
+	}
+	if err := xlsFile.SetDataToSheet(ChangesSheet, changes); err != nil {
+		return err
+	}
+	if err := xlsFile.DeleteSheet("Sheet1"); err != nil {
+		return err
+	}
+	return xlsFile.Write(w)
+}
+
+// ReadXlsx reads the sheets of an xlsx export, taking the key columns of each
+// sheet from keys. The errors sheet is left out.
+func ReadXlsx(r io.Reader, keys map[string][]string) ([]*Sheet, error) {
+	xlsFile, err := xls.Open(r)
+	if err != nil {
+		return nil, err
+	}
+	defer xlsFile.Close()
+	var sheets []*Sheet
+	for _, name := range xlsFile.Sheets() {
+		if name == inventory.ErrorsSheet {
+			continue
+		}
+		rows, err := xlsFile.GetDataFromSheet(name)
+		if err != nil {
+			return nil, fmt.Errorf("sheet %s: %s", name, err.Error())
+		}
+		sheets = append(sheets, &Sheet{
+			Name: name,
+			Key:  keys[name],
+			Rows: rows,
+		})
+	}
+	return sheets, nil
+}
@@ -0,0 +1,95 @@
+package gcp_inventory_exporter
+
+import (
+	"context"
+	"fmt"
+	"github.com/cloudevents/sdk-go/v2/event"
+	"github.com/liornabat/gcp_inventory_exporter/config"
+	"github.com/liornabat/gcp_inventory_exporter/exporter"
+	"github.com/liornabat/gcp_inventory_exporter/pkg/logger"
+)
+
+// MessagePublishedData is the data of the CloudEvent that Eventarc sends for
+// a message published to a Pub/Sub topic.
+type MessagePublishedData struct {
+	Message      PubSubMessage `json:"message"`
+	Subscription string        `json:"subscription"`
+}
+
+type PubSubMessage struct {
+	Data        []byte            `json:"data"`
+	Attributes  map[string]string `json:"attributes"`
+	MessageID   string            `json:"messageId"`
+	PublishTime string            `json:"publishTime"`
+}
+
+// parseEventOptions reads the export options from the JSON data of the
+// Pub/Sub message and from its attributes, which take precedence over the
+// data, like the body and the query parameters of an HTTP request.
+func parseEventOptions(e event.Event) (*exporter.Options, error) {
+	var data MessagePublishedData
+	if err := e.DataAs(&data); err != nil {
+		return nil, fmt.Errorf("invalid Pub/Sub event: %s", err.Error())
+	}
+	opts := &exporter.Options{}
+	if err := decodeExportOptions(data.Message.Data, opts); err != nil {
+		return nil, err
+	}
+	get := func(key string) string {
+		return data.Message.Attributes[key]
+	}
+	if err := setExportParams(get, opts); err != nil {
+		return nil, err
+	}
+	if opts.Async {
+		return nil, fmt.Errorf("async is not supported for Pub/Sub exports, which already run in the background")
+	}
+	return opts, nil
+}
+
+// processInventoryEvent exports the inventory for a Pub/Sub message, e.g.
+// one published by Cloud Scheduler. Invalid options are logged and the event
+// is acknowledged, since retrying it cannot succeed; other failures return an
+// error so that Pub/Sub can retry the export. To try it locally, run cmd with
+// FUNCTION_TARGET=ExportInventoryEvent and post a synthetic event:
+//
+//	curl localhost:8080 \
+//	  -H "Content-Type: application/json" \
+//	  -H "ce-id: 1" -H "ce-specversion: 1.0" \
+//	  -H "ce-type: google.cloud.pubsub.topic.v1.messagePublished" \
+//	  -H "ce-source: //pubsub.googleapis.com/projects/p/topics/inventory" \
+//	  -d '{"message": {"data": "'$(echo -n '{"collectors":["compute"]}' | base64)'"}}'
+func processInventoryEvent(ctx context.Context, e event.Event) error {
+	log := logger.NewLogger("ExportInventoryEvent", "debug")
+	log.Infof("ExportInventoryEvent Started for event %s", e.ID())
+	serverConfig, err := config.LoadDefault()
+	if err != nil {
+		log.Errorf("Failed to load config: %s", err.Error())
+		return err
+	}
+	if err := serverConfig.Validate(); err != nil {
+		log.Errorf("Failed to validate config: %s", err.Error())
+		return err
+	}
+	opts, err := parseEventOptions(e)
+	if err != nil {
+		log.Errorf("Failed to parse export options, dropping event %s: %s", e.ID(), err.Error())
+		return nil
+	}
+	cfg, err := opts.Apply(serverConfig)
+	if err != nil {
+		log.Errorf("Failed to apply export options, dropping event %s: %s", e.ID(), err.Error())
+		return nil
+	}
+	run, err := newExportRun(ctx, log, cfg, opts)
+	if err != nil {
+		return err
+	}
+	defer run.Close()
+	result, err := run.export(ctx, log)
+	if err != nil {
+		return err
+	}
+	log.Infof("Inventory exported to %s", result)
+	return nil
+}
@@ -0,0 +1,78 @@
+package gcp_inventory_exporter
+
+import (
+	"context"
+	"github.com/cloudevents/sdk-go/v2/event"
+	"github.com/liornabat/gcp_inventory_exporter/inventory"
+	"github.com/liornabat/gcp_inventory_exporter/project"
+	"github.com/liornabat/gcp_inventory_exporter/storage"
+	"strings"
+	"testing"
+)
+
+func newPubSubEvent(t *testing.T, data interface{}) event.Event {
+	t.Helper()
+	e := event.New()
+	e.SetID("1")
+	e.SetType("google.cloud.pubsub.topic.v1.messagePublished")
+	e.SetSource("//pubsub.googleapis.com/projects/demo-project/topics/inventory")
+	if err := e.SetData(event.ApplicationJSON, data); err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
+
+func TestProcessInventoryEvent(t *testing.T) {
+	srv := startFakeGCP(t)
+	e := newPubSubEvent(t, MessagePublishedData{
+		Message: PubSubMessage{
+			Data: []byte(`{"collectors": ["compute"], "objectName": "inventory-data.xlsx"}`),
+			// Attributes take precedence over the data.
+			Attributes: map[string]string{"objectName": "inventory-event.xlsx"},
+			MessageID:  "1",
+		},
+		Subscription: "projects/demo-project/subscriptions/inventory",
+	})
+	if err := processInventoryEvent(context.Background(), e); err != nil {
+		t.Fatalf("failed to process event: %s", err.Error())
+	}
+	if _, ok := srv.Object(testBucket, "inventory-data.xlsx"); ok {
+		t.Errorf("export saved under the object name of the data instead of the attributes")
+	}
+	var names []string
+	for _, sheet := range readWorkbook(t, srv, "inventory-event.xlsx") {
+		names = append(names, sheet.Sheet)
+	}
+	// The projects are exported whichever collectors are requested.
+	expected := []string{project.ProjectsSheet, "Compute", inventory.ErrorsSheet}
+	if strings.Join(names, ",") != strings.Join(expected, ",") {
+		t.Errorf("got sheets %v, expected %v", names, expected)
+	}
+}
+
+func TestProcessInventoryEventMalformed(t *testing.T) {
+	tests := []struct {
+		name string
+		data interface{}
+	}{
+		{"not a Pub/Sub message", "not a message"},
+		{"invalid JSON options", MessagePublishedData{Message: PubSubMessage{Data: []byte(`{"collectors":`)}}},
+		{"unknown option", MessagePublishedData{Message: PubSubMessage{Data: []byte(`{"collector": ["compute"]}`)}}},
+		{"invalid attribute", MessagePublishedData{Message: PubSubMessage{Attributes: map[string]string{"diff": "maybe"}}}},
+		{"async", MessagePublishedData{Message: PubSubMessage{Attributes: map[string]string{"async": "true"}}}},
+		{"unknown collector", MessagePublishedData{Message: PubSubMessage{Data: []byte(`{"collectors": ["unknown"]}`)}}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			srv := startFakeGCP(t)
+			// Malformed events are acknowledged, since retrying them cannot
+			// succeed, without running an export.
+			if err := processInventoryEvent(context.Background(), newPubSubEvent(t, test.data)); err != nil {
+				t.Errorf("got error %q, expected the event to be dropped", err.Error())
+			}
+			if _, ok := srv.Object(testBucket, storage.LatestObjectName); ok {
+				t.Errorf("an export ran for a malformed event")
+			}
+		})
+	}
+}
@@ -0,0 +1,239 @@
+package exporter
+
+import (
+	"context"
+	"fmt"
+	"github.com/liornabat/gcp_inventory_exporter/asset"
+	"github.com/liornabat/gcp_inventory_exporter/bigquery"
+	"github.com/liornabat/gcp_inventory_exporter/collector"
+	_ "github.com/liornabat/gcp_inventory_exporter/compute"
+	"github.com/liornabat/gcp_inventory_exporter/config"
+	"github.com/liornabat/gcp_inventory_exporter/inventory"
+	"github.com/liornabat/gcp_inventory_exporter/location"
+	_ "github.com/liornabat/gcp_inventory_exporter/network"
+	"github.com/liornabat/gcp_inventory_exporter/pkg/gcpclient"
+	"github.com/liornabat/gcp_inventory_exporter/pkg/limiter"
+	"github.com/liornabat/gcp_inventory_exporter/pkg/logger"
+	"github.com/liornabat/gcp_inventory_exporter/pkg/retry"
+	"github.com/liornabat/gcp_inventory_exporter/project"
+	"github.com/liornabat/gcp_inventory_exporter/storage"
+	"sync"
+	"time"
+)
+
+// Exporter runs the collectors of a single export. The API clients it creates
+// share its retrier, so the retries of the run are counted together.
+type Exporter struct {
+	cfg      *config.Config
+	log      *logger.Logger
+	retrier  *retry.Retrier
+	clients  *gcpclient.Options
+	assets   asset.Client
+	progress Progress
+}
+
+// Progress is notified as the collectors of a run start and finish.
+type Progress interface {
+	CollectorsEnabled(names []string)
+	CollectorStarted(name string)
+	CollectorDone(name string, resources, errors int)
+}
+
+type noProgress struct{}
+
+func (noProgress) CollectorsEnabled(names []string)                 {}
+func (noProgress) CollectorStarted(name string)                     {}
+func (noProgress) CollectorDone(name string, resources, errors int) {}
+
+func NewExporter(cfg *config.Config, log *logger.Logger) *Exporter {
+	clients := gcpclient.NewOptions(cfg.APIEndpoint)
+	if cfg.BigQueryEndpoint != "" {
+		clients.SetEndpoint(gcpclient.BigQuery, cfg.BigQueryEndpoint)
+	}
+	return &Exporter{
+		cfg:      cfg,
+		log:      log,
+		retrier:  retry.NewRetrier(cfg.RetryPolicy(), log),
+		clients:  clients,
+		progress: noProgress{},
+	}
+}
+
+// SetProgress reports the progress of the collectors to progress.
+func (e *Exporter) SetProgress(progress Progress) *Exporter {
+	e.progress = progress
+	return e
+}
+
+// SetAssetClient replaces the Cloud Asset Inventory client used by the
+// collectors listed in AssetCollectors, e.g. with a stub.
+func (e *Exporter) SetAssetClient(client asset.Client) *Exporter {
+	e.assets = client
+	return e
+}
+
+func (e *Exporter) NewStorage(ctx context.Context) (*storage.Storage, error) {
+	s, err := storage.NewStorage(ctx, e.cfg.ExportProjectId, e.clients.For(gcpclient.Storage)...)
+	if err != nil {
+		return nil, err
+	}
+	return s.SetRetrier(e.retrier), nil
+}
+
+// NewBigQuerySink returns the sink appending the snapshots to the configured
+// BigQuery dataset.
+func (e *Exporter) NewBigQuerySink(ctx context.Context) (*bigquery.Sink, error) {
+	cfg := e.cfg
+	return bigquery.NewSink(ctx, e.log, cfg.BigQueryProject(), cfg.BigQueryDataset, cfg.BigQueryLocation, e.retrier, e.clients.For(gcpclient.BigQuery)...)
+}
+
+// Collect runs the enabled collectors over the projects of the organization
+// and returns the collected snapshot. Partial failures are recorded in the
+// snapshot errors; an error is returned only when the run cannot complete or
+// the errors exceed the configured maximum.
+func (e *Exporter) Collect(ctx context.Context) (*inventory.Snapshot, error) {
+	cfg := e.cfg
+	log := e.log
+	snapshot := inventory.NewSnapshot(cfg.OrgId, time.Now())
+
+	projects, err := project.GetProjects(ctx, log, cfg.OrgId, e.retrier, snapshot.Errors, e.clients.For(gcpclient.ResourceManager)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get projects: %s", err.Error())
+	}
+	filters, err := cfg.Filters()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create project filters: %s", err.Error())
+	}
+	projects, skipped := project.Apply(projects, filters)
+	for _, s := range skipped {
+		log.Infof("Skipping project %s: %s", s.Project.ID, s.Reason)
+		snapshot.Skipped = append(snapshot.Skipped, &inventory.SkippedProject{ID: s.Project.ID, Reason: s.Reason})
+	}
+	if len(cfg.Projects) > 0 {
+		projects = project.FilterByID(projects, cfg.Projects)
+		log.Infof("Exporting %d of the listed projects", len(projects))
+	}
+	snapshot.AddTable(&inventory.Table{
+		Collector: project.CollectorName,
+		Sheet:     project.ProjectsSheet,
+		Schema:    project.ProjectSchema,
+		Resources: project.Resources(projects),
+	})
+
+	opts := &collector.Options{
+		Config:  cfg,
+		Log:     log,
+		Errors:  snapshot.Errors,
+		Limiter: limiter.NewLimiter(cfg.MaxConcurrentRequests, cfg.MaxConcurrentRequestsPerAPI),
+		Retrier: e.retrier,
+		Clients: e.clients,
+	}
+	opts.Locations = location.NewResolver(opts)
+	collectors, err := collector.Enabled(cfg.Collectors, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create collectors: %s", err.Error())
+	}
+	collectors, err = e.useAssets(ctx, collectors, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create asset collectors: %s", err.Error())
+	}
+	var names []string
+	for _, c := range collectors {
+		names = append(names, c.Name())
+	}
+	e.progress.CollectorsEnabled(names)
+	results, err := e.runCollectors(ctx, collectors, projects, snapshot.Errors)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get inventory: %s", err.Error())
+	}
+	for i, c := range collectors {
+		snapshot.AddTable(&inventory.Table{
+			Collector: c.Name(),
+			Sheet:     c.Sheet(),
+			Schema:    c.Schema(),
+			Resources: results[i],
+		})
+	}
+	snapshot.Retries = e.retrier.Counts()
+	snapshot.EndTime = time.Now()
+
+	if snapshot.Errors.Len() > 0 {
+		log.Warnf("Inventory collected with %s", snapshot.Summary())
+	}
+	if cfg.ErrorsExceeded(snapshot.Errors.Len()) {
+		return nil, fmt.Errorf("inventory collected with %s, exceeding the maximum of %d", snapshot.Errors.Summary(), cfg.MaxErrors)
+	}
+	return snapshot, nil
+}
+
+// useAssets replaces the collectors listed in AssetCollectors with collectors
+// that read from Cloud Asset Inventory.
+func (e *Exporter) useAssets(ctx context.Context, collectors []collector.Collector, opts *collector.Options) ([]collector.Collector, error) {
+	if len(e.cfg.AssetCollectors) == 0 {
+		return collectors, nil
+	}
+	for _, name := range e.cfg.AssetCollectors {
+		if !collector.IsRegistered(name) {
+			return nil, fmt.Errorf("unknown collector %s", name)
+		}
+	}
+	client := e.assets
+	if client == nil {
+		var err error
+		client, err = asset.NewClient(ctx, e.clients.For(gcpclient.CloudAsset)...)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for i, c := range collectors {
+		if !contains(e.cfg.AssetCollectors, c.Name()) {
+			continue
+		}
+		assetCollector, ok := c.(collector.AssetCollector)
+		if !ok {
+			return nil, fmt.Errorf("collector %s cannot read from Cloud Asset Inventory", c.Name())
+		}
+		collectors[i] = asset.NewCollector(assetCollector, client, opts)
+	}
+	return collectors, nil
+}
+
+// runCollectors returns the resources of each collector in collectors order.
+// With ParallelCollectors set all collectors run at the same time, sharing the
+// request limiter.
+func (e *Exporter) runCollectors(ctx context.Context, collectors []collector.Collector, projects []*project.Project, errors *inventory.Errors) ([][]*inventory.Resource, error) {
+	results := make([][]*inventory.Resource, len(collectors))
+	if !e.cfg.ParallelCollectors {
+		for i, c := range collectors {
+			resources, err := e.collect(ctx, c, projects, errors)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s", c.Name(), err.Error())
+			}
+			results[i] = resources
+		}
+		return results, nil
+	}
+	errs := make([]error, len(collectors))
+	wg := &sync.WaitGroup{}
+	wg.Add(len(collectors))
+	for i, c := range collectors {
+		go func(i int, c collector.Collector) {
+			defer wg.Done()
+			results[i], errs[i] = e.collect(ctx, c, projects, errors)
+		}(i, c)
+	}
+	wg.Wait()
+	for i, err := range errs {
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", collectors[i].Name(), err.Error())
+		}
+	}
+	return results, nil
+}
+
+func (e *Exporter) collect(ctx context.Context, c collector.Collector, projects []*project.Project, errors *inventory.Errors) ([]*inventory.Resource, error) {
+	e.progress.CollectorStarted(c.Name())
+	resources, err := c.Collect(ctx, projects)
+	e.progress.CollectorDone(c.Name(), len(resources), errors.CountByCollector()[c.Name()])
+	return resources, err
+}
@@ -0,0 +1,88 @@
+package exporter
+
+import (
+	"context"
+	"github.com/liornabat/gcp_inventory_exporter/config"
+	"github.com/liornabat/gcp_inventory_exporter/network"
+	"github.com/liornabat/gcp_inventory_exporter/pkg/fakegcp"
+	"github.com/liornabat/gcp_inventory_exporter/pkg/logger"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordedProgress records the progress notifications of a run.
+type recordedProgress struct {
+	mutex  sync.Mutex
+	events []string
+}
+
+func (p *recordedProgress) record(event string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.events = append(p.events, event)
+}
+
+func (p *recordedProgress) CollectorsEnabled(names []string) {
+	p.record("enabled " + strings.Join(names, ","))
+}
+
+func (p *recordedProgress) CollectorStarted(name string) {
+	p.record("started " + name)
+}
+
+func (p *recordedProgress) CollectorDone(name string, resources, errors int) {
+	p.record("done " + name)
+}
+
+func TestCollectErrorThreshold(t *testing.T) {
+	srv := fakegcp.NewServer(nil)
+	endpoint := srv.Start()
+	t.Cleanup(srv.Close)
+	// The fixtures have no subnetworks in two of the regions, which makes
+	// two collection errors.
+	newConfig := func(maxErrors int) *config.Config {
+		cfg := config.NewConfig()
+		cfg.OrgId = "111111111111"
+		cfg.Regions = config.Regions{"me-west1", "me-central1", "europe-west1"}
+		cfg.Zones = config.Zones{"me-west1-a"}
+		cfg.Collectors = []string{network.VPCCollectorName}
+		cfg.APIEndpoint = endpoint
+		cfg.RetryInitialBackoff = time.Millisecond
+		cfg.MaxErrors = maxErrors
+		return cfg
+	}
+	log := logger.NewLogger("test", "error")
+	tests := []struct {
+		maxErrors int
+		wantErr   string
+	}{
+		{maxErrors: -1},
+		{maxErrors: 2},
+		{maxErrors: 1, wantErr: "exceeding the maximum of 1"},
+		{maxErrors: 0, wantErr: "exceeding the maximum of 0"},
+	}
+	for _, test := range tests {
+		progress := &recordedProgress{}
+		snapshot, err := NewExporter(newConfig(test.maxErrors), log).SetProgress(progress).Collect(context.Background())
+		if test.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Errorf("max errors %d: got error %v, expected %q", test.maxErrors, err, test.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("max errors %d: %s", test.maxErrors, err.Error())
+			continue
+		}
+		if got := snapshot.Errors.CountByCollector()[network.VPCCollectorName]; got != 2 {
+			t.Errorf("max errors %d: got %d vpc errors, expected 2", test.maxErrors, got)
+		}
+		want := []string{"enabled " + network.VPCCollectorName, "started " + network.VPCCollectorName, "done " + network.VPCCollectorName}
+		if !reflect.DeepEqual(progress.events, want) {
+			t.Errorf("got progress %v, expected %v", progress.events, want)
+		}
+	}
+}
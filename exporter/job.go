@@ -0,0 +1,170 @@
+package exporter
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/liornabat/gcp_inventory_exporter/pkg/logger"
+	"regexp"
+	"sync"
+	"time"
+)
+
+const (
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobFailed    = "failed"
+
+	CollectorPending = "pending"
+	CollectorRunning = "running"
+	CollectorDone    = "done"
+
+	// JobPrefix holds the state of each job.
+	JobPrefix = "jobs/"
+)
+
+var jobIDPattern = regexp.MustCompile(`^[0-9]{8}-[0-9]{6}-[0-9a-f]{8}$`)
+
+// JobStore persists the job states in the export bucket, see
+// storage.Storage.
+type JobStore interface {
+	SaveFile(ctx context.Context, bucketName, objectName, contentType string, objectData []byte) error
+	ReadFile(ctx context.Context, bucketName, objectName string) ([]byte, error)
+}
+
+type CollectorProgress struct {
+	State     string `json:"state"`
+	Resources int    `json:"resources"`
+	Errors    int    `json:"errors"`
+}
+
+// Job is an export running in the background. Its state is saved to the
+// export bucket on every change, so it can be polled from any instance.
+type Job struct {
+	ID         string                        `json:"id"`
+	State      string                        `json:"state"`
+	StartTime  time.Time                     `json:"startTime"`
+	EndTime    *time.Time                    `json:"endTime,omitempty"`
+	Collectors map[string]*CollectorProgress `json:"collectors"`
+	OutputURI  string                        `json:"outputUri,omitempty"`
+	Summary    string                        `json:"summary,omitempty"`
+	Error      string                        `json:"error,omitempty"`
+
+	mutex     sync.Mutex
+	saveMutex sync.Mutex
+	ctx       context.Context
+	log       *logger.Logger
+	store     JobStore
+	bucket    string
+}
+
+func NewJobID() string {
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+	return time.Now().UTC().Format("20060102-150405") + "-" + hex.EncodeToString(suffix)
+}
+
+func ValidJobID(id string) bool {
+	return jobIDPattern.MatchString(id)
+}
+
+// JobObjectName returns the name of the object holding the state of a job.
+func JobObjectName(id string) string {
+	return JobPrefix + id + ".json"
+}
+
+func NewJob(ctx context.Context, log *logger.Logger, store JobStore, bucket string) *Job {
+	return &Job{
+		ID:         NewJobID(),
+		State:      JobRunning,
+		StartTime:  time.Now(),
+		Collectors: map[string]*CollectorProgress{},
+		ctx:        ctx,
+		log:        log,
+		store:      store,
+		bucket:     bucket,
+	}
+}
+
+// LoadJob reads the state of a job from the export bucket.
+func LoadJob(ctx context.Context, store JobStore, bucket, id string) (*Job, error) {
+	if !ValidJobID(id) {
+		return nil, fmt.Errorf("invalid job id %q", id)
+	}
+	data, err := store.ReadFile(ctx, bucket, JobObjectName(id))
+	if err != nil {
+		return nil, err
+	}
+	job := &Job{}
+	if err := json.Unmarshal(data, job); err != nil {
+		return nil, fmt.Errorf("invalid state of job %s: %s", id, err.Error())
+	}
+	return job, nil
+}
+
+// Save writes the job state to the export bucket. Saves are serialized and
+// each writes the latest state, so an older state never overwrites a newer
+// one.
+func (j *Job) Save() error {
+	j.saveMutex.Lock()
+	defer j.saveMutex.Unlock()
+	j.mutex.Lock()
+	data, err := json.MarshalIndent(j, "", "  ")
+	j.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+	return j.store.SaveFile(j.ctx, j.bucket, JobObjectName(j.ID), "application/json", data)
+}
+
+// update changes the job under its lock and saves it. A failed save is only
+// logged, the job keeps running and the next update saves it again.
+func (j *Job) update(fn func()) {
+	j.mutex.Lock()
+	fn()
+	j.mutex.Unlock()
+	if err := j.Save(); err != nil {
+		j.log.Errorf("Failed to save state of job %s: %s", j.ID, err.Error())
+	}
+}
+
+func (j *Job) CollectorsEnabled(names []string) {
+	j.update(func() {
+		for _, name := range names {
+			j.Collectors[name] = &CollectorProgress{State: CollectorPending}
+		}
+	})
+}
+
+func (j *Job) CollectorStarted(name string) {
+	j.update(func() {
+		j.Collectors[name] = &CollectorProgress{State: CollectorRunning}
+	})
+}
+
+func (j *Job) CollectorDone(name string, resources, errors int) {
+	j.update(func() {
+		j.Collectors[name] = &CollectorProgress{State: CollectorDone, Resources: resources, Errors: errors}
+	})
+}
+
+func (j *Job) Succeed(outputURI, summary string) {
+	j.update(func() {
+		now := time.Now()
+		j.State = JobSucceeded
+		j.EndTime = &now
+		j.OutputURI = outputURI
+		j.Summary = summary
+	})
+}
+
+func (j *Job) Fail(err error) {
+	j.update(func() {
+		now := time.Now()
+		j.State = JobFailed
+		j.EndTime = &now
+		j.Error = err.Error()
+	})
+}
@@ -0,0 +1,165 @@
+package exporter
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/liornabat/gcp_inventory_exporter/pkg/logger"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// memoryStore is a JobStore that keeps the objects in memory and can be made
+// to fail the saves.
+type memoryStore struct {
+	mutex   sync.Mutex
+	objects map[string][]byte
+	saves   int
+	fail    bool
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{objects: map[string][]byte{}}
+}
+
+func (s *memoryStore) SaveFile(ctx context.Context, bucketName, objectName, contentType string, objectData []byte) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.fail {
+		return errors.New("bucket unavailable")
+	}
+	s.saves++
+	s.objects[bucketName+"/"+objectName] = objectData
+	return nil
+}
+
+func (s *memoryStore) ReadFile(ctx context.Context, bucketName, objectName string) ([]byte, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	data, ok := s.objects[bucketName+"/"+objectName]
+	if !ok {
+		return nil, fmt.Errorf("object %s not found", objectName)
+	}
+	return data, nil
+}
+
+func TestJob(t *testing.T) {
+	ctx := context.Background()
+	store := newMemoryStore()
+	job := NewJob(ctx, logger.NewLogger("test", "error"), store, "inv")
+	if !ValidJobID(job.ID) || job.State != JobRunning {
+		t.Fatalf("got job %s in state %s", job.ID, job.State)
+	}
+
+	job.CollectorsEnabled([]string{"compute", "vpc"})
+	job.CollectorStarted("compute")
+	loaded, err := LoadJob(ctx, store, "inv", job.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.State != JobRunning || loaded.Collectors["compute"].State != CollectorRunning || loaded.Collectors["vpc"].State != CollectorPending {
+		t.Errorf("got saved state %s with collectors %v", loaded.State, loaded.Collectors)
+	}
+
+	job.CollectorDone("compute", 3, 1)
+	job.CollectorDone("vpc", 2, 0)
+	job.Succeed("gs://inv/inventory.xlsx", "5 resources")
+	loaded, err = LoadJob(ctx, store, "inv", job.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.State != JobSucceeded || loaded.EndTime == nil || loaded.OutputURI != "gs://inv/inventory.xlsx" || loaded.Summary != "5 resources" {
+		t.Errorf("got saved state %s ending at %v with output %q and summary %q", loaded.State, loaded.EndTime, loaded.OutputURI, loaded.Summary)
+	}
+	if progress := loaded.Collectors["compute"]; *progress != (CollectorProgress{State: CollectorDone, Resources: 3, Errors: 1}) {
+		t.Errorf("got compute progress %+v", *progress)
+	}
+	if store.saves != 5 {
+		t.Errorf("got %d saves, expected one per update", store.saves)
+	}
+}
+
+func TestJobFail(t *testing.T) {
+	ctx := context.Background()
+	store := newMemoryStore()
+	job := NewJob(ctx, logger.NewLogger("test", "error"), store, "inv")
+
+	// A failed save does not stop the job, the next update saves it.
+	store.fail = true
+	job.CollectorsEnabled([]string{"compute"})
+	if _, err := LoadJob(ctx, store, "inv", job.ID); err == nil {
+		t.Fatalf("job was saved while the store was failing")
+	}
+	store.fail = false
+	job.Fail(errors.New("failed to get projects"))
+	loaded, err := LoadJob(ctx, store, "inv", job.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.State != JobFailed || loaded.EndTime == nil || loaded.Error != "failed to get projects" || loaded.Collectors["compute"] == nil {
+		t.Errorf("got saved state %s ending at %v with error %q and collectors %v", loaded.State, loaded.EndTime, loaded.Error, loaded.Collectors)
+	}
+}
+
+func TestJobConcurrentUpdates(t *testing.T) {
+	ctx := context.Background()
+	store := newMemoryStore()
+	job := NewJob(ctx, logger.NewLogger("test", "error"), store, "inv")
+	var names []string
+	for i := 0; i < 20; i++ {
+		names = append(names, fmt.Sprintf("collector-%d", i))
+	}
+	job.CollectorsEnabled(names)
+	wg := &sync.WaitGroup{}
+	for _, name := range names {
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+			job.CollectorStarted(name)
+			job.CollectorDone(name, 1, 0)
+		}(name)
+	}
+	wg.Wait()
+	// The last save holds the latest state.
+	loaded, err := LoadJob(ctx, store, "inv", job.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range names {
+		if progress := loaded.Collectors[name]; progress == nil || progress.State != CollectorDone {
+			t.Errorf("collector %s was saved as %v", name, progress)
+		}
+	}
+}
+
+func TestLoadJob(t *testing.T) {
+	ctx := context.Background()
+	store := newMemoryStore()
+	store.objects["inv/"+JobObjectName("20260101-000000-0000000a")] = []byte("not json")
+	tests := []struct {
+		id      string
+		wantErr string
+	}{
+		{"../latest", "invalid job id"},
+		{"20260101-000000-ABCDEF12", "invalid job id"},
+		{"20260101-000000-00000000", "not found"},
+		{"20260101-000000-0000000a", "invalid state of job"},
+	}
+	for _, test := range tests {
+		if _, err := LoadJob(ctx, store, "inv", test.id); err == nil || !strings.Contains(err.Error(), test.wantErr) {
+			t.Errorf("%s: got error %v, expected %q", test.id, err, test.wantErr)
+		}
+	}
+
+	data, err := json.Marshal(map[string]string{"id": "20260101-000000-0000000b", "state": JobSucceeded})
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.objects["inv/jobs/20260101-000000-0000000b.json"] = data
+	job, err := LoadJob(ctx, store, "inv", "20260101-000000-0000000b")
+	if err != nil || job.State != JobSucceeded {
+		t.Errorf("got job %v and error %v", job, err)
+	}
+}
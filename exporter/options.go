@@ -0,0 +1,166 @@
+package exporter
+
+import (
+	"fmt"
+	"github.com/liornabat/gcp_inventory_exporter/collector"
+	"github.com/liornabat/gcp_inventory_exporter/config"
+	"github.com/liornabat/gcp_inventory_exporter/output"
+	"strings"
+)
+
+// Options are the per-export choices a caller can make on top of the server
+// configuration.
+type Options struct {
+	Collectors []string `json:"collectors,omitempty"`
+	Projects   []string `json:"projects,omitempty"`
+	Regions    []string `json:"regions,omitempty"`
+	Zones      []string `json:"zones,omitempty"`
+	Format     string   `json:"format,omitempty"`
+	ObjectName string   `json:"objectName,omitempty"`
+	// ProjectFilter is applied on top of the server project filters, so it
+	// can only narrow the exported projects.
+	ProjectFilter *config.ProjectFilter `json:"projectFilter,omitempty"`
+	// Async runs the export in the background as a job whose state is kept
+	// in the export bucket.
+	Async bool `json:"async,omitempty"`
+	// Diff saves the changes since the previous export next to the export.
+	Diff bool `json:"diff,omitempty"`
+}
+
+func subsetOf(values, allowed []string, what string) error {
+	if len(allowed) == 0 {
+		return nil
+	}
+	for _, value := range values {
+		if !contains(allowed, value) {
+			return fmt.Errorf("%s %s is not enabled on this server", what, value)
+		}
+	}
+	return nil
+}
+
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Apply returns a copy of cfg narrowed by the options. Options may only narrow
+// the server configuration: collectors, projects, regions and zones must be
+// enabled in cfg. When cfg discovers the regions or zones, the requested ones
+// filter the discovered ones instead.
+func (o *Options) Apply(cfg *config.Config) (*config.Config, error) {
+	c := *cfg
+	if o == nil {
+		return &c, nil
+	}
+	if len(o.Collectors) > 0 {
+		for _, name := range o.Collectors {
+			if !collector.IsRegistered(name) {
+				return nil, fmt.Errorf("unknown collector %s", name)
+			}
+		}
+		if err := subsetOf(o.Collectors, cfg.Collectors, "collector"); err != nil {
+			return nil, err
+		}
+		c.Collectors = o.Collectors
+	}
+	if len(o.Projects) > 0 {
+		if err := subsetOf(o.Projects, cfg.Projects, "project"); err != nil {
+			return nil, err
+		}
+		c.Projects = o.Projects
+	}
+	if o.ProjectFilter != nil {
+		if _, err := o.ProjectFilter.Filter(); err != nil {
+			return nil, fmt.Errorf("invalid project filter: %s", err.Error())
+		}
+		c.ProjectFilters = append(append([]config.ProjectFilter{}, cfg.ProjectFilters...), *o.ProjectFilter)
+	}
+	// In auto mode the requested regions and zones narrow the ones
+	// discovered in each project.
+	if len(o.Regions) > 0 {
+		if contains(o.Regions, config.Auto) {
+			return nil, fmt.Errorf("region %s cannot be requested", config.Auto)
+		}
+		if cfg.Regions.IsAuto() {
+			if err := subsetOf(o.Regions, cfg.RegionFilter, "region"); err != nil {
+				return nil, err
+			}
+			c.RegionFilter = o.Regions
+		} else {
+			if err := subsetOf(o.Regions, cfg.Regions, "region"); err != nil {
+				return nil, err
+			}
+			c.Regions = o.Regions
+		}
+	}
+	if len(o.Zones) > 0 {
+		if contains(o.Zones, config.Auto) {
+			return nil, fmt.Errorf("zone %s cannot be requested", config.Auto)
+		}
+		if cfg.Zones.IsAuto() {
+			if err := subsetOf(o.Zones, cfg.ZoneFilter, "zone"); err != nil {
+				return nil, err
+			}
+			c.ZoneFilter = o.Zones
+		} else {
+			if err := subsetOf(o.Zones, cfg.Zones, "zone"); err != nil {
+				return nil, err
+			}
+			c.Zones = o.Zones
+		}
+	}
+	if o.Format != "" {
+		if _, err := output.Get(o.Format); err != nil {
+			return nil, err
+		}
+		c.OutputFormat = o.Format
+	}
+	if o.Diff {
+		c.DiffPrevious = true
+	}
+	if c.DiffPrevious && c.OutputFormat != "xlsx" {
+		return nil, fmt.Errorf("diff needs the xlsx format, got %s", c.OutputFormat)
+	}
+	if o.ObjectName != "" {
+		if err := validateObjectName(o.ObjectName); err != nil {
+			return nil, err
+		}
+	}
+	return &c, nil
+}
+
+// validateObjectName checks a requested object name. Names must start with
+// output.ObjectPrefix, so an export cannot overwrite the manifests, job
+// records, changes or Parquet files kept in the same bucket, and is found by
+// retention and by the previous export lookup.
+func validateObjectName(name string) error {
+	if !strings.HasPrefix(name, output.ObjectPrefix) {
+		return fmt.Errorf("object name %q must start with %q", name, output.ObjectPrefix)
+	}
+	if len(name) > 1024 {
+		return fmt.Errorf("object name is longer than 1024 bytes")
+	}
+	if strings.ContainsAny(name, "\r\n") {
+		return fmt.Errorf("invalid object name %q", name)
+	}
+	for _, segment := range strings.Split(name, "/") {
+		if segment == "" || segment == "." || segment == ".." {
+			return fmt.Errorf("invalid object name %q", name)
+		}
+	}
+	return nil
+}
+
+// GetObjectName returns the requested object name, or defaultName when none
+// was requested.
+func (o *Options) GetObjectName(defaultName string) string {
+	if o == nil || o.ObjectName == "" {
+		return defaultName
+	}
+	return o.ObjectName
+}
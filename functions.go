@@ -1,21 +1,31 @@
 package gcp_inventory_exporter
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/GoogleCloudPlatform/functions-framework-go/functions"
-	"github.com/liornabat/gcp_inventory_exporter/compute"
+	"github.com/liornabat/gcp_inventory_exporter/bigquery"
 	"github.com/liornabat/gcp_inventory_exporter/config"
-	"github.com/liornabat/gcp_inventory_exporter/network"
+	"github.com/liornabat/gcp_inventory_exporter/diff"
+	"github.com/liornabat/gcp_inventory_exporter/exporter"
+	"github.com/liornabat/gcp_inventory_exporter/inventory"
+	"github.com/liornabat/gcp_inventory_exporter/output"
 	"github.com/liornabat/gcp_inventory_exporter/pkg/logger"
-	"github.com/liornabat/gcp_inventory_exporter/pkg/xls"
-	"github.com/liornabat/gcp_inventory_exporter/project"
 	"github.com/liornabat/gcp_inventory_exporter/storage"
+	"io"
 	"net/http"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 )
 
 func init() {
 	functions.HTTP("ExportInventory", processInventory)
+	functions.CloudEvent("ExportInventoryEvent", processInventoryEvent)
 }
 func setResponse(w http.ResponseWriter, code int, msg string) {
 	w.WriteHeader(code)
@@ -27,136 +37,450 @@ func setErrorResponse(w http.ResponseWriter, code int, err error) {
 	w.Write([]byte(err.Error()))
 }
 
+func setJSONResponse(w http.ResponseWriter, code int, value interface{}) {
+	data, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		setErrorResponse(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	w.Write(data)
+}
+
 func processInventory(w http.ResponseWriter, r *http.Request) {
 	log := logger.NewLogger("ExportInventory", "debug")
 	log.Infof("ExportInventory Started")
-	cfg := config.DefaultConfig
-	if err := cfg.Validate(); err != nil {
-		log.Errorf("Failed to validate config: %s", err.Error())
+	serverConfig, err := config.LoadDefault()
+	if err != nil {
+		log.Errorf("Failed to load config: %s", err.Error())
 		setErrorResponse(w, http.StatusInternalServerError, err)
 		return
 	}
-	storageClient, err := storage.NewStorage(r.Context(), cfg.ExportProjectId)
-	if err != nil {
-		log.Errorf("Failed to create storage client: %s", err.Error())
+	if err := serverConfig.Validate(); err != nil {
+		log.Errorf("Failed to validate config: %s", err.Error())
 		setErrorResponse(w, http.StatusInternalServerError, err)
 		return
 	}
-	defer storageClient.Close()
-	err = storageClient.BucketExistsOrCreate(r.Context(), cfg.ExportBucketName)
+	if r.Method == http.MethodGet && r.URL.Query().Has("schema") {
+		w.Header().Set("Content-Type", "application/schema+json")
+		setResponse(w, http.StatusOK, string(output.JSONSchema))
+		return
+	}
+	if jobId := r.URL.Query().Get("jobId"); r.Method == http.MethodGet && jobId != "" {
+		getJobStatus(w, r, log, serverConfig, jobId)
+		return
+	}
+	if query := r.URL.Query(); r.Method == http.MethodGet && (query.Has("snapshot") || query.Has("snapshots")) {
+		getSnapshots(w, r, log, serverConfig)
+		return
+	}
+	opts, err := parseExportOptions(r)
 	if err != nil {
-		log.Errorf("Failed to create bucket: %s", err.Error())
-		setErrorResponse(w, http.StatusInternalServerError, err)
+		log.Errorf("Failed to parse export options: %s", err.Error())
+		setErrorResponse(w, http.StatusBadRequest, err)
 		return
 	}
-	xlsFile := xls.NewXls()
-
-	projects, err := project.GetProjects(r.Context(), log)
+	cfg, err := opts.Apply(serverConfig)
 	if err != nil {
-		log.Errorf("Failed to get projects: %s", err.Error())
-		setErrorResponse(w, http.StatusInternalServerError, err)
+		log.Errorf("Failed to apply export options: %s", err.Error())
+		setErrorResponse(w, http.StatusBadRequest, err)
 		return
 	}
-
-	compute, err := compute.GetComputeInventory(r.Context(), projects, cfg.Zones, log)
+	// The clients of an async export are used after the response is written
+	// and the request context canceled, so they are created apart from it.
+	ctx := r.Context()
+	if opts.Async {
+		ctx = context.Background()
+	}
+	run, err := newExportRun(ctx, log, cfg, opts)
 	if err != nil {
-		log.Errorf("Failed to get compute inventory: %s", err.Error())
 		setErrorResponse(w, http.StatusInternalServerError, err)
 		return
 	}
-	if err := xlsFile.SetDataToSheet("Compute", compute); err != nil {
-		log.Errorf("Failed to add compute sheet: %s", err.Error())
-		setErrorResponse(w, http.StatusInternalServerError, err)
+	if opts.Async {
+		startJob(w, r, log, run)
 		return
 	}
+	defer run.Close()
 
-	vpc, err := network.GetVPCInventory(r.Context(), projects, cfg.Regions, log)
+	result, err := run.export(ctx, log)
 	if err != nil {
-		log.Errorf("Failed to get vpc inventory: %s", err.Error())
 		setErrorResponse(w, http.StatusInternalServerError, err)
 		return
 	}
-	if err := xlsFile.SetDataToSheet("VPC", vpc); err != nil {
-		log.Errorf("Failed to add vpc sheet: %s", err.Error())
-		setErrorResponse(w, http.StatusInternalServerError, err)
-		return
+	log.Infof("Inventory exported to %s", result)
+	setResponse(w, http.StatusOK, fmt.Sprintf("Inventory exported to %s", result))
+}
+
+// exportRun is an export with validated options, ready to run.
+type exportRun struct {
+	cfg           *config.Config
+	opts          *exporter.Options
+	format        output.Format
+	diffRenderer  *diff.Renderer
+	exp           *exporter.Exporter
+	storageClient *storage.Storage
+	// bigQuerySink is set when the snapshots are appended to BigQuery.
+	bigQuerySink *bigquery.Sink
+}
+
+// newExportRun creates the exporter and the storage client of an export and
+// makes sure the export bucket exists.
+func newExportRun(ctx context.Context, log *logger.Logger, cfg *config.Config, opts *exporter.Options) (*exportRun, error) {
+	format, err := output.ForConfig(cfg)
+	if err != nil {
+		log.Errorf("Failed to get output format: %s", err.Error())
+		return nil, err
+	}
+	var diffRenderer *diff.Renderer
+	if cfg.DiffPrevious {
+		if diffRenderer, err = diff.GetRenderer(cfg.DiffFormat); err != nil {
+			log.Errorf("Failed to get diff format: %s", err.Error())
+			return nil, err
+		}
 	}
+	exp := exporter.NewExporter(cfg, log)
+	storageClient, err := exp.NewStorage(ctx)
+	if err != nil {
+		log.Errorf("Failed to create storage client: %s", err.Error())
+		return nil, err
+	}
+	if err := storageClient.BucketExistsOrCreate(ctx, cfg.ExportBucketName); err != nil {
+		storageClient.Close()
+		log.Errorf("Failed to create bucket: %s", err.Error())
+		return nil, err
+	}
+	var bigQuerySink *bigquery.Sink
+	if cfg.BigQueryEnabled() {
+		if bigQuerySink, err = exp.NewBigQuerySink(ctx); err != nil {
+			storageClient.Close()
+			log.Errorf("Failed to create BigQuery client: %s", err.Error())
+			return nil, err
+		}
+	}
+	return &exportRun{
+		cfg:           cfg,
+		opts:          opts,
+		format:        format,
+		diffRenderer:  diffRenderer,
+		exp:           exp,
+		storageClient: storageClient,
+		bigQuerySink:  bigQuerySink,
+	}, nil
+}
 
-	ipAddress, err := network.GetIPAddressInventory(r.Context(), projects, cfg.Zones, log)
+func (e *exportRun) Close() error {
+	return e.storageClient.Close()
+}
+
+// exportResult describes a completed export.
+type exportResult struct {
+	uri      string
+	snapshot *inventory.Snapshot
+	// changes is set when the export was compared with the previous one.
+	changes    *diff.Diff
+	changesURI string
+	// parquetURIs are the Parquet files uploaded next to the export.
+	parquetURIs []string
+}
+
+// summary describes the collection errors and retries and the changes since
+// the previous export.
+func (r *exportResult) summary() string {
+	summary := r.snapshot.Summary()
+	if r.changes != nil {
+		summary += fmt.Sprintf("; %s in %s", r.changes.Summary(), r.changesURI)
+	}
+	return summary
+}
+
+func (r *exportResult) String() string {
+	return fmt.Sprintf("%s with %s", r.uri, r.summary())
+}
+
+func (r *exportResult) objectURIs() []string {
+	uris := []string{r.uri}
+	if r.changesURI != "" {
+		uris = append(uris, r.changesURI)
+	}
+	return append(uris, r.parquetURIs...)
+}
+
+// export runs the export and adds its manifest to the snapshot catalog of the
+// bucket, whether it succeeded or not.
+func (e *exportRun) export(ctx context.Context, log *logger.Logger) (*exportResult, error) {
+	cfg := e.cfg
+	startTime := time.Now()
+	result, err := e.run(ctx, log)
+	var manifest *storage.Manifest
 	if err != nil {
-		log.Errorf("Failed to get ip address inventory: %s", err.Error())
-		setErrorResponse(w, http.StatusInternalServerError, err)
-		return
+		manifest = storage.NewFailedManifest(cfg.OrgId, startTime, cfg.Hash(), err)
+	} else {
+		manifest = storage.NewManifest(result.snapshot, cfg.Hash(), result.objectURIs()...)
 	}
-	if err := xlsFile.SetDataToSheet("IP Addresses", ipAddress); err != nil {
-		log.Errorf("Failed to add ip address sheet: %s", err.Error())
-		setErrorResponse(w, http.StatusInternalServerError, err)
-		return
+	if err := e.storageClient.SaveManifest(ctx, cfg.ExportBucketName, manifest); err != nil {
+		log.Errorf("Failed to save the manifest of run %s: %s", manifest.RunID, err.Error())
 	}
+	return result, err
+}
 
-	routes, err := network.GetRoutesInventory(r.Context(), projects, log)
+// run collects the inventory and saves it to the export bucket, along with
+// the changes since the previous export when asked to.
+func (e *exportRun) run(ctx context.Context, log *logger.Logger) (*exportResult, error) {
+	cfg, opts, format := e.cfg, e.opts, e.format
+	snapshot, err := e.exp.Collect(ctx)
 	if err != nil {
-		log.Errorf("Failed to get routes inventory: %s", err.Error())
-		setErrorResponse(w, http.StatusInternalServerError, err)
-		return
+		log.Errorf("Failed to collect inventory: %s", err.Error())
+		return nil, err
 	}
-	if err := xlsFile.SetDataToSheet("Routes", routes); err != nil {
-		log.Errorf("Failed to add routes sheet: %s", err.Error())
-		setErrorResponse(w, http.StatusInternalServerError, err)
-		return
+	objectName := opts.GetObjectName(output.ObjectName(snapshot, format))
+	// The previous export is looked up before saving this one, which could
+	// otherwise be taken for it.
+	var previous, previousID string
+	if cfg.DiffPrevious {
+		if previous, previousID, err = e.previousExport(ctx); err != nil {
+			log.Errorf("Failed to find the previous export: %s", err.Error())
+		}
+	}
+	// The export is streamed into the object as it is written, so large
+	// inventories are not held in memory twice.
+	err = e.storageClient.WriteFile(ctx, cfg.ExportBucketName, objectName, format.ContentType(), func(w io.Writer) error {
+		return format.Write(w, snapshot)
+	})
+	if err != nil {
+		log.Errorf("Failed to save %s inventory: %s", format.Name(), err.Error())
+		return nil, err
+	}
+	result := &exportResult{
+		uri:      fmt.Sprintf("gs://%s/%s", cfg.ExportBucketName, objectName),
+		snapshot: snapshot,
+	}
+	if cfg.ParquetAlongside {
+		if err := e.saveParquet(ctx, result); err != nil {
+			log.Errorf("Failed to save the Parquet files: %s", err.Error())
+			return nil, err
+		}
+	}
+	if e.bigQuerySink != nil {
+		if err := e.bigQuerySink.Write(ctx, snapshot); err != nil {
+			log.Errorf("Failed to write inventory to BigQuery: %s", err.Error())
+			return nil, err
+		}
+	}
+	if cfg.DiffPrevious {
+		if previous == "" {
+			log.Infof("No previous xlsx export with the same config to compare with")
+		} else if err := e.saveChanges(ctx, previous, previousID, result); err != nil {
+			log.Errorf("Failed to save the changes since %s: %s", previous, err.Error())
+		}
 	}
+	// A failed cleanup does not fail the export, the next run retries it.
+	// The objects and manifest of a run are kept or pruned together, and the
+	// job records are counted apart from the runs.
+	policy := cfg.RetentionPolicy()
+	for _, prefixes := range [][]string{
+		{output.ObjectPrefix, diff.ObjectPrefix, output.ParquetPrefix, storage.CatalogPrefix},
+		{exporter.JobPrefix},
+	} {
+		if _, err := e.storageClient.EnforceRetention(ctx, log, cfg.ExportBucketName, policy, prefixes...); err != nil {
+			log.Errorf("Failed to enforce retention: %s", err.Error())
+		}
+	}
+	return result, nil
+}
 
-	peering, err := network.GetPreeingInventory(r.Context(), projects, log)
+// saveParquet uploads a Parquet file per collector next to the export.
+func (e *exportRun) saveParquet(ctx context.Context, result *exportResult) error {
+	snapshot := result.snapshot
+	for _, table := range snapshot.Tables {
+		objectName := output.ParquetObjectName(snapshot, table)
+		err := e.storageClient.WriteFile(ctx, e.cfg.ExportBucketName, objectName, output.ParquetContentType, func(w io.Writer) error {
+			return output.WriteParquet(w, snapshot, table)
+		})
+		if err != nil {
+			return fmt.Errorf("%s: %s", table.Collector, err.Error())
+		}
+		result.parquetURIs = append(result.parquetURIs, fmt.Sprintf("gs://%s/%s", e.cfg.ExportBucketName, objectName))
+	}
+	return nil
+}
+
+// previousExport returns the object name and run id of the export of the
+// last successful run with the same config, read from the snapshot catalog,
+// or "" when there is none. Runs with other collectors or project filters
+// are passed over, since their resources differ from this run's. The export
+// is the first object of the manifest.
+func (e *exportRun) previousExport(ctx context.Context) (string, string, error) {
+	manifest, err := e.storageClient.LatestManifestWithConfig(ctx, e.cfg.ExportBucketName, e.cfg.Hash())
+	if err == storage.ErrObjectNotExist {
+		return "", "", nil
+	}
+	if err != nil {
+		return "", "", err
+	}
+	if len(manifest.ObjectURIs) == 0 {
+		return "", "", nil
+	}
+	prefix := fmt.Sprintf("gs://%s/", e.cfg.ExportBucketName)
+	uri := manifest.ObjectURIs[0]
+	if !strings.HasPrefix(uri, prefix) || !strings.HasSuffix(uri, ".xlsx") {
+		return "", "", nil
+	}
+	return strings.TrimPrefix(uri, prefix), manifest.RunID, nil
+}
+
+// saveChanges compares the result snapshot with the previous export and
+// saves the changes next to the export. The projects skipped or failed in
+// this run are left out, as their resources may be missing from it.
+func (e *exportRun) saveChanges(ctx context.Context, previous, fromID string, result *exportResult) error {
+	cfg, renderer := e.cfg, e.diffRenderer
+	data, err := e.storageClient.ReadFile(ctx, cfg.ExportBucketName, previous)
+	if err != nil {
+		return err
+	}
+	from, err := diff.ReadXlsx(bytes.NewReader(data), diff.Keys())
 	if err != nil {
-		log.Errorf("Failed to get peering inventory: %s", err.Error())
+		return fmt.Errorf("failed to read %s: %s", previous, err.Error())
+	}
+	snapshot := result.snapshot
+	excluded := map[string]bool{}
+	for _, e := range snapshot.Errors.List() {
+		if e.Project != "" {
+			excluded[e.Project] = true
+		}
+	}
+	for _, p := range snapshot.Skipped {
+		excluded[p.ID] = true
+	}
+	changes := diff.Compare(fromID, diff.ExcludeProjects(from, excluded), snapshot.RunID, diff.ExcludeProjects(diff.FromSnapshot(snapshot), excluded))
+	for id := range excluded {
+		changes.ExcludedProjects = append(changes.ExcludedProjects, id)
+	}
+	sort.Strings(changes.ExcludedProjects)
+	changesData := &bytes.Buffer{}
+	if err := renderer.Write(changesData, changes); err != nil {
+		return err
+	}
+	objectName := diff.ObjectName(changes, renderer)
+	if err := e.storageClient.SaveFile(ctx, cfg.ExportBucketName, objectName, renderer.ContentType, changesData.Bytes()); err != nil {
+		return err
+	}
+	result.changes = changes
+	result.changesURI = fmt.Sprintf("gs://%s/%s", cfg.ExportBucketName, objectName)
+	return nil
+}
+
+// startJob runs the export in the background and responds with 202 and the
+// job id, which GET ?jobId=<id> reports the state of. The background work
+// needs an instance that keeps its CPU allocated after the response, e.g.
+// Cloud Functions 2nd gen with CPU always allocated.
+func startJob(w http.ResponseWriter, r *http.Request, log *logger.Logger, run *exportRun) {
+	ctx := context.Background()
+	cfg := run.cfg
+	job := exporter.NewJob(ctx, log, run.storageClient, cfg.ExportBucketName)
+	if err := job.Save(); err != nil {
+		run.Close()
+		log.Errorf("Failed to save job: %s", err.Error())
 		setErrorResponse(w, http.StatusInternalServerError, err)
 		return
 	}
-	if err := xlsFile.SetDataToSheet("VPC Peering", peering); err != nil {
-		log.Errorf("Failed to add peering sheet: %s", err.Error())
-		setErrorResponse(w, http.StatusInternalServerError, err)
+	run.exp.SetProgress(job)
+	go func() {
+		defer run.Close()
+		result, err := run.export(ctx, log)
+		if err != nil {
+			job.Fail(err)
+			return
+		}
+		job.Succeed(result.uri, result.summary())
+		log.Infof("Job %s exported the inventory to %s", job.ID, result)
+	}()
+	log.Infof("Job %s started", job.ID)
+	statusURL := *r.URL
+	statusURL.RawQuery = "jobId=" + job.ID
+	w.Header().Set("Location", statusURL.String())
+	setJSONResponse(w, http.StatusAccepted, map[string]string{
+		"jobId":     job.ID,
+		"state":     exporter.JobRunning,
+		"statusUrl": statusURL.String(),
+	})
+}
+
+func getJobStatus(w http.ResponseWriter, r *http.Request, log *logger.Logger, cfg *config.Config, jobId string) {
+	if !exporter.ValidJobID(jobId) {
+		setErrorResponse(w, http.StatusBadRequest, fmt.Errorf("invalid job id %q", jobId))
 		return
 	}
-	firewall, err := network.GetFirewallInventory(r.Context(), projects, log)
+	storageClient, err := exporter.NewExporter(cfg, log).NewStorage(r.Context())
 	if err != nil {
-		log.Errorf("Failed to get firewall inventory: %s", err.Error())
+		log.Errorf("Failed to create storage client: %s", err.Error())
 		setErrorResponse(w, http.StatusInternalServerError, err)
 		return
 	}
-	if err := xlsFile.SetDataToSheet("Firewall", firewall); err != nil {
-		log.Errorf("Failed to add firewall sheet: %s", err.Error())
-		setErrorResponse(w, http.StatusInternalServerError, err)
+	defer storageClient.Close()
+	job, err := exporter.LoadJob(r.Context(), storageClient, cfg.ExportBucketName, jobId)
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		setErrorResponse(w, http.StatusNotFound, fmt.Errorf("job %s not found", jobId))
 		return
 	}
-	if err := xlsFile.DeleteSheet("Sheet1"); err != nil {
-		log.Errorf("Failed to delete default sheet: %s", err.Error())
+	if err != nil {
+		log.Errorf("Failed to load job %s: %s", jobId, err.Error())
 		setErrorResponse(w, http.StatusInternalServerError, err)
 		return
 	}
-	cloudStore, err := storageClient.GetStorageInventory(r.Context(), projects, log)
+	setJSONResponse(w, http.StatusOK, job)
+}
+
+// getSnapshots answers from the snapshot catalog: GET ?snapshot=<runId> or
+// ?snapshot=latest returns the manifest of a run, GET ?snapshots the
+// manifests of the last runs, newest first, up to ?limit=<n> (20 by default).
+func getSnapshots(w http.ResponseWriter, r *http.Request, log *logger.Logger, cfg *config.Config) {
+	query := r.URL.Query()
+	runID := query.Get("snapshot")
+	limit := 20
+	if query.Has("snapshot") && runID != "latest" && !storage.ValidRunID(runID) {
+		setErrorResponse(w, http.StatusBadRequest, fmt.Errorf("invalid run id %q", runID))
+		return
+	}
+	if value := query.Get("limit"); value != "" {
+		var err error
+		if limit, err = strconv.Atoi(value); err != nil || limit < 0 {
+			setErrorResponse(w, http.StatusBadRequest, fmt.Errorf("invalid limit %q", value))
+			return
+		}
+	}
+	storageClient, err := exporter.NewExporter(cfg, log).NewStorage(r.Context())
 	if err != nil {
-		log.Errorf("Failed to get cloud storage inventory: %s", err.Error())
+		log.Errorf("Failed to create storage client: %s", err.Error())
 		setErrorResponse(w, http.StatusInternalServerError, err)
 		return
 	}
-	if err := xlsFile.SetDataToSheet("Cloud Storage", cloudStore); err != nil {
-		log.Errorf("Failed to add cloud storage sheet: %s", err.Error())
-		setErrorResponse(w, http.StatusInternalServerError, err)
+	defer storageClient.Close()
+	if !query.Has("snapshot") {
+		manifests, err := storageClient.ListManifests(r.Context(), cfg.ExportBucketName, limit)
+		if err != nil {
+			log.Errorf("Failed to list snapshots: %s", err.Error())
+			setErrorResponse(w, http.StatusInternalServerError, err)
+			return
+		}
+		setJSONResponse(w, http.StatusOK, manifests)
 		return
 	}
-	objectName := fmt.Sprintf("inventory-%s.xlsx", time.Now().Format("2006-01-02-15-04-05"))
-	objectData, err := xlsFile.GetBytes()
-	if err != nil {
-		log.Errorf("Failed to get xls bytes: %s", err.Error())
-		setErrorResponse(w, http.StatusInternalServerError, err)
+	var manifest *storage.Manifest
+	if runID == "latest" {
+		manifest, err = storageClient.LatestManifest(r.Context(), cfg.ExportBucketName)
+	} else {
+		manifest, err = storageClient.GetManifest(r.Context(), cfg.ExportBucketName, runID)
+	}
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		setErrorResponse(w, http.StatusNotFound, fmt.Errorf("snapshot %s not found", runID))
 		return
 	}
-	err = storageClient.SaveFile(r.Context(), cfg.ExportBucketName, objectName, objectData)
 	if err != nil {
-		log.Errorf("Failed to save compute inventory: %s", err.Error())
+		log.Errorf("Failed to get snapshot %s: %s", runID, err.Error())
 		setErrorResponse(w, http.StatusInternalServerError, err)
 		return
 	}
-	log.Infof("Inventory exported to gs://%s/%s", cfg.ExportBucketName, objectName)
-	setResponse(w, http.StatusOK, fmt.Sprintf("Inventory exported to gs://%s/%s", cfg.ExportBucketName, objectName))
+	setJSONResponse(w, http.StatusOK, manifest)
 }
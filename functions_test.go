@@ -0,0 +1,229 @@
+package gcp_inventory_exporter
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"github.com/liornabat/gcp_inventory_exporter/diff"
+	"github.com/liornabat/gcp_inventory_exporter/exporter"
+	"github.com/liornabat/gcp_inventory_exporter/pkg/fakegcp"
+	"github.com/liornabat/gcp_inventory_exporter/pkg/xls"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"testing"
+	"time"
+)
+
+var update = flag.Bool("update", false, "update the golden files")
+
+const testBucket = "inv"
+
+// startFakeGCP serves the default fixtures and points the exporter config at
+// them.
+func startFakeGCP(t *testing.T) *fakegcp.Server {
+	t.Helper()
+	srv := fakegcp.NewServer(nil)
+	t.Setenv("API_ENDPOINT", srv.Start())
+	t.Cleanup(srv.Close)
+	t.Setenv("ORG_ID", "111111111111")
+	t.Setenv("REGIONS", "me-west1")
+	t.Setenv("ZONES", "me-west1-a,me-west1-b,me-west1-c")
+	t.Setenv("EXPORT_PROJECT_ID", "demo-project")
+	t.Setenv("EXPORT_BUCKET_NAME", testBucket)
+	t.Setenv("RETRY_INITIAL_BACKOFF", "10ms")
+	return srv
+}
+
+type goldenSheet struct {
+	Sheet string     `json:"sheet"`
+	Rows  [][]string `json:"rows"`
+}
+
+// readWorkbook returns the sheets and rows of an uploaded workbook.
+func readWorkbook(t *testing.T, srv *fakegcp.Server, objectName string) []*goldenSheet {
+	t.Helper()
+	data, ok := srv.Object(testBucket, objectName)
+	if !ok {
+		t.Fatalf("object %s was not uploaded", objectName)
+	}
+	xlsFile, err := xls.Open(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("failed to read %s: %s", objectName, err.Error())
+	}
+	defer xlsFile.Close()
+	var sheets []*goldenSheet
+	for _, name := range xlsFile.Sheets() {
+		rows, err := xlsFile.GetDataFromSheet(name)
+		if err != nil {
+			t.Fatalf("failed to read sheet %s: %s", name, err.Error())
+		}
+		sheets = append(sheets, &goldenSheet{Sheet: name, Rows: rows})
+	}
+	return sheets
+}
+
+// checkGolden compares sheets with the golden file, or rewrites it when the
+// tests run with -update.
+func checkGolden(t *testing.T, name string, sheets []*goldenSheet) {
+	t.Helper()
+	data, err := json.MarshalIndent(sheets, "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data = append(data, '\n')
+	path := filepath.Join("testdata", name)
+	if *update {
+		if err := os.WriteFile(path, data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	golden, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read golden file, run with -update to create it: %s", err.Error())
+	}
+	if !bytes.Equal(data, golden) {
+		t.Errorf("workbook does not match %s, run with -update to accept it:\n%s", path, data)
+	}
+}
+
+func TestProcessInventory(t *testing.T) {
+	srv := startFakeGCP(t)
+	r := httptest.NewRequest(http.MethodPost, "/?objectName=inventory-test.xlsx", nil)
+	w := httptest.NewRecorder()
+	processInventory(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d: %s", w.Code, w.Body.String())
+	}
+	checkGolden(t, "inventory.golden.json", readWorkbook(t, srv, "inventory-test.xlsx"))
+}
+
+// export runs an export with the query and returns the response.
+func export(t *testing.T, query string) string {
+	t.Helper()
+	r := httptest.NewRequest(http.MethodPost, "/?"+query, nil)
+	w := httptest.NewRecorder()
+	processInventory(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("%s: got status %d: %s", query, w.Code, w.Body.String())
+	}
+	return w.Body.String()
+}
+
+var changesPattern = regexp.MustCompile(`gs://` + testBucket + `/(changes-[^ ]+\.json)`)
+
+func TestProcessInventoryDiff(t *testing.T) {
+	srv := startFakeGCP(t)
+	t.Setenv("DIFF_PREVIOUS", "true")
+	t.Setenv("DIFF_FORMAT", "json")
+	if response := export(t, ""); changesPattern.MatchString(response) {
+		t.Fatalf("first export was compared with a previous one: %s", response)
+	}
+	// A run with a narrower scope is not the previous export of a full one.
+	export(t, "collectors=compute")
+	response := export(t, "")
+	match := changesPattern.FindStringSubmatch(response)
+	if match == nil {
+		t.Fatalf("export was not compared with the previous one: %s", response)
+	}
+	data, ok := srv.Object(testBucket, match[1])
+	if !ok {
+		t.Fatalf("%s was not uploaded", match[1])
+	}
+	var changes diff.Diff
+	if err := json.Unmarshal(data, &changes); err != nil {
+		t.Fatal(err)
+	}
+	if counts := changes.Counts(); len(counts) > 0 {
+		t.Errorf("got changes %v since the previous full export, expected none", counts)
+	}
+	if len(changes.SkippedSheets) > 1 {
+		t.Errorf("sheets %v were not compared", changes.SkippedSheets)
+	}
+	// The fixtures have a project skipped for its lifecycle state.
+	if want := []string{"old-project"}; !reflect.DeepEqual(changes.ExcludedProjects, want) {
+		t.Errorf("got excluded projects %v, expected %v", changes.ExcludedProjects, want)
+	}
+}
+
+func TestProcessInventoryJob(t *testing.T) {
+	srv := startFakeGCP(t)
+	// The request context is canceled once the job is started, as it is
+	// when the response has been written.
+	ctx, cancel := context.WithCancel(context.Background())
+	r := httptest.NewRequest(http.MethodPost, "/?async=true&objectName=inventory-job.xlsx", nil).WithContext(ctx)
+	w := httptest.NewRecorder()
+	processInventory(w, r)
+	cancel()
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("got status %d: %s", w.Code, w.Body.String())
+	}
+	var started map[string]string
+	if err := json.Unmarshal(w.Body.Bytes(), &started); err != nil {
+		t.Fatal(err)
+	}
+	if !exporter.ValidJobID(started["jobId"]) || started["state"] != exporter.JobRunning {
+		t.Fatalf("got response %v", started)
+	}
+	var job exporter.Job
+	for deadline := time.Now().Add(10 * time.Second); ; {
+		r := httptest.NewRequest(http.MethodGet, "/?jobId="+started["jobId"], nil)
+		w := httptest.NewRecorder()
+		processInventory(w, r)
+		if w.Code != http.StatusOK {
+			t.Fatalf("got status %d: %s", w.Code, w.Body.String())
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &job); err != nil {
+			t.Fatal(err)
+		}
+		if job.State != exporter.JobRunning {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("job %s still running", job.ID)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if job.State != exporter.JobSucceeded {
+		t.Fatalf("job %s: %s", job.State, job.Error)
+	}
+	if job.OutputURI != "gs://"+testBucket+"/inventory-job.xlsx" || job.EndTime == nil {
+		t.Errorf("got output %s and end time %v", job.OutputURI, job.EndTime)
+	}
+	for name, progress := range job.Collectors {
+		if progress.State != exporter.CollectorDone {
+			t.Errorf("collector %s is %s", name, progress.State)
+		}
+	}
+	if _, ok := srv.Object(testBucket, "inventory-job.xlsx"); !ok {
+		t.Errorf("job did not upload the export")
+	}
+
+	r = httptest.NewRequest(http.MethodGet, "/?jobId=20260101-000000-00000000", nil)
+	w = httptest.NewRecorder()
+	processInventory(w, r)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("got status %d for an unknown job, expected %d", w.Code, http.StatusNotFound)
+	}
+}
+
+func TestProcessInventoryInvalidOptions(t *testing.T) {
+	startFakeGCP(t)
+	for _, query := range []string{
+		"collectors=unknown",
+		"objectName=snapshots/latest.json",
+		"diff=true&format=csv",
+	} {
+		r := httptest.NewRequest(http.MethodPost, "/?"+query, nil)
+		w := httptest.NewRecorder()
+		processInventory(w, r)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: got status %d, expected %d: %s", query, w.Code, http.StatusBadRequest, w.Body.String())
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package inventory
+
+import (
+	"errors"
+	"fmt"
+	"google.golang.org/api/googleapi"
+	"sync"
+)
+
+const ErrorKind = "inventory#error"
+
+var ErrorSchema = &Schema{
+	Kind: ErrorKind,
+	Columns: []Column{
+		{Name: "Collector", Type: StringColumn},
+		{Name: "Project", Type: StringColumn},
+		{Name: "Location", Type: StringColumn},
+		{Name: "API", Type: StringColumn},
+		{Name: "HTTP Status", Type: IntColumn},
+		{Name: "Message", Type: StringColumn},
+	},
+}
+
+type Error struct {
+	Collector  string
+	Project    string
+	Location   string
+	API        string
+	HTTPStatus int
+	Message    string
+}
+
+func NewError(collector, project, location, api string, err error) *Error {
+	e := &Error{
+		Collector: collector,
+		Project:   project,
+		Location:  location,
+		API:       api,
+		Message:   err.Error(),
+	}
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) {
+		e.HTTPStatus = apiErr.Code
+		if apiErr.Message != "" {
+			e.Message = apiErr.Message
+		}
+	}
+	return e
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %s failed for project %s, location %s: %s", e.Collector, e.API, e.Project, e.Location, e.Message)
+}
+
+func (e *Error) Resource() *Resource {
+	r := NewResource(ErrorKind, e.Project, e.Location, e.API).
+		Set("Collector", e.Collector).
+		Set("Project", e.Project).
+		Set("Location", e.Location).
+		Set("API", e.API).
+		Set("Message", e.Message)
+	if e.HTTPStatus != 0 {
+		r.Set("HTTP Status", e.HTTPStatus)
+	}
+	return r
+}
+
+// Errors collects the partial failures reported by collectors during a run.
+type Errors struct {
+	mutex sync.Mutex
+	items []*Error
+}
+
+func NewErrors() *Errors {
+	return &Errors{}
+}
+
+func (e *Errors) Add(err *Error) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.items = append(e.items, err)
+}
+
+func (e *Errors) List() []*Error {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	items := make([]*Error, len(e.items))
+	copy(items, e.items)
+	return items
+}
+
+func (e *Errors) Len() int {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return len(e.items)
+}
+
+func (e *Errors) CountByCollector() map[string]int {
+	counts := map[string]int{}
+	for _, err := range e.List() {
+		counts[err.Collector]++
+	}
+	return counts
+}
+
+func (e *Errors) Resources() []*Resource {
+	var resources []*Resource
+	for _, err := range e.List() {
+		resources = append(resources, err.Resource())
+	}
+	return resources
+}
+
+// Summary returns a one line description of the errors, e.g.
+// "3 errors (compute: 2, firewall: 1)".
+func (e *Errors) Summary() string {
+	return CountSummary(e.CountByCollector(), "error", "errors")
+}
@@ -0,0 +1,61 @@
+package inventory
+
+import (
+	"time"
+)
+
+type Resource struct {
+	Kind       string
+	Project    string
+	Location   string
+	Name       string
+	Attributes map[string]interface{}
+	Labels     map[string]string
+	Raw        interface{}
+}
+
+func NewResource(kind, project, location, name string) *Resource {
+	return &Resource{
+		Kind:       kind,
+		Project:    project,
+		Location:   location,
+		Name:       name,
+		Attributes: map[string]interface{}{},
+		Labels:     map[string]string{},
+	}
+}
+
+func (r *Resource) Set(key string, value interface{}) *Resource {
+	r.Attributes[key] = value
+	return r
+}
+
+func (r *Resource) SetLabels(labels map[string]string) *Resource {
+	for key, value := range labels {
+		r.Labels[key] = value
+	}
+	return r
+}
+
+func (r *Resource) SetRaw(raw interface{}) *Resource {
+	r.Raw = raw
+	return r
+}
+
+func (r *Resource) Get(key string) (interface{}, bool) {
+	value, ok := r.Attributes[key]
+	return value, ok
+}
+
+// ParseTimestamp converts an RFC3339 timestamp as returned by the GCP APIs into
+// a time.Time, keeping the original string when it cannot be parsed.
+func ParseTimestamp(value string) interface{} {
+	if value == "" {
+		return nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return value
+	}
+	return t
+}
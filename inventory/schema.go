@@ -0,0 +1,153 @@
+package inventory
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+)
+
+type ColumnType int
+
+const (
+	StringColumn ColumnType = iota
+	IntColumn
+	BoolColumn
+	TimestampColumn
+	StringListColumn
+)
+
+var columnTypeNames = map[ColumnType]string{
+	StringColumn:     "string",
+	IntColumn:        "int",
+	BoolColumn:       "bool",
+	TimestampColumn:  "timestamp",
+	StringListColumn: "string_list",
+}
+
+func (t ColumnType) String() string {
+	if name, ok := columnTypeNames[t]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+type Column struct {
+	Name      string
+	Type      ColumnType
+	Separator string
+}
+
+type Schema struct {
+	Kind string
+	// Key lists the columns that identify a resource within its sheet, e.g.
+	// the project, zone and name of an instance, to match resources across
+	// snapshots.
+	Key     []string
+	Columns []Column
+}
+
+func (s *Schema) Header() []string {
+	var header []string
+	for _, column := range s.Columns {
+		header = append(header, column.Name)
+	}
+	return header
+}
+
+func (s *Schema) Row(r *Resource) []string {
+	var row []string
+	for _, column := range s.Columns {
+		value, _ := r.Get(column.Name)
+		row = append(row, column.Format(value))
+	}
+	return row
+}
+
+// Rows renders the resources as a table with the header as the first row.
+func (s *Schema) Rows(resources []*Resource) [][]string {
+	var rows [][]string
+	rows = append(rows, s.Header())
+	for _, r := range resources {
+		rows = append(rows, s.Row(r))
+	}
+	return rows
+}
+
+// FieldName returns the column name in snake case, e.g. memory_mb for
+// "Memory (MB)", for outputs with restricted column names such as Parquet and
+// BigQuery.
+func (c Column) FieldName() string {
+	var b strings.Builder
+	underscore := false
+	for _, r := range strings.ToLower(c.Name) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if underscore && b.Len() > 0 {
+				b.WriteByte('_')
+			}
+			b.WriteRune(r)
+			underscore = false
+		} else {
+			underscore = true
+		}
+	}
+	return b.String()
+}
+
+// FieldNames returns the FieldName of each column, with a numeric suffix
+// added to names that are reserved or already taken, e.g. labels_2.
+func (s *Schema) FieldNames(reserved ...string) []string {
+	used := map[string]bool{}
+	for _, name := range reserved {
+		used[name] = true
+	}
+	var names []string
+	for _, column := range s.Columns {
+		name := column.FieldName()
+		for n := 2; used[name]; n++ {
+			name = fmt.Sprintf("%s_%d", column.FieldName(), n)
+		}
+		used[name] = true
+		names = append(names, name)
+	}
+	return names
+}
+
+// Value returns the value as written to typed outputs such as JSON:
+// timestamps as RFC3339 strings, lists as string lists and numbers and
+// booleans as they are.
+func (c Column) Value(value interface{}) interface{} {
+	switch v := value.(type) {
+	case nil, string, int64, int, bool, []string:
+		return v
+	case time.Time:
+		return v.Format(time.RFC3339)
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
+
+func (c Column) Format(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case int64:
+		return fmt.Sprintf("%d", v)
+	case int:
+		return fmt.Sprintf("%d", v)
+	case bool:
+		return fmt.Sprintf("%t", v)
+	case time.Time:
+		return v.Format(time.RFC3339)
+	case []string:
+		separator := c.Separator
+		if separator == "" {
+			separator = ","
+		}
+		return strings.Join(v, separator)
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
@@ -0,0 +1,134 @@
+package inventory
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+const ErrorsSheet = "Errors"
+
+type Table struct {
+	Collector string
+	Sheet     string
+	Schema    *Schema
+	Resources []*Resource
+}
+
+func (t *Table) Rows() [][]string {
+	return t.Schema.Rows(t.Resources)
+}
+
+// SkippedProject is a project of the organization that was left out of the
+// run by the project filters.
+type SkippedProject struct {
+	ID     string
+	Reason string
+}
+
+// Snapshot is the inventory collected by a single run.
+type Snapshot struct {
+	RunID     string
+	OrgId     string
+	StartTime time.Time
+	EndTime   time.Time
+	Tables    []*Table
+	Errors    *Errors
+	Retries   map[string]int
+	Skipped   []*SkippedProject
+}
+
+// RunID returns the id of a run started at startTime, e.g.
+// 2023-03-01-10-00-00-1a2b3c4d. Like job ids, it ends with a random suffix
+// so runs started in the same second do not overwrite each other's objects.
+func RunID(startTime time.Time) string {
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+	return startTime.Format("2006-01-02-15-04-05") + "-" + hex.EncodeToString(suffix)
+}
+
+func NewSnapshot(orgId string, startTime time.Time) *Snapshot {
+	return &Snapshot{
+		RunID:     RunID(startTime),
+		OrgId:     orgId,
+		StartTime: startTime,
+		Errors:    NewErrors(),
+		Retries:   map[string]int{},
+	}
+}
+
+func (s *Snapshot) AddTable(table *Table) {
+	s.Tables = append(s.Tables, table)
+}
+
+func (s *Snapshot) Table(collector string) *Table {
+	for _, table := range s.Tables {
+		if table.Collector == collector {
+			return table
+		}
+	}
+	return nil
+}
+
+func (s *Snapshot) ErrorsTable() *Table {
+	return &Table{
+		Collector: "errors",
+		Sheet:     ErrorsSheet,
+		Schema:    ErrorSchema,
+		Resources: s.Errors.Resources(),
+	}
+}
+
+func (s *Snapshot) RetryCount() int {
+	total := 0
+	for _, count := range s.Retries {
+		total += count
+	}
+	return total
+}
+
+// Summary describes the collection errors and retries of the run, e.g.
+// "2 errors (compute: 2) and 0 retries", followed by the skipped projects if
+// any, e.g. "; 1 skipped project (sys-x: matched by exclude pattern ^sys-)".
+func (s *Snapshot) Summary() string {
+	summary := fmt.Sprintf("%s and %s", s.Errors.Summary(), CountSummary(s.Retries, "retry", "retries"))
+	if len(s.Skipped) == 0 {
+		return summary
+	}
+	var parts []string
+	for _, skipped := range s.Skipped {
+		parts = append(parts, fmt.Sprintf("%s: %s", skipped.ID, skipped.Reason))
+	}
+	noun := "projects"
+	if len(s.Skipped) == 1 {
+		noun = "project"
+	}
+	return fmt.Sprintf("%s; %d skipped %s (%s)", summary, len(s.Skipped), noun, strings.Join(parts, ", "))
+}
+
+// CountSummary describes counts by key, e.g.
+// "3 errors (compute: 2, network: 1)".
+func CountSummary(counts map[string]int, singular, plural string) string {
+	var keys []string
+	total := 0
+	for key, count := range counts {
+		keys = append(keys, key)
+		total += count
+	}
+	if total == 0 {
+		return "0 " + plural
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, key := range keys {
+		parts = append(parts, fmt.Sprintf("%s: %d", key, counts[key]))
+	}
+	noun := plural
+	if total == 1 {
+		noun = singular
+	}
+	return fmt.Sprintf("%d %s (%s)", total, noun, strings.Join(parts, ", "))
+}
@@ -0,0 +1,165 @@
+package location
+
+import (
+	"context"
+	"github.com/liornabat/gcp_inventory_exporter/collector"
+	"github.com/liornabat/gcp_inventory_exporter/inventory"
+	"github.com/liornabat/gcp_inventory_exporter/pkg/gcpclient"
+	"github.com/liornabat/gcp_inventory_exporter/project"
+	"google.golang.org/api/compute/v1"
+	"sync"
+)
+
+const collectorName = "locations"
+
+type projectLocations struct {
+	regionsOnce sync.Once
+	regions     []string
+	zonesOnce   sync.Once
+	zones       []string
+}
+
+// Resolver returns the configured regions and zones, or discovers the ones
+// that are UP in each project when the config asks for "auto". Discovered
+// locations are cached per project and shared by all collectors of a run.
+type Resolver struct {
+	opts     *collector.Options
+	mutex    sync.Mutex
+	projects map[string]*projectLocations
+}
+
+func NewResolver(opts *collector.Options) *Resolver {
+	return &Resolver{
+		opts:     opts,
+		projects: map[string]*projectLocations{},
+	}
+}
+
+func (r *Resolver) project(id string) *projectLocations {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	locations, ok := r.projects[id]
+	if !ok {
+		locations = &projectLocations{}
+		r.projects[id] = locations
+	}
+	return locations
+}
+
+// filter returns the locations that pass the location patterns and, when
+// only is set, are listed in only.
+func (r *Resolver) filter(locations, only []string) []string {
+	var filtered []string
+	for _, location := range locations {
+		if r.match(location, only) {
+			filtered = append(filtered, location)
+		}
+	}
+	return filtered
+}
+
+func (r *Resolver) match(location string, only []string) bool {
+	return r.opts.Config.MatchLocation(location) && (len(only) == 0 || contains(only, location))
+}
+
+func (r *Resolver) Regions(ctx context.Context, p *project.Project) []string {
+	if !r.opts.Config.Regions.IsAuto() {
+		return r.filter(r.opts.Config.Regions, nil)
+	}
+	locations := r.project(p.ID)
+	locations.regionsOnce.Do(func() {
+		locations.regions = r.filter(r.discoverRegions(ctx, p), r.opts.Config.RegionFilter)
+		r.opts.Log.Infof("Discovered %d regions for project %s", len(locations.regions), p.Name)
+	})
+	return locations.regions
+}
+
+func (r *Resolver) Zones(ctx context.Context, p *project.Project) []string {
+	if !r.opts.Config.Zones.IsAuto() {
+		return r.filter(r.opts.Config.Zones, nil)
+	}
+	locations := r.project(p.ID)
+	locations.zonesOnce.Do(func() {
+		locations.zones = r.filter(r.discoverZones(ctx, p), r.opts.Config.ZoneFilter)
+		r.opts.Log.Infof("Discovered %d zones for project %s", len(locations.zones), p.Name)
+	})
+	return locations.zones
+}
+
+// MatchRegion reports whether region is one of the project regions. In auto
+// mode any region that passes the location patterns and the region filter
+// matches, so resources listed across regions are filtered without
+// discovering the regions first.
+func (r *Resolver) MatchRegion(ctx context.Context, p *project.Project, region string) bool {
+	if r.opts.Config.Regions.IsAuto() {
+		return r.match(region, r.opts.Config.RegionFilter)
+	}
+	return contains(r.Regions(ctx, p), region)
+}
+
+// MatchZone is MatchRegion for zones.
+func (r *Resolver) MatchZone(ctx context.Context, p *project.Project, zone string) bool {
+	if r.opts.Config.Zones.IsAuto() {
+		return r.match(zone, r.opts.Config.ZoneFilter)
+	}
+	return contains(r.Zones(ctx, p), zone)
+}
+
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Resolver) discoverRegions(ctx context.Context, p *project.Project) []string {
+	service, err := compute.NewService(ctx, r.opts.Clients.For(gcpclient.Compute)...)
+	if err != nil {
+		r.opts.Errors.Add(inventory.NewError(collectorName, p.ID, "", "compute.regions.list", err))
+		return nil
+	}
+	var regions []string
+	req := service.Regions.List(p.ID)
+	if err := r.opts.Call(ctx, "compute.regions.list", func() error {
+		regions = nil
+		return req.Pages(ctx, func(page *compute.RegionList) error {
+			for _, region := range page.Items {
+				if region.Status == "UP" {
+					regions = append(regions, region.Name)
+				}
+			}
+			return nil
+		})
+	}); err != nil {
+		r.opts.Log.Errorf("Failed to discover regions for project %s, error: %s", p.Name, err.Error())
+		r.opts.Errors.Add(inventory.NewError(collectorName, p.ID, "", "compute.regions.list", err))
+	}
+	return regions
+}
+
+func (r *Resolver) discoverZones(ctx context.Context, p *project.Project) []string {
+	service, err := compute.NewService(ctx, r.opts.Clients.For(gcpclient.Compute)...)
+	if err != nil {
+		r.opts.Errors.Add(inventory.NewError(collectorName, p.ID, "", "compute.zones.list", err))
+		return nil
+	}
+	var zones []string
+	req := service.Zones.List(p.ID)
+	if err := r.opts.Call(ctx, "compute.zones.list", func() error {
+		zones = nil
+		return req.Pages(ctx, func(page *compute.ZoneList) error {
+			for _, zone := range page.Items {
+				if zone.Status == "UP" {
+					zones = append(zones, zone.Name)
+				}
+			}
+			return nil
+		})
+	}); err != nil {
+		r.opts.Log.Errorf("Failed to discover zones for project %s, error: %s", p.Name, err.Error())
+		r.opts.Errors.Add(inventory.NewError(collectorName, p.ID, "", "compute.zones.list", err))
+	}
+	return zones
+}
@@ -0,0 +1,227 @@
+package location
+
+import (
+	"context"
+	"github.com/liornabat/gcp_inventory_exporter/collector"
+	"github.com/liornabat/gcp_inventory_exporter/config"
+	"github.com/liornabat/gcp_inventory_exporter/inventory"
+	"github.com/liornabat/gcp_inventory_exporter/pkg/fakegcp"
+	"github.com/liornabat/gcp_inventory_exporter/pkg/gcpclient"
+	"github.com/liornabat/gcp_inventory_exporter/pkg/logger"
+	"github.com/liornabat/gcp_inventory_exporter/project"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"testing/fstest"
+)
+
+// The regions and zones of demo-project span two pages, with a location of
+// each that is not UP.
+var fixtures = fstest.MapFS{
+	"compute/v1/projects/demo-project/regions.json": {Data: []byte(`{
+  "items": [
+    {"name": "me-west1", "status": "UP"},
+    {"name": "me-central2", "status": "DOWN"}
+  ],
+  "nextPageToken": "page-2"
+}`)},
+	"compute/v1/projects/demo-project/regions@page-2.json": {Data: []byte(`{
+  "items": [
+    {"name": "europe-west1", "status": "UP"},
+    {"name": "us-central1", "status": "UP"}
+  ]
+}`)},
+	"compute/v1/projects/demo-project/zones.json": {Data: []byte(`{
+  "items": [
+    {"name": "me-west1-a", "status": "UP"},
+    {"name": "me-west1-b", "status": "DOWN"}
+  ],
+  "nextPageToken": "page-2"
+}`)},
+	"compute/v1/projects/demo-project/zones@page-2.json": {Data: []byte(`{
+  "items": [
+    {"name": "europe-west1-b", "status": "UP"},
+    {"name": "us-central1-a", "status": "UP"}
+  ]
+}`)},
+}
+
+// requests counts the requests per path served by the fake API.
+type requests struct {
+	mutex  sync.Mutex
+	counts map[string]int
+}
+
+func (r *requests) count(path string) int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.counts[path]
+}
+
+func newTestResolver(t *testing.T, cfg *config.Config) (*Resolver, *collector.Options, *requests) {
+	t.Helper()
+	srv := fakegcp.NewServer(fixtures)
+	reqs := &requests{counts: map[string]int{}}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reqs.mutex.Lock()
+		reqs.counts[r.URL.Path]++
+		reqs.mutex.Unlock()
+		srv.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+	opts := &collector.Options{
+		Config:  cfg,
+		Log:     logger.NewLogger("test", "error"),
+		Errors:  inventory.NewErrors(),
+		Clients: gcpclient.NewOptions(server.URL),
+	}
+	return NewResolver(opts), opts, reqs
+}
+
+func autoConfig() *config.Config {
+	cfg := config.NewConfig()
+	cfg.Regions = config.Regions{config.Auto}
+	cfg.Zones = config.Zones{config.Auto}
+	return cfg
+}
+
+var demoProject = &project.Project{ID: "demo-project", Name: "Demo Project"}
+
+func TestResolverDiscovery(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     func(cfg *config.Config)
+		regions []string
+		zones   []string
+	}{
+		{
+			name:    "all pages",
+			regions: []string{"me-west1", "europe-west1", "us-central1"},
+			zones:   []string{"me-west1-a", "europe-west1-b", "us-central1-a"},
+		},
+		{
+			name: "location patterns",
+			cfg: func(cfg *config.Config) {
+				cfg.LocationInclude = []string{"me-*", "europe-*"}
+				cfg.LocationExclude = []string{"europe-west1-?"}
+			},
+			regions: []string{"me-west1", "europe-west1"},
+			zones:   []string{"me-west1-a"},
+		},
+		{
+			name: "requested locations filter the discovered ones",
+			cfg: func(cfg *config.Config) {
+				cfg.RegionFilter = []string{"us-central1", "me-central2"}
+				cfg.ZoneFilter = []string{"me-west1-a"}
+			},
+			regions: []string{"us-central1"},
+			zones:   []string{"me-west1-a"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := autoConfig()
+			if test.cfg != nil {
+				test.cfg(cfg)
+			}
+			r, opts, _ := newTestResolver(t, cfg)
+			ctx := context.Background()
+			if got := r.Regions(ctx, demoProject); !reflect.DeepEqual(got, test.regions) {
+				t.Errorf("got regions %v, expected %v", got, test.regions)
+			}
+			if got := r.Zones(ctx, demoProject); !reflect.DeepEqual(got, test.zones) {
+				t.Errorf("got zones %v, expected %v", got, test.zones)
+			}
+			if opts.Errors.Len() > 0 {
+				t.Errorf("got errors %s", opts.Errors.Summary())
+			}
+		})
+	}
+}
+
+func TestResolverCachesDiscovery(t *testing.T) {
+	r, _, reqs := newTestResolver(t, autoConfig())
+	ctx := context.Background()
+	wg := &sync.WaitGroup{}
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r.Regions(ctx, demoProject)
+			r.Zones(ctx, demoProject)
+		}()
+	}
+	wg.Wait()
+	// Each list has two pages, requested once for all the collectors.
+	for _, path := range []string{"/compute/v1/projects/demo-project/regions", "/compute/v1/projects/demo-project/zones"} {
+		if count := reqs.count(path); count != 2 {
+			t.Errorf("got %d requests for %s, expected 2", count, path)
+		}
+	}
+}
+
+func TestResolverDiscoveryError(t *testing.T) {
+	r, opts, _ := newTestResolver(t, autoConfig())
+	other := &project.Project{ID: "other-project", Name: "Other Project"}
+	if regions := r.Regions(context.Background(), other); len(regions) > 0 {
+		t.Errorf("got regions %v for a project that cannot be listed", regions)
+	}
+	list := opts.Errors.List()
+	if len(list) != 1 || list[0].Project != "other-project" || list[0].API != "compute.regions.list" || list[0].HTTPStatus != http.StatusNotFound {
+		t.Fatalf("got errors %s, expected a compute.regions.list error of other-project", opts.Errors.Summary())
+	}
+}
+
+func TestResolverConfiguredLocations(t *testing.T) {
+	cfg := config.NewConfig()
+	cfg.Regions = config.Regions{"me-west1", "europe-west1"}
+	cfg.Zones = config.Zones{"me-west1-a", "europe-west1-b"}
+	cfg.LocationExclude = []string{"europe-*"}
+	r, _, reqs := newTestResolver(t, cfg)
+	ctx := context.Background()
+	if got, want := r.Regions(ctx, demoProject), []string{"me-west1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got regions %v, expected %v", got, want)
+	}
+	if got, want := r.Zones(ctx, demoProject), []string{"me-west1-a"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got zones %v, expected %v", got, want)
+	}
+	if !r.MatchZone(ctx, demoProject, "me-west1-a") || r.MatchZone(ctx, demoProject, "me-west1-b") || r.MatchRegion(ctx, demoProject, "europe-west1") {
+		t.Errorf("configured locations are not matched")
+	}
+	if len(reqs.counts) > 0 {
+		t.Errorf("got requests %v for configured locations", reqs.counts)
+	}
+}
+
+func TestResolverMatchAuto(t *testing.T) {
+	cfg := autoConfig()
+	cfg.LocationExclude = []string{"us-*"}
+	cfg.ZoneFilter = []string{"me-west1-a", "me-west1-b"}
+	r, _, reqs := newTestResolver(t, cfg)
+	ctx := context.Background()
+	for _, test := range []struct {
+		location string
+		region   bool
+		want     bool
+	}{
+		// A region that is not discovered still matches, resources are only
+		// listed in the regions that exist.
+		{"me-central2", true, true},
+		{"us-central1", true, false},
+		{"me-west1-b", false, true},
+		{"europe-west1-b", false, false},
+	} {
+		match := r.MatchZone
+		if test.region {
+			match = r.MatchRegion
+		}
+		if got := match(ctx, demoProject, test.location); got != test.want {
+			t.Errorf("%s: got match %t, expected %t", test.location, got, test.want)
+		}
+	}
+	if len(reqs.counts) > 0 {
+		t.Errorf("got requests %v for matching in auto mode", reqs.counts)
+	}
+}
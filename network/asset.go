@@ -0,0 +1,116 @@
+package network
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/liornabat/gcp_inventory_exporter/collector"
+	"github.com/liornabat/gcp_inventory_exporter/inventory"
+	"google.golang.org/api/compute/v1"
+)
+
+const (
+	SubnetworkAssetType    = "compute.googleapis.com/Subnetwork"
+	InstanceAssetType      = "compute.googleapis.com/Instance"
+	AddressAssetType       = "compute.googleapis.com/Address"
+	GlobalAddressAssetType = "compute.googleapis.com/GlobalAddress"
+	RouteAssetType         = "compute.googleapis.com/Route"
+	NetworkAssetType       = "compute.googleapis.com/Network"
+	FirewallAssetType      = "compute.googleapis.com/Firewall"
+)
+
+// decodeAsset unmarshals the asset data into v, reporting a collection error
+// for the collector when the data is not valid.
+func decodeAsset(asset *collector.Asset, v interface{}, collectorName string, opts *collector.Options) bool {
+	if err := json.Unmarshal(asset.Data, v); err != nil {
+		opts.Errors.Add(inventory.NewError(collectorName, asset.Project.ID, asset.Location, "cloudasset.assets.list", err))
+		return false
+	}
+	return true
+}
+
+func GetVPCAssetInventory(ctx context.Context, assets []*collector.Asset, opts *collector.Options) ([]*inventory.Resource, error) {
+	var resources []*inventory.Resource
+	for _, asset := range assets {
+		subnetwork := &compute.Subnetwork{}
+		if !decodeAsset(asset, subnetwork, VPCCollectorName, opts) {
+			continue
+		}
+		region := removeUrlPrefix(subnetwork.Region)
+		if !opts.MatchRegion(ctx, asset.Project, region) {
+			continue
+		}
+		resources = append(resources, newSubnetworkResource(asset.Project, region, subnetwork))
+	}
+	return resources, nil
+}
+
+func GetIPAddressAssetInventory(ctx context.Context, assets []*collector.Asset, opts *collector.Options) ([]*inventory.Resource, error) {
+	var resources []*inventory.Resource
+	for _, asset := range assets {
+		switch asset.Type {
+		case InstanceAssetType:
+			instance := &compute.Instance{}
+			if !decodeAsset(asset, instance, IPAddressCollectorName, opts) {
+				continue
+			}
+			zone := removeUrlPrefix(instance.Zone)
+			if !opts.MatchZone(ctx, asset.Project, zone) {
+				continue
+			}
+			for _, networkInterface := range instance.NetworkInterfaces {
+				resources = append(resources, newInterfaceAddressResource(asset.Project, zone, instance, networkInterface))
+			}
+		case AddressAssetType:
+			address := &compute.Address{}
+			if !decodeAsset(asset, address, IPAddressCollectorName, opts) {
+				continue
+			}
+			resources = append(resources, newAddressResource(asset.Project, removeUrlPrefix(address.Region), address))
+		case GlobalAddressAssetType:
+			address := &compute.Address{}
+			if !decodeAsset(asset, address, IPAddressCollectorName, opts) {
+				continue
+			}
+			resources = append(resources, newAddressResource(asset.Project, "global", address))
+		}
+	}
+	return resources, nil
+}
+
+func GetRoutesAssetInventory(ctx context.Context, assets []*collector.Asset, opts *collector.Options) ([]*inventory.Resource, error) {
+	var resources []*inventory.Resource
+	for _, asset := range assets {
+		route := &compute.Route{}
+		if !decodeAsset(asset, route, RoutesCollectorName, opts) {
+			continue
+		}
+		resources = append(resources, newRouteResource(asset.Project, route))
+	}
+	return resources, nil
+}
+
+func GetPeeringAssetInventory(ctx context.Context, assets []*collector.Asset, opts *collector.Options) ([]*inventory.Resource, error) {
+	var resources []*inventory.Resource
+	for _, asset := range assets {
+		network := &compute.Network{}
+		if !decodeAsset(asset, network, PeeringCollectorName, opts) {
+			continue
+		}
+		for _, peering := range network.Peerings {
+			resources = append(resources, newPeeringResource(asset.Project, network, peering))
+		}
+	}
+	return resources, nil
+}
+
+func GetFirewallAssetInventory(ctx context.Context, assets []*collector.Asset, opts *collector.Options) ([]*inventory.Resource, error) {
+	var resources []*inventory.Resource
+	for _, asset := range assets {
+		firewall := &compute.Firewall{}
+		if !decodeAsset(asset, firewall, FirewallCollectorName, opts) {
+			continue
+		}
+		resources = append(resources, newFirewallResource(asset.Project, firewall))
+	}
+	return resources, nil
+}
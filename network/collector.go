@@ -0,0 +1,216 @@
+package network
+
+import (
+	"context"
+	"github.com/liornabat/gcp_inventory_exporter/collector"
+	"github.com/liornabat/gcp_inventory_exporter/inventory"
+	"github.com/liornabat/gcp_inventory_exporter/project"
+)
+
+const (
+	VPCCollectorName       = "vpc"
+	IPAddressCollectorName = "ip_addresses"
+	RoutesCollectorName    = "routes"
+	PeeringCollectorName   = "vpc_peering"
+	FirewallCollectorName  = "firewall"
+)
+
+func init() {
+	collector.Register(VPCCollectorName, NewVPCCollector)
+	collector.Register(IPAddressCollectorName, NewIPAddressCollector)
+	collector.Register(RoutesCollectorName, NewRoutesCollector)
+	collector.Register(PeeringCollectorName, NewPeeringCollector)
+	collector.Register(FirewallCollectorName, NewFirewallCollector)
+}
+
+var requiredAPIs = []string{"compute.googleapis.com"}
+
+type VPCCollector struct {
+	opts *collector.Options
+}
+
+func NewVPCCollector(opts *collector.Options) collector.Collector {
+	return &VPCCollector{
+		opts: opts,
+	}
+}
+
+func (c *VPCCollector) Name() string {
+	return VPCCollectorName
+}
+
+func (c *VPCCollector) Sheet() string {
+	return "VPC"
+}
+
+func (c *VPCCollector) Schema() *inventory.Schema {
+	return VPCSchema
+}
+
+func (c *VPCCollector) RequiredAPIs() []string {
+	return requiredAPIs
+}
+
+func (c *VPCCollector) Collect(ctx context.Context, projects []*project.Project) ([]*inventory.Resource, error) {
+	return GetVPCInventory(ctx, projects, c.opts)
+}
+
+func (c *VPCCollector) AssetTypes() []string {
+	return []string{SubnetworkAssetType}
+}
+
+func (c *VPCCollector) CollectAssets(ctx context.Context, assets []*collector.Asset) ([]*inventory.Resource, error) {
+	return GetVPCAssetInventory(ctx, assets, c.opts)
+}
+
+type IPAddressCollector struct {
+	opts *collector.Options
+}
+
+func NewIPAddressCollector(opts *collector.Options) collector.Collector {
+	return &IPAddressCollector{
+		opts: opts,
+	}
+}
+
+func (c *IPAddressCollector) Name() string {
+	return IPAddressCollectorName
+}
+
+func (c *IPAddressCollector) Sheet() string {
+	return "IP Addresses"
+}
+
+func (c *IPAddressCollector) Schema() *inventory.Schema {
+	return IPAddressSchema
+}
+
+func (c *IPAddressCollector) RequiredAPIs() []string {
+	return requiredAPIs
+}
+
+func (c *IPAddressCollector) Collect(ctx context.Context, projects []*project.Project) ([]*inventory.Resource, error) {
+	return GetIPAddressInventory(ctx, projects, c.opts)
+}
+
+func (c *IPAddressCollector) AssetTypes() []string {
+	return []string{InstanceAssetType, AddressAssetType, GlobalAddressAssetType}
+}
+
+func (c *IPAddressCollector) CollectAssets(ctx context.Context, assets []*collector.Asset) ([]*inventory.Resource, error) {
+	return GetIPAddressAssetInventory(ctx, assets, c.opts)
+}
+
+type RoutesCollector struct {
+	opts *collector.Options
+}
+
+func NewRoutesCollector(opts *collector.Options) collector.Collector {
+	return &RoutesCollector{
+		opts: opts,
+	}
+}
+
+func (c *RoutesCollector) Name() string {
+	return RoutesCollectorName
+}
+
+func (c *RoutesCollector) Sheet() string {
+	return "Routes"
+}
+
+func (c *RoutesCollector) Schema() *inventory.Schema {
+	return RouteSchema
+}
+
+func (c *RoutesCollector) RequiredAPIs() []string {
+	return requiredAPIs
+}
+
+func (c *RoutesCollector) Collect(ctx context.Context, projects []*project.Project) ([]*inventory.Resource, error) {
+	return GetRoutesInventory(ctx, projects, c.opts)
+}
+
+func (c *RoutesCollector) AssetTypes() []string {
+	return []string{RouteAssetType}
+}
+
+func (c *RoutesCollector) CollectAssets(ctx context.Context, assets []*collector.Asset) ([]*inventory.Resource, error) {
+	return GetRoutesAssetInventory(ctx, assets, c.opts)
+}
+
+type PeeringCollector struct {
+	opts *collector.Options
+}
+
+func NewPeeringCollector(opts *collector.Options) collector.Collector {
+	return &PeeringCollector{
+		opts: opts,
+	}
+}
+
+func (c *PeeringCollector) Name() string {
+	return PeeringCollectorName
+}
+
+func (c *PeeringCollector) Sheet() string {
+	return "VPC Peering"
+}
+
+func (c *PeeringCollector) Schema() *inventory.Schema {
+	return PeeringSchema
+}
+
+func (c *PeeringCollector) RequiredAPIs() []string {
+	return requiredAPIs
+}
+
+func (c *PeeringCollector) Collect(ctx context.Context, projects []*project.Project) ([]*inventory.Resource, error) {
+	return GetPreeingInventory(ctx, projects, c.opts)
+}
+
+func (c *PeeringCollector) AssetTypes() []string {
+	return []string{NetworkAssetType}
+}
+
+func (c *PeeringCollector) CollectAssets(ctx context.Context, assets []*collector.Asset) ([]*inventory.Resource, error) {
+	return GetPeeringAssetInventory(ctx, assets, c.opts)
+}
+
+type FirewallCollector struct {
+	opts *collector.Options
+}
+
+func NewFirewallCollector(opts *collector.Options) collector.Collector {
+	return &FirewallCollector{
+		opts: opts,
+	}
+}
+
+func (c *FirewallCollector) Name() string {
+	return FirewallCollectorName
+}
+
+func (c *FirewallCollector) Sheet() string {
+	return "Firewall"
+}
+
+func (c *FirewallCollector) Schema() *inventory.Schema {
+	return FirewallSchema
+}
+
+func (c *FirewallCollector) RequiredAPIs() []string {
+	return requiredAPIs
+}
+
+func (c *FirewallCollector) Collect(ctx context.Context, projects []*project.Project) ([]*inventory.Resource, error) {
+	return GetFirewallInventory(ctx, projects, c.opts)
+}
+
+func (c *FirewallCollector) AssetTypes() []string {
+	return []string{FirewallAssetType}
+}
+
+func (c *FirewallCollector) CollectAssets(ctx context.Context, assets []*collector.Asset) ([]*inventory.Resource, error) {
+	return GetFirewallAssetInventory(ctx, assets, c.opts)
+}
@@ -3,80 +3,99 @@ package network
 import (
 	"context"
 	"fmt"
-	"github.com/liornabat/gcp_inventory_exporter/pkg/logger"
+	"github.com/liornabat/gcp_inventory_exporter/collector"
+	"github.com/liornabat/gcp_inventory_exporter/inventory"
+	"github.com/liornabat/gcp_inventory_exporter/pkg/gcpclient"
 	"github.com/liornabat/gcp_inventory_exporter/project"
 	"google.golang.org/api/compute/v1"
 	"strings"
 	"sync"
 )
 
-var firewallHeader = []string{
-	"Project",
-	"Name",
-	"Network",
-	"Priority",
-	"Source Ranges",
-	"Allowed",
-	"Denied",
-	"Creation Timestamp",
+const FirewallKind = "compute#firewall"
+
+var FirewallSchema = &inventory.Schema{
+	Kind: FirewallKind,
+	Key:  []string{"Project ID", "Name"},
+	Columns: []inventory.Column{
+		{Name: "Project", Type: inventory.StringColumn},
+		{Name: "Project ID", Type: inventory.StringColumn},
+		{Name: "Name", Type: inventory.StringColumn},
+		{Name: "Network", Type: inventory.StringColumn},
+		{Name: "Priority", Type: inventory.IntColumn},
+		{Name: "Source Ranges", Type: inventory.StringListColumn},
+		{Name: "Allowed", Type: inventory.StringListColumn, Separator: "; "},
+		{Name: "Denied", Type: inventory.StringListColumn, Separator: "; "},
+		{Name: "Creation Timestamp", Type: inventory.TimestampColumn},
+	},
+}
+
+func newFirewallResource(projectId *project.Project, firewall *compute.Firewall) *inventory.Resource {
+	return inventory.NewResource(FirewallKind, projectId.ID, "global", firewall.Name).
+		Set("Project", projectId.Name).
+		Set("Project ID", projectId.ID).
+		Set("Name", firewall.Name).
+		Set("Network", removeUrlPrefix(firewall.Network)).
+		Set("Priority", firewall.Priority).
+		Set("Source Ranges", firewall.SourceRanges).
+		Set("Allowed", allowToStrings(firewall.Allowed)).
+		Set("Denied", denyToStrings(firewall.Denied)).
+		Set("Creation Timestamp", inventory.ParseTimestamp(firewall.CreationTimestamp)).
+		SetRaw(firewall)
 }
 
-func GetFirewallInventory(ctx context.Context, projectsId []*project.Project, log *logger.Logger) ([][]string, error) {
+func GetFirewallInventory(ctx context.Context, projectsId []*project.Project, opts *collector.Options) ([]*inventory.Resource, error) {
+	log := opts.Log
 	log.Infof("Getting Firewall inventory")
 	defer log.Infof("Done getting Firewall inventory")
-	service, err := compute.NewService(ctx)
+	service, err := compute.NewService(ctx, opts.Clients.For(gcpclient.Compute)...)
 	if err != nil {
 		return nil, err
 	}
-	var inventory [][]string
-	inventory = append(inventory, firewallHeader)
+	var resources []*inventory.Resource
 	mutex := &sync.Mutex{}
 	wg := &sync.WaitGroup{}
 	wg.Add(len(projectsId))
 	for _, projectId := range projectsId {
 		go func(projectId *project.Project) {
 			defer wg.Done()
-			var localInventory [][]string
+			var localResources []*inventory.Resource
 			log.Infof("Getting firewall inventory for project %s", projectId.Name)
 			req := service.Firewalls.List(projectId.ID)
-			if err := req.Pages(ctx, func(page *compute.FirewallList) error {
-				for _, route := range page.Items {
-					localInventory = append(localInventory, []string{
-						projectId.Name,
-						route.Name,
-						removeUrlPrefix(route.Network),
-						fmt.Sprintf("%d", route.Priority),
-						strings.Join(route.SourceRanges, ","),
-						allowToString(route.Allowed),
-						denyToString(route.Denied),
-						route.CreationTimestamp,
-					})
-				}
-				return nil
+			start := len(localResources)
+			if err := opts.Call(ctx, "compute.firewalls.list", func() error {
+				localResources = localResources[:start]
+				return req.Pages(ctx, func(page *compute.FirewallList) error {
+					for _, firewall := range page.Items {
+						localResources = append(localResources, newFirewallResource(projectId, firewall))
+					}
+					return nil
+				})
 			}); err != nil {
 				log.Errorf("Failed to get firewall inventory for project %s , error: %s", projectId.Name, err.Error())
+				opts.Errors.Add(inventory.NewError(FirewallCollectorName, projectId.ID, "global", "compute.firewalls.list", err))
 			}
 			mutex.Lock()
-			inventory = append(inventory, localInventory...)
+			resources = append(resources, localResources...)
 			mutex.Unlock()
 		}(projectId)
 	}
 	wg.Wait()
-	return inventory, nil
+	return resources, nil
 }
 
-func denyToString(denied []*compute.FirewallDenied) string {
+func denyToStrings(denied []*compute.FirewallDenied) []string {
 	var deniedString []string
 	for _, deny := range denied {
 		deniedString = append(deniedString, fmt.Sprintf("%s:%s", deny.IPProtocol, strings.Join(deny.Ports, ",")))
 	}
-	return strings.Join(deniedString, ",")
+	return deniedString
 }
 
-func allowToString(allowed []*compute.FirewallAllowed) string {
+func allowToStrings(allowed []*compute.FirewallAllowed) []string {
 	var allowedString []string
 	for _, allow := range allowed {
 		allowedString = append(allowedString, fmt.Sprintf("%s:%s", allow.IPProtocol, strings.Join(allow.Ports, ",")))
 	}
-	return strings.Join(allowedString, ",")
+	return allowedString
 }
@@ -2,115 +2,132 @@ package network
 
 import (
 	"context"
-	"github.com/liornabat/gcp_inventory_exporter/config"
-	"github.com/liornabat/gcp_inventory_exporter/pkg/logger"
+	"github.com/liornabat/gcp_inventory_exporter/collector"
+	instances "github.com/liornabat/gcp_inventory_exporter/compute"
+	"github.com/liornabat/gcp_inventory_exporter/inventory"
+	"github.com/liornabat/gcp_inventory_exporter/pkg/gcpclient"
 	"github.com/liornabat/gcp_inventory_exporter/project"
 	"google.golang.org/api/compute/v1"
-	"strings"
 	"sync"
 )
 
-var ipAddressHeader = []string{
-	"Project",
-	"Region/Zone",
-	"Name",
-	"Address",
-	"Network",
-	"Subnetwork",
-	"Address Type",
-	"Used By",
-	"Creation Timestamp",
+const AddressKind = "compute#address"
+
+var IPAddressSchema = &inventory.Schema{
+	Kind: AddressKind,
+	Key:  []string{"Project ID", "Region/Zone", "Name", "Address"},
+	Columns: []inventory.Column{
+		{Name: "Project", Type: inventory.StringColumn},
+		{Name: "Project ID", Type: inventory.StringColumn},
+		{Name: "Region/Zone", Type: inventory.StringColumn},
+		{Name: "Name", Type: inventory.StringColumn},
+		{Name: "Address", Type: inventory.StringColumn},
+		{Name: "Network", Type: inventory.StringColumn},
+		{Name: "Subnetwork", Type: inventory.StringColumn},
+		{Name: "Address Type", Type: inventory.StringColumn},
+		{Name: "Used By", Type: inventory.StringListColumn},
+		{Name: "Creation Timestamp", Type: inventory.TimestampColumn},
+	},
+}
+
+func newInterfaceAddressResource(projectId *project.Project, zone string, instance *compute.Instance, networkInterface *compute.NetworkInterface) *inventory.Resource {
+	location := removeUrlPrefix(zone)
+	return inventory.NewResource(AddressKind, projectId.ID, location, networkInterface.Name).
+		Set("Project", projectId.Name).
+		Set("Project ID", projectId.ID).
+		Set("Region/Zone", location).
+		Set("Name", networkInterface.Name).
+		Set("Address", networkInterface.NetworkIP).
+		Set("Network", removeUrlPrefix(networkInterface.Network)).
+		Set("Subnetwork", removeUrlPrefix(networkInterface.Subnetwork)).
+		Set("Address Type", "INTERNAL").
+		Set("Used By", []string{instance.Name}).
+		Set("Creation Timestamp", inventory.ParseTimestamp(instance.CreationTimestamp)).
+		SetRaw(networkInterface)
 }
 
-func GetIPAddressInventory(ctx context.Context, projectsId []*project.Project, zones config.Zones, log *logger.Logger) ([][]string, error) {
+func newAddressResource(projectId *project.Project, location string, address *compute.Address) *inventory.Resource {
+	return inventory.NewResource(AddressKind, projectId.ID, location, address.Name).
+		Set("Project", projectId.Name).
+		Set("Project ID", projectId.ID).
+		Set("Region/Zone", location).
+		Set("Name", address.Name).
+		Set("Address", address.Address).
+		Set("Network", removeUrlPrefix(address.Network)).
+		Set("Subnetwork", removeUrlPrefix(address.Subnetwork)).
+		Set("Address Type", address.AddressType).
+		Set("Used By", removeUrlPrefixes(address.Users)).
+		Set("Creation Timestamp", inventory.ParseTimestamp(address.CreationTimestamp)).
+		SetRaw(address)
+}
+
+func GetIPAddressInventory(ctx context.Context, projectsId []*project.Project, opts *collector.Options) ([]*inventory.Resource, error) {
+	log := opts.Log
 	log.Infof("Getting IP address inventory")
 	defer log.Infof("Done getting IP address inventory")
-	service, err := compute.NewService(ctx)
+	service, err := compute.NewService(ctx, opts.Clients.For(gcpclient.Compute)...)
 	if err != nil {
 		return nil, err
 	}
-	var inventory [][]string
-	inventory = append(inventory, ipAddressHeader)
+	var resources []*inventory.Resource
 	mutex := &sync.Mutex{}
 	wg := &sync.WaitGroup{}
 	wg.Add(len(projectsId))
 	for _, projectId := range projectsId {
 		go func(projectId *project.Project) {
 			defer wg.Done()
-			var localInventory [][]string
+			var localResources []*inventory.Resource
 			log.Infof("Getting Ip Address inventory for project %s", projectId.Name)
-			for _, zone := range zones {
-				log.Infof("Getting Ip Address inventory for compute instances in zone %s", zone)
-				instances, err := service.Instances.List(projectId.ID, zone).Do()
-				if err != nil {
-					log.Errorf("Failed to get compute inventory for project %s and zone %s, error: %s", projectId.Name, zone, err.Error())
-					continue
-				}
-				for _, instance := range instances.Items {
+			zones, err := instances.ListInstances(ctx, service, projectId, opts)
+			if err != nil {
+				log.Errorf("Failed to get compute inventory for project %s, error: %s", projectId.Name, err.Error())
+				opts.Errors.Add(inventory.NewError(IPAddressCollectorName, projectId.ID, "", "compute.instances.aggregatedList", err))
+			}
+			for _, zone := range zones.Names() {
+				for _, instance := range zones[zone] {
 					for _, networkInterface := range instance.NetworkInterfaces {
-						localInventory = append(localInventory, []string{
-							projectId.Name,
-							removeUrlPrefix(zone),
-							networkInterface.Name,
-							networkInterface.NetworkIP,
-							removeUrlPrefix(networkInterface.Network),
-							removeUrlPrefix(networkInterface.Subnetwork),
-							"INTERNAL",
-							instance.Name,
-							instance.CreationTimestamp,
-						})
+						localResources = append(localResources, newInterfaceAddressResource(projectId, zone, instance, networkInterface))
 					}
 				}
 			}
 			log.Infof("Getting Ip Address inventory with Aggregated List")
 			req := service.Addresses.AggregatedList(projectId.ID)
-			if err := req.Pages(ctx, func(page *compute.AddressAggregatedList) error {
-				for _, item := range page.Items {
-					for _, address := range item.Addresses {
-						localInventory = append(localInventory, []string{
-							projectId.Name,
-							removeUrlPrefix(address.Region),
-							address.Name,
-							address.Address,
-							removeUrlPrefix(address.Network),
-							removeUrlPrefix(address.Subnetwork),
-							address.AddressType,
-							strings.Join(removeUrlPrefixes(address.Users), ","),
-							address.CreationTimestamp,
-						})
+			start := len(localResources)
+			if err := opts.Call(ctx, "compute.addresses.aggregatedList", func() error {
+				localResources = localResources[:start]
+				return req.Pages(ctx, func(page *compute.AddressAggregatedList) error {
+					for _, item := range page.Items {
+						for _, address := range item.Addresses {
+							localResources = append(localResources, newAddressResource(projectId, removeUrlPrefix(address.Region), address))
+						}
 					}
-				}
-				return nil
+					return nil
+				})
 			}); err != nil {
 				log.Errorf("Failed to get IP address inventory for project %s, error: %s", projectId.Name, err.Error())
-
+				opts.Errors.Add(inventory.NewError(IPAddressCollectorName, projectId.ID, "", "compute.addresses.aggregatedList", err))
 			}
 			log.Infof("Getting Ip Address inventory with Global List")
 			newReq := service.GlobalAddresses.List(projectId.ID)
-			if err := newReq.Pages(ctx, func(page *compute.AddressList) error {
-				for _, address := range page.Items {
-					localInventory = append(localInventory, []string{
-						projectId.Name,
-						"global",
-						address.Name,
-						address.Address,
-						removeUrlPrefix(address.Network),
-						removeUrlPrefix(address.Subnetwork),
-						address.AddressType,
-						strings.Join(removeUrlPrefixes(address.Users), ","),
-						address.CreationTimestamp,
-					})
-				}
-				return nil
+			start = len(localResources)
+			if err := opts.Call(ctx, "compute.globalAddresses.list", func() error {
+				localResources = localResources[:start]
+				return newReq.Pages(ctx, func(page *compute.AddressList) error {
+					for _, address := range page.Items {
+						localResources = append(localResources, newAddressResource(projectId, "global", address))
+					}
+					return nil
+				})
 			}); err != nil {
 				log.Errorf("Failed to get IP address inventory for project %s, error: %s", projectId.Name, err.Error())
+				opts.Errors.Add(inventory.NewError(IPAddressCollectorName, projectId.ID, "global", "compute.globalAddresses.list", err))
 			}
 			mutex.Lock()
-			inventory = append(inventory, localInventory...)
+			resources = append(resources, localResources...)
 			mutex.Unlock()
 		}(projectId)
 	}
 	wg.Wait()
 
-	return inventory, nil
+	return resources, nil
 }
@@ -2,74 +2,92 @@ package network
 
 import (
 	"context"
-	"fmt"
-	"github.com/liornabat/gcp_inventory_exporter/pkg/logger"
+	"github.com/liornabat/gcp_inventory_exporter/collector"
+	"github.com/liornabat/gcp_inventory_exporter/inventory"
+	"github.com/liornabat/gcp_inventory_exporter/pkg/gcpclient"
 	"github.com/liornabat/gcp_inventory_exporter/project"
 	"google.golang.org/api/compute/v1"
 	"sync"
 )
 
-var peeringHeader = []string{
-	"Project",
-	"Name",
-	"Network",
-	"Peer Network",
-	"State",
-	"Auto Create Routes",
-	"Exchange Subnet Routes",
-	"Export Custom Routes",
-	"Import Custom Routes",
-	"Export Subnet Routes With Public IP",
-	"Import Subnet Routes With Public IP",
-	"Creation Timestamp",
+const PeeringKind = "compute#networkPeering"
+
+var PeeringSchema = &inventory.Schema{
+	Kind: PeeringKind,
+	Key:  []string{"Project ID", "Network", "Name"},
+	Columns: []inventory.Column{
+		{Name: "Project", Type: inventory.StringColumn},
+		{Name: "Project ID", Type: inventory.StringColumn},
+		{Name: "Name", Type: inventory.StringColumn},
+		{Name: "Network", Type: inventory.StringColumn},
+		{Name: "Peer Network", Type: inventory.StringColumn},
+		{Name: "State", Type: inventory.StringColumn},
+		{Name: "Auto Create Routes", Type: inventory.BoolColumn},
+		{Name: "Exchange Subnet Routes", Type: inventory.BoolColumn},
+		{Name: "Export Custom Routes", Type: inventory.BoolColumn},
+		{Name: "Import Custom Routes", Type: inventory.BoolColumn},
+		{Name: "Export Subnet Routes With Public IP", Type: inventory.BoolColumn},
+		{Name: "Import Subnet Routes With Public IP", Type: inventory.BoolColumn},
+		{Name: "Creation Timestamp", Type: inventory.TimestampColumn},
+	},
+}
+
+func newPeeringResource(projectId *project.Project, network *compute.Network, peering *compute.NetworkPeering) *inventory.Resource {
+	return inventory.NewResource(PeeringKind, projectId.ID, "global", peering.Name).
+		Set("Project", projectId.Name).
+		Set("Project ID", projectId.ID).
+		Set("Name", peering.Name).
+		Set("Network", removeUrlPrefix(network.Name)).
+		Set("Peer Network", removeUrlPrefix(peering.Network)).
+		Set("State", peering.StateDetails).
+		Set("Auto Create Routes", peering.AutoCreateRoutes).
+		Set("Exchange Subnet Routes", peering.ExchangeSubnetRoutes).
+		Set("Export Custom Routes", peering.ExportCustomRoutes).
+		Set("Import Custom Routes", peering.ImportCustomRoutes).
+		Set("Export Subnet Routes With Public IP", peering.ExportSubnetRoutesWithPublicIp).
+		Set("Import Subnet Routes With Public IP", peering.ImportSubnetRoutesWithPublicIp).
+		Set("Creation Timestamp", inventory.ParseTimestamp(network.CreationTimestamp)).
+		SetRaw(peering)
 }
 
-func GetPreeingInventory(ctx context.Context, projectsId []*project.Project, log *logger.Logger) ([][]string, error) {
+func GetPreeingInventory(ctx context.Context, projectsId []*project.Project, opts *collector.Options) ([]*inventory.Resource, error) {
+	log := opts.Log
 	log.Infof("Getting Peering inventory")
 	defer log.Infof("Done Peering network inventory")
-	service, err := compute.NewService(ctx)
+	service, err := compute.NewService(ctx, opts.Clients.For(gcpclient.Compute)...)
 	if err != nil {
 		return nil, err
 	}
-	var inventory [][]string
-	inventory = append(inventory, peeringHeader)
+	var resources []*inventory.Resource
 	mutex := &sync.Mutex{}
 	wg := &sync.WaitGroup{}
 	wg.Add(len(projectsId))
 	for _, projectId := range projectsId {
 		go func(projectId *project.Project) {
 			defer wg.Done()
-			var localInventory [][]string
+			var localResources []*inventory.Resource
 			log.Infof("Getting network peering inventory for project %s", projectId.Name)
 			req := service.Networks.List(projectId.ID)
-			if err := req.Pages(ctx, func(page *compute.NetworkList) error {
-				for _, network := range page.Items {
-					for _, peering := range network.Peerings {
-						localInventory = append(localInventory, []string{
-							projectId.Name,
-							peering.Name,
-							removeUrlPrefix(network.Name),
-							removeUrlPrefix(peering.Network),
-							peering.StateDetails,
-							fmt.Sprintf("%t", peering.AutoCreateRoutes),
-							fmt.Sprintf("%t", peering.ExchangeSubnetRoutes),
-							fmt.Sprintf("%t", peering.ExportCustomRoutes),
-							fmt.Sprintf("%t", peering.ImportCustomRoutes),
-							fmt.Sprintf("%t", peering.ExportSubnetRoutesWithPublicIp),
-							fmt.Sprintf("%t", peering.ImportSubnetRoutesWithPublicIp),
-							network.CreationTimestamp,
-						})
+			start := len(localResources)
+			if err := opts.Call(ctx, "compute.networks.list", func() error {
+				localResources = localResources[:start]
+				return req.Pages(ctx, func(page *compute.NetworkList) error {
+					for _, network := range page.Items {
+						for _, peering := range network.Peerings {
+							localResources = append(localResources, newPeeringResource(projectId, network, peering))
+						}
 					}
-				}
-				return nil
+					return nil
+				})
 			}); err != nil {
 				log.Errorf("Failed to get network peering inventory for project %s , error: %s", projectId.Name, err.Error())
+				opts.Errors.Add(inventory.NewError(PeeringCollectorName, projectId.ID, "global", "compute.networks.list", err))
 			}
 			mutex.Lock()
-			inventory = append(inventory, localInventory...)
+			resources = append(resources, localResources...)
 			mutex.Unlock()
 		}(projectId)
 	}
 	wg.Wait()
-	return inventory, nil
+	return resources, nil
 }
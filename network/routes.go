@@ -2,70 +2,88 @@ package network
 
 import (
 	"context"
-	"fmt"
-	"github.com/liornabat/gcp_inventory_exporter/pkg/logger"
+	"github.com/liornabat/gcp_inventory_exporter/collector"
+	"github.com/liornabat/gcp_inventory_exporter/inventory"
+	"github.com/liornabat/gcp_inventory_exporter/pkg/gcpclient"
 	"github.com/liornabat/gcp_inventory_exporter/project"
 	"google.golang.org/api/compute/v1"
 	"sync"
 )
 
-var routesHeader = []string{
-	"Project",
-	"Name",
-	"Network",
-	"Dest Range",
-	"Priority",
-	"Next Hop IP",
-	"Next Hop Network",
-	"Next Hop Gateway",
-	"Next Hop Peering",
-	"Next Hop Ilb",
-	"Creation Timestamp",
+const RouteKind = "compute#route"
+
+var RouteSchema = &inventory.Schema{
+	Kind: RouteKind,
+	Key:  []string{"Project ID", "Name"},
+	Columns: []inventory.Column{
+		{Name: "Project", Type: inventory.StringColumn},
+		{Name: "Project ID", Type: inventory.StringColumn},
+		{Name: "Name", Type: inventory.StringColumn},
+		{Name: "Network", Type: inventory.StringColumn},
+		{Name: "Dest Range", Type: inventory.StringColumn},
+		{Name: "Priority", Type: inventory.IntColumn},
+		{Name: "Next Hop IP", Type: inventory.StringColumn},
+		{Name: "Next Hop Network", Type: inventory.StringColumn},
+		{Name: "Next Hop Gateway", Type: inventory.StringColumn},
+		{Name: "Next Hop Peering", Type: inventory.StringColumn},
+		{Name: "Next Hop Ilb", Type: inventory.StringColumn},
+		{Name: "Creation Timestamp", Type: inventory.TimestampColumn},
+	},
+}
+
+func newRouteResource(projectId *project.Project, route *compute.Route) *inventory.Resource {
+	return inventory.NewResource(RouteKind, projectId.ID, "global", route.Name).
+		Set("Project", projectId.Name).
+		Set("Project ID", projectId.ID).
+		Set("Name", route.Name).
+		Set("Network", removeUrlPrefix(route.Network)).
+		Set("Dest Range", route.DestRange).
+		Set("Priority", route.Priority).
+		Set("Next Hop IP", removeUrlPrefix(route.NextHopIp)).
+		Set("Next Hop Network", removeUrlPrefix(route.NextHopNetwork)).
+		Set("Next Hop Gateway", removeUrlPrefix(route.NextHopGateway)).
+		Set("Next Hop Peering", removeUrlPrefix(route.NextHopPeering)).
+		Set("Next Hop Ilb", removeUrlPrefix(route.NextHopIlb)).
+		Set("Creation Timestamp", inventory.ParseTimestamp(route.CreationTimestamp)).
+		SetRaw(route)
 }
 
-func GetRoutesInventory(ctx context.Context, projectsId []*project.Project, log *logger.Logger) ([][]string, error) {
+func GetRoutesInventory(ctx context.Context, projectsId []*project.Project, opts *collector.Options) ([]*inventory.Resource, error) {
+	log := opts.Log
 	log.Infof("Getting Routing inventory")
 	defer log.Infof("Done Routing network inventory")
-	service, err := compute.NewService(ctx)
+	service, err := compute.NewService(ctx, opts.Clients.For(gcpclient.Compute)...)
 	if err != nil {
 		return nil, err
 	}
-	var inventory [][]string
-	inventory = append(inventory, routesHeader)
+	var resources []*inventory.Resource
 	mutex := &sync.Mutex{}
 	wg := &sync.WaitGroup{}
 	wg.Add(len(projectsId))
 	for _, projectId := range projectsId {
 		go func(projectId *project.Project) {
 			defer wg.Done()
-			var localInventory [][]string
+			var localResources []*inventory.Resource
 			log.Infof("Getting routes inventory for project %s", projectId.Name)
 			req := service.Routes.List(projectId.ID)
-			if err := req.Pages(ctx, func(page *compute.RouteList) error {
-				for _, route := range page.Items {
-					localInventory = append(localInventory, []string{
-						projectId.Name,
-						route.Name,
-						removeUrlPrefix(route.Network),
-						route.DestRange,
-						fmt.Sprintf("%d", route.Priority),
-						removeUrlPrefix(route.NextHopIp),
-						removeUrlPrefix(route.NextHopNetwork),
-						removeUrlPrefix(route.NextHopGateway),
-						removeUrlPrefix(route.NextHopPeering),
-						removeUrlPrefix(route.NextHopIlb),
-						route.CreationTimestamp,
-					})
-				}
-				return nil
+			start := len(localResources)
+			if err := opts.Call(ctx, "compute.routes.list", func() error {
+				localResources = localResources[:start]
+				return req.Pages(ctx, func(page *compute.RouteList) error {
+					for _, route := range page.Items {
+						localResources = append(localResources, newRouteResource(projectId, route))
+					}
+					return nil
+				})
 			}); err != nil {
 				log.Errorf("Failed to get routes inventory for project %s , error: %s", projectId.Name, err.Error())
+				opts.Errors.Add(inventory.NewError(RoutesCollectorName, projectId.ID, "global", "compute.routes.list", err))
 			}
 			mutex.Lock()
-			inventory = append(inventory, localInventory...)
+			resources = append(resources, localResources...)
 			mutex.Unlock()
 		}(projectId)
 	}
 	wg.Wait()
-	return inventory, nil
+	return resources, nil
 }
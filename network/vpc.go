@@ -2,22 +2,30 @@ package network
 
 import (
 	"context"
-	"github.com/liornabat/gcp_inventory_exporter/config"
-	"github.com/liornabat/gcp_inventory_exporter/pkg/logger"
+	"github.com/liornabat/gcp_inventory_exporter/collector"
+	"github.com/liornabat/gcp_inventory_exporter/inventory"
+	"github.com/liornabat/gcp_inventory_exporter/pkg/gcpclient"
 	"github.com/liornabat/gcp_inventory_exporter/project"
 	"google.golang.org/api/compute/v1"
 	"strings"
 	"sync"
 )
 
-var networkHeader = []string{
-	"Project",
-	"Region",
-	"Name",
-	"Subnetwork",
-	"CIDR",
-	"Gateway Address",
-	"Creation Timestamp",
+const SubnetworkKind = "compute#subnetwork"
+
+var VPCSchema = &inventory.Schema{
+	Kind: SubnetworkKind,
+	Key:  []string{"Project ID", "Region", "Subnetwork"},
+	Columns: []inventory.Column{
+		{Name: "Project", Type: inventory.StringColumn},
+		{Name: "Project ID", Type: inventory.StringColumn},
+		{Name: "Region", Type: inventory.StringColumn},
+		{Name: "Name", Type: inventory.StringColumn},
+		{Name: "Subnetwork", Type: inventory.StringColumn},
+		{Name: "CIDR", Type: inventory.StringColumn},
+		{Name: "Gateway Address", Type: inventory.StringColumn},
+		{Name: "Creation Timestamp", Type: inventory.TimestampColumn},
+	},
 }
 
 func removeUrlPrefix(url string) string {
@@ -30,49 +38,60 @@ func removeUrlPrefixes(urls []string) []string {
 	}
 	return newUrls
 }
-func GetVPCInventory(ctx context.Context, projectsId []*project.Project, regions config.Regions, log *logger.Logger) ([][]string, error) {
+
+func newSubnetworkResource(projectId *project.Project, region string, subnetwork *compute.Subnetwork) *inventory.Resource {
+	return inventory.NewResource(SubnetworkKind, projectId.ID, region, subnetwork.Name).
+		Set("Project", projectId.Name).
+		Set("Project ID", projectId.ID).
+		Set("Region", region).
+		Set("Name", removeUrlPrefix(subnetwork.Network)).
+		Set("Subnetwork", subnetwork.Name).
+		Set("CIDR", subnetwork.IpCidrRange).
+		Set("Gateway Address", subnetwork.GatewayAddress).
+		Set("Creation Timestamp", inventory.ParseTimestamp(subnetwork.CreationTimestamp)).
+		SetRaw(subnetwork)
+}
+
+func GetVPCInventory(ctx context.Context, projectsId []*project.Project, opts *collector.Options) ([]*inventory.Resource, error) {
+	log := opts.Log
 	log.Infof("Getting network inventory")
 	defer log.Infof("Done getting network inventory")
-	service, err := compute.NewService(ctx)
+	service, err := compute.NewService(ctx, opts.Clients.For(gcpclient.Compute)...)
 	if err != nil {
 		return nil, err
 	}
-	var inventory [][]string
-	inventory = append(inventory, networkHeader)
+	var resources []*inventory.Resource
 	mutex := &sync.Mutex{}
 	wg := &sync.WaitGroup{}
 	wg.Add(len(projectsId))
 	for _, projectId := range projectsId {
 		go func(projectId *project.Project) {
 			defer wg.Done()
-			var localInventory [][]string
+			var localResources []*inventory.Resource
 			log.Infof("Getting network inventory for project %s", projectId.Name)
-			for _, region := range regions {
+			for _, region := range opts.Regions(ctx, projectId) {
 				log.Infof("Getting network inventory for project %s in region %s", projectId.Name, region)
 				req := service.Subnetworks.List(projectId.ID, region)
-				if err := req.Pages(ctx, func(page *compute.SubnetworkList) error {
-					for _, subnetwork := range page.Items {
-						localInventory = append(localInventory, []string{
-							projectId.Name,
-							region,
-							removeUrlPrefix(subnetwork.Network),
-							subnetwork.Name,
-							subnetwork.IpCidrRange,
-							subnetwork.GatewayAddress,
-							subnetwork.CreationTimestamp,
-						})
-					}
-					return nil
+				start := len(localResources)
+				if err := opts.Call(ctx, "compute.subnetworks.list", func() error {
+					localResources = localResources[:start]
+					return req.Pages(ctx, func(page *compute.SubnetworkList) error {
+						for _, subnetwork := range page.Items {
+							localResources = append(localResources, newSubnetworkResource(projectId, region, subnetwork))
+						}
+						return nil
+					})
 				}); err != nil {
 					log.Errorf("Failed to get subnetwork inventory for project %s in region %s, error: %s", projectId.Name, region, err.Error())
+					opts.Errors.Add(inventory.NewError(VPCCollectorName, projectId.ID, region, "compute.subnetworks.list", err))
 					continue
 				}
 			}
 			mutex.Lock()
-			inventory = append(inventory, localInventory...)
+			resources = append(resources, localResources...)
 			mutex.Unlock()
 		}(projectId)
 	}
 	wg.Wait()
-	return inventory, nil
+	return resources, nil
 }
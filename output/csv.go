@@ -0,0 +1,69 @@
+package output
+
+import (
+	"archive/zip"
+	"github.com/liornabat/gcp_inventory_exporter/config"
+	"github.com/liornabat/gcp_inventory_exporter/inventory"
+	"github.com/liornabat/gcp_inventory_exporter/pkg/csv"
+	"io"
+	"strings"
+)
+
+func init() {
+	Register(&csvFormat{options: csv.DefaultOptions()})
+}
+
+// csvFormat writes a zip archive with an RFC 4180 CSV file per sheet, e.g.
+// Compute.csv.
+type csvFormat struct {
+	options *csv.Options
+}
+
+func (f *csvFormat) Name() string {
+	return "csv"
+}
+
+func (f *csvFormat) Extension() string {
+	return "zip"
+}
+
+func (f *csvFormat) ContentType() string {
+	return "application/zip"
+}
+
+func (f *csvFormat) Configure(cfg *config.Config) (Format, error) {
+	delimiter, err := cfg.CSVDelimiterRune()
+	if err != nil {
+		return nil, err
+	}
+	return &csvFormat{
+		options: &csv.Options{
+			Delimiter: delimiter,
+			BOM:       cfg.CSVBOM,
+		},
+	}, nil
+}
+
+func (f *csvFormat) Write(w io.Writer, snapshot *inventory.Snapshot) error {
+	archive := zip.NewWriter(w)
+	for _, table := range append(snapshot.Tables, snapshot.ErrorsTable()) {
+		file, err := archive.CreateHeader(&zip.FileHeader{
+			Name:     csvFileName(table.Sheet),
+			Method:   zip.Deflate,
+			Modified: snapshot.StartTime,
+		})
+		if err != nil {
+			return err
+		}
+		if err := csv.Write(file, table.Rows(), f.options); err != nil {
+			return err
+		}
+	}
+	return archive.Close()
+}
+
+// csvFileName returns the name of the CSV file of a sheet, keeping the sheet
+// name readable but without path separators.
+func csvFileName(sheet string) string {
+	return strings.NewReplacer("/", "_", "\\", "_").Replace(sheet) + ".csv"
+}
@@ -0,0 +1,189 @@
+package output
+
+import (
+	_ "embed"
+	"encoding/json"
+	"github.com/liornabat/gcp_inventory_exporter/inventory"
+	"io"
+	"time"
+)
+
+const (
+	// JSONSchemaVersion is the version of the JSON and NDJSON records. It
+	// changes only with incompatible changes to the records.
+	JSONSchemaVersion = "1"
+	JSONSchemaID      = "urn:gcp-inventory-exporter:schema:inventory:v1"
+
+	RunKind = "inventory#run"
+)
+
+// JSONSchema is the JSON Schema of the JSON document and of the NDJSON lines.
+//
+//go:embed schemas/inventory-v1.schema.json
+var JSONSchema []byte
+
+func init() {
+	Register(&jsonFormat{})
+	Register(&ndjsonFormat{})
+}
+
+// Record is a resource as written to the JSON and NDJSON outputs. Attributes
+// holds a value for every column of the collector schema.
+type Record struct {
+	Kind       string                 `json:"kind"`
+	RunID      string                 `json:"runId"`
+	Collector  string                 `json:"collector"`
+	Project    string                 `json:"project"`
+	Location   string                 `json:"location"`
+	Name       string                 `json:"name"`
+	Attributes map[string]interface{} `json:"attributes"`
+	Labels     map[string]string      `json:"labels"`
+}
+
+type SkippedProjectRecord struct {
+	ID     string `json:"id"`
+	Reason string `json:"reason"`
+}
+
+// RunRecord describes the run, as the first line of the NDJSON output and
+// the run section of the JSON document.
+type RunRecord struct {
+	Kind            string                  `json:"kind"`
+	SchemaVersion   string                  `json:"schemaVersion"`
+	RunID           string                  `json:"runId"`
+	OrgId           string                  `json:"orgId"`
+	StartTime       time.Time               `json:"startTime"`
+	EndTime         time.Time               `json:"endTime"`
+	Errors          map[string]int          `json:"errors"`
+	Retries         map[string]int          `json:"retries"`
+	SkippedProjects []*SkippedProjectRecord `json:"skippedProjects"`
+}
+
+type ColumnRecord struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+type CollectorSection struct {
+	Name      string          `json:"name"`
+	Sheet     string          `json:"sheet"`
+	Kind      string          `json:"kind"`
+	Columns   []*ColumnRecord `json:"columns"`
+	Resources []*Record       `json:"resources"`
+}
+
+// Document is the JSON output: the run, a section per collector and the
+// collection errors.
+type Document struct {
+	Schema     string              `json:"$schema"`
+	Run        *RunRecord          `json:"run"`
+	Collectors []*CollectorSection `json:"collectors"`
+	Errors     []*Record           `json:"errors"`
+}
+
+func newRunRecord(snapshot *inventory.Snapshot) *RunRecord {
+	run := &RunRecord{
+		Kind:            RunKind,
+		SchemaVersion:   JSONSchemaVersion,
+		RunID:           snapshot.RunID,
+		OrgId:           snapshot.OrgId,
+		StartTime:       snapshot.StartTime,
+		EndTime:         snapshot.EndTime,
+		Errors:          snapshot.Errors.CountByCollector(),
+		Retries:         snapshot.Retries,
+		SkippedProjects: []*SkippedProjectRecord{},
+	}
+	for _, skipped := range snapshot.Skipped {
+		run.SkippedProjects = append(run.SkippedProjects, &SkippedProjectRecord{ID: skipped.ID, Reason: skipped.Reason})
+	}
+	return run
+}
+
+func newRecords(snapshot *inventory.Snapshot, table *inventory.Table) []*Record {
+	records := []*Record{}
+	for _, r := range table.Resources {
+		record := &Record{
+			Kind:       r.Kind,
+			RunID:      snapshot.RunID,
+			Collector:  table.Collector,
+			Project:    r.Project,
+			Location:   r.Location,
+			Name:       r.Name,
+			Attributes: map[string]interface{}{},
+			Labels:     r.Labels,
+		}
+		for _, column := range table.Schema.Columns {
+			value, _ := r.Get(column.Name)
+			record.Attributes[column.Name] = column.Value(value)
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+type jsonFormat struct{}
+
+func (f *jsonFormat) Name() string {
+	return "json"
+}
+
+func (f *jsonFormat) Extension() string {
+	return "json"
+}
+
+func (f *jsonFormat) ContentType() string {
+	return "application/json"
+}
+
+func (f *jsonFormat) Write(w io.Writer, snapshot *inventory.Snapshot) error {
+	document := &Document{
+		Schema:     JSONSchemaID,
+		Run:        newRunRecord(snapshot),
+		Collectors: []*CollectorSection{},
+		Errors:     newRecords(snapshot, snapshot.ErrorsTable()),
+	}
+	for _, table := range snapshot.Tables {
+		section := &CollectorSection{
+			Name:      table.Collector,
+			Sheet:     table.Sheet,
+			Kind:      table.Schema.Kind,
+			Resources: newRecords(snapshot, table),
+		}
+		for _, column := range table.Schema.Columns {
+			section.Columns = append(section.Columns, &ColumnRecord{Name: column.Name, Type: column.Type.String()})
+		}
+		document.Collectors = append(document.Collectors, section)
+	}
+	return json.NewEncoder(w).Encode(document)
+}
+
+// ndjsonFormat writes the run record followed by a line per resource and per
+// collection error.
+type ndjsonFormat struct{}
+
+func (f *ndjsonFormat) Name() string {
+	return "ndjson"
+}
+
+func (f *ndjsonFormat) Extension() string {
+	return "ndjson"
+}
+
+func (f *ndjsonFormat) ContentType() string {
+	return "application/x-ndjson"
+}
+
+func (f *ndjsonFormat) Write(w io.Writer, snapshot *inventory.Snapshot) error {
+	encoder := json.NewEncoder(w)
+	if err := encoder.Encode(newRunRecord(snapshot)); err != nil {
+		return err
+	}
+	for _, table := range append(snapshot.Tables, snapshot.ErrorsTable()) {
+		for _, record := range newRecords(snapshot, table) {
+			if err := encoder.Encode(record); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
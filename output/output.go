@@ -0,0 +1,80 @@
+package output
+
+import (
+	"fmt"
+	"github.com/liornabat/gcp_inventory_exporter/config"
+	"github.com/liornabat/gcp_inventory_exporter/inventory"
+	"io"
+	"sort"
+	"sync"
+)
+
+// Format renders a snapshot into a single file.
+type Format interface {
+	Name() string
+	Extension() string
+	ContentType() string
+	Write(w io.Writer, snapshot *inventory.Snapshot) error
+}
+
+var (
+	formatsMutex sync.RWMutex
+	formats      = map[string]Format{}
+)
+
+func Register(format Format) {
+	formatsMutex.Lock()
+	defer formatsMutex.Unlock()
+	if _, ok := formats[format.Name()]; ok {
+		panic(fmt.Sprintf("output format %s: registered twice", format.Name()))
+	}
+	formats[format.Name()] = format
+}
+
+func Get(name string) (Format, error) {
+	formatsMutex.RLock()
+	defer formatsMutex.RUnlock()
+	format, ok := formats[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown output format %s", name)
+	}
+	return format, nil
+}
+
+// Configurable is implemented by the formats with settings in the config.
+type Configurable interface {
+	// Configure returns the format set up with the settings of cfg.
+	Configure(cfg *config.Config) (Format, error)
+}
+
+// ForConfig returns the output format of cfg, set up with its settings.
+func ForConfig(cfg *config.Config) (Format, error) {
+	format, err := Get(cfg.OutputFormat)
+	if err != nil {
+		return nil, err
+	}
+	if configurable, ok := format.(Configurable); ok {
+		return configurable.Configure(cfg)
+	}
+	return format, nil
+}
+
+func Names() []string {
+	formatsMutex.RLock()
+	defer formatsMutex.RUnlock()
+	var names []string
+	for name := range formats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ObjectPrefix starts the default name of every exported object.
+const ObjectPrefix = "inventory-"
+
+// ObjectName returns the file name of the snapshot in the given format, e.g.
+// inventory-2023-03-01-10-00-00.xlsx.
+func ObjectName(snapshot *inventory.Snapshot, format Format) string {
+	return fmt.Sprintf("%s%s.%s", ObjectPrefix, snapshot.RunID, format.Extension())
+}
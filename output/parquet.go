@@ -0,0 +1,189 @@
+package output
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"github.com/liornabat/gcp_inventory_exporter/inventory"
+	"github.com/xitongsys/parquet-go/writer"
+	"io"
+	"time"
+)
+
+func init() {
+	Register(&parquetFormat{})
+}
+
+// parquetFormat writes a zip archive with a Parquet file per collector, e.g.
+// compute.parquet. WriteParquet writes a single one, e.g. to upload it next
+// to the workbook.
+type parquetFormat struct{}
+
+func (f *parquetFormat) Name() string {
+	return "parquet"
+}
+
+func (f *parquetFormat) Extension() string {
+	return "parquet.zip"
+}
+
+func (f *parquetFormat) ContentType() string {
+	return "application/zip"
+}
+
+func (f *parquetFormat) Write(w io.Writer, snapshot *inventory.Snapshot) error {
+	archive := zip.NewWriter(w)
+	for _, table := range append(snapshot.Tables, snapshot.ErrorsTable()) {
+		file, err := archive.CreateHeader(&zip.FileHeader{
+			Name:     table.Collector + ".parquet",
+			Method:   zip.Store,
+			Modified: snapshot.StartTime,
+		})
+		if err != nil {
+			return err
+		}
+		if err := WriteParquet(file, snapshot, table); err != nil {
+			return fmt.Errorf("%s: %s", table.Collector, err.Error())
+		}
+	}
+	return archive.Close()
+}
+
+const (
+	ParquetContentType = "application/vnd.apache.parquet"
+	// ParquetPrefix starts the name of the Parquet files uploaded next to
+	// the exports.
+	ParquetPrefix = "parquet/"
+)
+
+// ParquetObjectName returns the name of the Parquet file of a collector when
+// uploaded next to the export, e.g. parquet/compute/2023-03-01-10-00-00.parquet,
+// so that each collector is a dataset of its own.
+func ParquetObjectName(snapshot *inventory.Snapshot, table *inventory.Table) string {
+	return fmt.Sprintf("%s%s/%s.parquet", ParquetPrefix, table.Collector, snapshot.RunID)
+}
+
+// parquetColumn is a column of a Parquet file, named in snake case, e.g.
+// memory_mb for "Memory (MB)".
+type parquetColumn struct {
+	name   string
+	field  string
+	column inventory.Column
+}
+
+func parquetColumns(table *inventory.Table) []*parquetColumn {
+	var columns []*parquetColumn
+	for i, name := range table.Schema.FieldNames("run_id") {
+		columns = append(columns, &parquetColumn{name: name, field: fmt.Sprintf("F%d", i), column: table.Schema.Columns[i]})
+	}
+	return columns
+}
+
+func (c *parquetColumn) tag() map[string]interface{} {
+	var tag string
+	switch c.column.Type {
+	case inventory.IntColumn:
+		tag = "type=INT64"
+	case inventory.BoolColumn:
+		tag = "type=BOOLEAN"
+	case inventory.TimestampColumn:
+		tag = "type=INT64, logicaltype=TIMESTAMP, logicaltype.isadjustedtoutc=true, logicaltype.unit=MILLIS"
+	case inventory.StringListColumn:
+		return map[string]interface{}{
+			"Tag":    fmt.Sprintf("name=%s, inname=%s, type=LIST, repetitiontype=OPTIONAL", c.name, c.field),
+			"Fields": []map[string]string{{"Tag": "name=element, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=REQUIRED"}},
+		}
+	default:
+		tag = "type=BYTE_ARRAY, convertedtype=UTF8"
+	}
+	return map[string]interface{}{
+		"Tag": fmt.Sprintf("name=%s, inname=%s, %s, repetitiontype=OPTIONAL", c.name, c.field, tag),
+	}
+}
+
+// value converts an attribute to the type of the column. Values of another
+// type, such as timestamps that could not be parsed, are written as null.
+func (c *parquetColumn) value(value interface{}) interface{} {
+	switch c.column.Type {
+	case inventory.IntColumn:
+		switch v := value.(type) {
+		case int, int64:
+			return v
+		}
+	case inventory.BoolColumn:
+		if v, ok := value.(bool); ok {
+			return v
+		}
+	case inventory.TimestampColumn:
+		if v, ok := value.(time.Time); ok {
+			return v.UnixMilli()
+		}
+	case inventory.StringListColumn:
+		switch v := value.(type) {
+		case []string:
+			return v
+		case string:
+			return []string{v}
+		}
+	default:
+		if value != nil {
+			return c.column.Format(value)
+		}
+	}
+	return nil
+}
+
+// WriteParquet writes the resources of a table as a Parquet file with a
+// typed column per schema column, preceded by the run id and followed by the
+// resource labels.
+func WriteParquet(w io.Writer, snapshot *inventory.Snapshot, table *inventory.Table) error {
+	columns := parquetColumns(table)
+	fields := []map[string]interface{}{
+		{"Tag": "name=run_id, inname=RunID, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=REQUIRED"},
+	}
+	for _, c := range columns {
+		fields = append(fields, c.tag())
+	}
+	withLabels := true
+	for _, c := range columns {
+		withLabels = withLabels && c.name != "labels"
+	}
+	if withLabels {
+		fields = append(fields, map[string]interface{}{
+			"Tag": "name=labels, inname=Labels, type=MAP, repetitiontype=OPTIONAL",
+			"Fields": []map[string]string{
+				{"Tag": "name=key, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=REQUIRED"},
+				{"Tag": "name=value, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=OPTIONAL"},
+			},
+		})
+	}
+	schema, err := json.Marshal(map[string]interface{}{
+		"Tag":    "name=inventory, repetitiontype=REQUIRED",
+		"Fields": fields,
+	})
+	if err != nil {
+		return err
+	}
+	pw, err := writer.NewJSONWriterFromWriter(string(schema), w, 1)
+	if err != nil {
+		return err
+	}
+	for _, r := range table.Resources {
+		row := map[string]interface{}{"RunID": snapshot.RunID}
+		for _, c := range columns {
+			value, _ := r.Get(c.column.Name)
+			row[c.field] = c.value(value)
+		}
+		if withLabels {
+			row["Labels"] = r.Labels
+		}
+		data, err := json.Marshal(row)
+		if err != nil {
+			return err
+		}
+		if err := pw.Write(string(data)); err != nil {
+			return err
+		}
+	}
+	return pw.WriteStop()
+}
@@ -0,0 +1,228 @@
+package output
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"github.com/liornabat/gcp_inventory_exporter/inventory"
+	"io"
+	_ "modernc.org/sqlite"
+	"os"
+	"strings"
+	"time"
+)
+
+func init() {
+	Register(&sqliteFormat{})
+}
+
+// sqliteFormat writes a SQLite database with a table per collector and a
+// runs table describing the run. Timestamps are stored as RFC 3339 text and
+// lists and labels as JSON arrays and objects, which the SQLite date and JSON
+// functions read.
+type sqliteFormat struct{}
+
+func (f *sqliteFormat) Name() string {
+	return "sqlite"
+}
+
+func (f *sqliteFormat) Extension() string {
+	return "sqlite"
+}
+
+func (f *sqliteFormat) ContentType() string {
+	return "application/vnd.sqlite3"
+}
+
+// sqliteIndexed are the fields indexed in every table that has them.
+var sqliteIndexed = []string{"project", "project_id", "name"}
+
+func sqliteType(column inventory.Column) string {
+	switch column.Type {
+	case inventory.IntColumn, inventory.BoolColumn:
+		return "INTEGER"
+	default:
+		return "TEXT"
+	}
+}
+
+func quote(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// sqliteValue converts an attribute to the type of its column. Values of
+// another type, such as timestamps that could not be parsed, are written as
+// null.
+func sqliteValue(column inventory.Column, value interface{}) (interface{}, error) {
+	switch column.Type {
+	case inventory.IntColumn:
+		switch v := value.(type) {
+		case int, int64:
+			return v, nil
+		}
+	case inventory.BoolColumn:
+		if v, ok := value.(bool); ok {
+			return v, nil
+		}
+	case inventory.TimestampColumn:
+		if v, ok := value.(time.Time); ok {
+			return v.UTC().Format(time.RFC3339), nil
+		}
+	case inventory.StringListColumn:
+		switch v := value.(type) {
+		case []string:
+			data, err := json.Marshal(v)
+			return string(data), err
+		case string:
+			data, err := json.Marshal([]string{v})
+			return string(data), err
+		}
+	default:
+		if value != nil {
+			return column.Format(value), nil
+		}
+	}
+	return nil, nil
+}
+
+func (f *sqliteFormat) Write(w io.Writer, snapshot *inventory.Snapshot) error {
+	// SQLite needs a file it can seek in, so the database is built in a
+	// temporary file and then copied to w.
+	file, err := os.CreateTemp("", "inventory-*.sqlite")
+	if err != nil {
+		return err
+	}
+	file.Close()
+	defer os.Remove(file.Name())
+	if err := writeSQLite(file.Name(), snapshot); err != nil {
+		return err
+	}
+	file, err = os.Open(file.Name())
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = io.Copy(w, file)
+	return err
+}
+
+func writeSQLite(path string, snapshot *inventory.Snapshot) error {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := writeRunsTable(tx, snapshot); err != nil {
+		return fmt.Errorf("runs: %s", err.Error())
+	}
+	for _, table := range append(snapshot.Tables, snapshot.ErrorsTable()) {
+		if err := writeSQLiteTable(tx, snapshot, table); err != nil {
+			return fmt.Errorf("%s: %s", table.Collector, err.Error())
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	return db.Close()
+}
+
+func writeRunsTable(tx *sql.Tx, snapshot *inventory.Snapshot) error {
+	_, err := tx.Exec(`CREATE TABLE runs (
+		run_id TEXT PRIMARY KEY,
+		org_id TEXT NOT NULL,
+		start_time TEXT NOT NULL,
+		end_time TEXT NOT NULL,
+		resources INTEGER NOT NULL,
+		errors INTEGER NOT NULL,
+		retries INTEGER NOT NULL,
+		skipped_projects TEXT NOT NULL
+	)`)
+	if err != nil {
+		return err
+	}
+	resources, retries := 0, 0
+	for _, table := range snapshot.Tables {
+		resources += len(table.Resources)
+	}
+	for _, count := range snapshot.Retries {
+		retries += count
+	}
+	skipped := []*SkippedProjectRecord{}
+	for _, project := range snapshot.Skipped {
+		skipped = append(skipped, &SkippedProjectRecord{ID: project.ID, Reason: project.Reason})
+	}
+	skippedJSON, err := json.Marshal(skipped)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`INSERT INTO runs VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		snapshot.RunID,
+		snapshot.OrgId,
+		snapshot.StartTime.UTC().Format(time.RFC3339),
+		snapshot.EndTime.UTC().Format(time.RFC3339),
+		resources,
+		len(snapshot.ErrorsTable().Resources),
+		retries,
+		string(skippedJSON),
+	)
+	return err
+}
+
+// writeSQLiteTable creates the table of a collector, with the run id, a typed
+// column per schema column and the resource labels, and inserts its rows.
+func writeSQLiteTable(tx *sql.Tx, snapshot *inventory.Snapshot, table *inventory.Table) error {
+	names := table.Schema.FieldNames("run_id", "labels")
+	definitions := []string{"run_id TEXT NOT NULL"}
+	for i, column := range table.Schema.Columns {
+		definitions = append(definitions, fmt.Sprintf("%s %s", quote(names[i]), sqliteType(column)))
+	}
+	definitions = append(definitions, "labels TEXT NOT NULL")
+	name := quote(table.Collector)
+	if _, err := tx.Exec(fmt.Sprintf("CREATE TABLE %s (\n\t%s\n)", name, strings.Join(definitions, ",\n\t"))); err != nil {
+		return err
+	}
+	for _, field := range sqliteIndexed {
+		for _, n := range names {
+			if n != field {
+				continue
+			}
+			index := quote(fmt.Sprintf("%s_%s", table.Collector, field))
+			if _, err := tx.Exec(fmt.Sprintf("CREATE INDEX %s ON %s (%s)", index, name, quote(field))); err != nil {
+				return err
+			}
+		}
+	}
+	insert, err := tx.Prepare(fmt.Sprintf("INSERT INTO %s VALUES (%s)", name, strings.TrimSuffix(strings.Repeat("?, ", len(names)+2), ", ")))
+	if err != nil {
+		return err
+	}
+	defer insert.Close()
+	for _, r := range table.Resources {
+		values := []interface{}{snapshot.RunID}
+		for _, column := range table.Schema.Columns {
+			attribute, _ := r.Get(column.Name)
+			value, err := sqliteValue(column, attribute)
+			if err != nil {
+				return err
+			}
+			values = append(values, value)
+		}
+		labels := r.Labels
+		if labels == nil {
+			labels = map[string]string{}
+		}
+		labelsJSON, err := json.Marshal(labels)
+		if err != nil {
+			return err
+		}
+		if _, err := insert.Exec(append(values, string(labelsJSON))...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,39 @@
+package output
+
+import (
+	"github.com/liornabat/gcp_inventory_exporter/inventory"
+	"github.com/liornabat/gcp_inventory_exporter/pkg/xls"
+	"io"
+)
+
+func init() {
+	Register(&xlsxFormat{})
+}
+
+type xlsxFormat struct{}
+
+func (f *xlsxFormat) Name() string {
+	return "xlsx"
+}
+
+func (f *xlsxFormat) Extension() string {
+	return "xlsx"
+}
+
+func (f *xlsxFormat) ContentType() string {
+	return "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+}
+
+func (f *xlsxFormat) Write(w io.Writer, snapshot *inventory.Snapshot) error {
+	xlsFile := xls.NewXls()
+	defer xlsFile.Close()
+	for _, table := range append(snapshot.Tables, snapshot.ErrorsTable()) {
+		if err := xlsFile.SetDataToSheet(table.Sheet, table.Rows()); err != nil {
+			return err
+		}
+	}
+	if err := xlsFile.DeleteSheet("Sheet1"); err != nil {
+		return err
+	}
+	return xlsFile.Write(w)
+}
@@ -1,15 +1,65 @@
 package csv
 
-import "strings"
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"unicode/utf8"
+)
 
-func CreateCSVFile(data [][]string) ([]byte, error) {
-	sb := strings.Builder{}
-	for _, row := range data {
-		for _, cell := range row {
-			sb.WriteString(cell)
-			sb.WriteString(",")
+// bom is the UTF-8 byte order mark, which makes spreadsheet applications such
+// as Excel read the file as UTF-8.
+var bom = []byte{0xEF, 0xBB, 0xBF}
+
+type Options struct {
+	// Delimiter separates the fields, a comma when zero.
+	Delimiter rune
+	// BOM starts the file with a UTF-8 byte order mark.
+	BOM bool
+}
+
+func DefaultOptions() *Options {
+	return &Options{
+		Delimiter: ',',
+	}
+}
+
+// ValidDelimiter reports whether r can separate the fields: a delimiter can
+// not be a quote, a line break or the Unicode replacement character.
+func ValidDelimiter(r rune) bool {
+	return r != 0 && r != '"' && r != '\r' && r != '\n' && utf8.ValidRune(r) && r != utf8.RuneError
+}
+
+// Write writes the rows as RFC 4180 CSV: rows end with CRLF and fields that
+// hold the delimiter, a quote or a line break are quoted, with quotes doubled.
+func Write(w io.Writer, data [][]string, opts *Options) error {
+	if opts == nil {
+		opts = DefaultOptions()
+	}
+	writer := csv.NewWriter(w)
+	if opts.Delimiter != 0 {
+		if !ValidDelimiter(opts.Delimiter) {
+			return fmt.Errorf("invalid delimiter %q", opts.Delimiter)
+		}
+		writer.Comma = opts.Delimiter
+	}
+	writer.UseCRLF = true
+	if opts.BOM {
+		if _, err := w.Write(bom); err != nil {
+			return err
 		}
-		sb.WriteString("\n")
 	}
-	return []byte(sb.String()), nil
+	if err := writer.WriteAll(data); err != nil {
+		return err
+	}
+	return nil
+}
+
+func CreateCSVFile(data [][]string) ([]byte, error) {
+	buffer := &bytes.Buffer{}
+	if err := Write(buffer, data, DefaultOptions()); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
 }
@@ -0,0 +1,88 @@
+package csv
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestWrite(t *testing.T) {
+	tests := []struct {
+		name string
+		data [][]string
+		opts *Options
+		want string
+	}{
+		{
+			name: "default options",
+			data: [][]string{{"Name", "Zone"}, {"web-1", "me-west1-a"}},
+			want: "Name,Zone\r\nweb-1,me-west1-a\r\n",
+		},
+		{
+			name: "quoting",
+			data: [][]string{
+				{"Name", "Tags"},
+				{"web-1", "http,https"},
+				{`say "hi"`, "line 1\nline 2"},
+				{" padded", ""},
+			},
+			opts: DefaultOptions(),
+			// Line breaks within quoted fields are written as CRLF too.
+			want: "Name,Tags\r\n" +
+				"web-1,\"http,https\"\r\n" +
+				"\"say \"\"hi\"\"\",\"line 1\r\nline 2\"\r\n" +
+				"\" padded\",\r\n",
+		},
+		{
+			name: "semicolon delimiter",
+			data: [][]string{{"Name", "Tags"}, {"web-1", "http;https"}, {"web-2", "a,b"}},
+			opts: &Options{Delimiter: ';'},
+			want: "Name;Tags\r\nweb-1;\"http;https\"\r\nweb-2;a,b\r\n",
+		},
+		{
+			name: "tab delimiter",
+			data: [][]string{{"Name", "Description"}, {"web-1", "a\tb"}},
+			opts: &Options{Delimiter: '\t'},
+			want: "Name\tDescription\r\nweb-1\t\"a\tb\"\r\n",
+		},
+		{
+			name: "zero delimiter is a comma",
+			data: [][]string{{"a", "b;c"}},
+			opts: &Options{},
+			want: "a,b;c\r\n",
+		},
+		{
+			name: "byte order mark",
+			data: [][]string{{"Name"}, {"שרת"}},
+			opts: &Options{Delimiter: ',', BOM: true},
+			want: "\xEF\xBB\xBFName\r\nשרת\r\n",
+		},
+		{
+			name: "no rows",
+			opts: &Options{BOM: true},
+			want: "\xEF\xBB\xBF",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			buffer := &bytes.Buffer{}
+			if err := Write(buffer, test.data, test.opts); err != nil {
+				t.Fatal(err)
+			}
+			if got := buffer.String(); got != test.want {
+				t.Errorf("got %q, expected %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestWriteInvalidDelimiter(t *testing.T) {
+	for _, delimiter := range []rune{'"', '\r', '\n', 0xFFFD} {
+		buffer := &bytes.Buffer{}
+		if err := Write(buffer, [][]string{{"a"}}, &Options{Delimiter: delimiter}); err == nil {
+			t.Errorf("delimiter %q was accepted", delimiter)
+		}
+		if buffer.Len() > 0 {
+			t.Errorf("delimiter %q: got output %q", delimiter, buffer.String())
+		}
+	}
+}
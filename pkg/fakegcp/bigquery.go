@@ -0,0 +1,293 @@
+package fakegcp
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+type bqField struct {
+	Name        string     `json:"name"`
+	Type        string     `json:"type"`
+	Mode        string     `json:"mode,omitempty"`
+	Description string     `json:"description,omitempty"`
+	Fields      []*bqField `json:"fields,omitempty"`
+}
+
+type bqSchema struct {
+	Fields []*bqField `json:"fields"`
+}
+
+type bqTable struct {
+	resource  map[string]interface{}
+	schema    *bqSchema
+	rows      []map[string]interface{}
+	insertIDs map[string]bool
+	// pending are the fields added by the last patch, which the next
+	// insertAll request does not see yet.
+	pending map[string]bool
+	// created is set until the table is read or inserted into, the first
+	// insertAll request not finding the new table yet.
+	created bool
+}
+
+type bqDataset struct {
+	resource map[string]interface{}
+	tables   map[string]*bqTable
+}
+
+// TableRows returns the rows inserted into a table of the fake BigQuery.
+func (s *Server) TableRows(project, dataset, table string) ([]map[string]interface{}, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	t, ok := s.datasets[project+"/"+dataset].tables[table]
+	if !ok {
+		return nil, false
+	}
+	return t.rows, true
+}
+
+// serveBigQuery answers the dataset, table and tabledata requests of the
+// BigQuery API, under bigquery/v2/projects/<project>/datasets.
+func (s *Server) serveBigQuery(w http.ResponseWriter, r *http.Request, path string) {
+	parts := strings.Split(strings.TrimPrefix(path, "bigquery/v2/projects/"), "/")
+	if len(parts) < 2 || parts[1] != "datasets" {
+		writeError(w, http.StatusNotImplemented, fmt.Sprintf("%s %s is not supported", r.Method, r.URL.Path))
+		return
+	}
+	project := parts[0]
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	switch {
+	case len(parts) == 2 && r.Method == http.MethodPost:
+		s.insertDataset(w, r, project)
+	case len(parts) == 3 && r.Method == http.MethodGet:
+		if d, ok := s.datasets[project+"/"+parts[2]]; ok {
+			writeJSON(w, d.resource)
+		} else {
+			writeError(w, http.StatusNotFound, fmt.Sprintf("Not found: Dataset %s:%s", project, parts[2]))
+		}
+	case len(parts) >= 4 && parts[3] == "tables":
+		d, ok := s.datasets[project+"/"+parts[2]]
+		if !ok {
+			writeError(w, http.StatusNotFound, fmt.Sprintf("Not found: Dataset %s:%s", project, parts[2]))
+			return
+		}
+		s.serveTable(w, r, d, parts[4:])
+	default:
+		writeError(w, http.StatusNotImplemented, fmt.Sprintf("%s %s is not supported", r.Method, r.URL.Path))
+	}
+}
+
+func (s *Server) insertDataset(w http.ResponseWriter, r *http.Request, project string) {
+	var resource map[string]interface{}
+	if err := json.NewDecoder(r.Body).Decode(&resource); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	reference, _ := resource["datasetReference"].(map[string]interface{})
+	id, _ := reference["datasetId"].(string)
+	if id == "" {
+		writeError(w, http.StatusBadRequest, "Missing datasetReference.datasetId")
+		return
+	}
+	if _, ok := s.datasets[project+"/"+id]; ok {
+		writeError(w, http.StatusConflict, fmt.Sprintf("Already Exists: Dataset %s:%s", project, id))
+		return
+	}
+	resource["kind"] = "bigquery#dataset"
+	resource["id"] = project + ":" + id
+	s.datasets[project+"/"+id] = &bqDataset{resource: resource, tables: map[string]*bqTable{}}
+	writeJSON(w, resource)
+}
+
+func (s *Server) serveTable(w http.ResponseWriter, r *http.Request, d *bqDataset, parts []string) {
+	if len(parts) == 0 && r.Method == http.MethodPost {
+		s.insertTable(w, r, d)
+		return
+	}
+	if len(parts) == 0 {
+		writeError(w, http.StatusNotImplemented, fmt.Sprintf("%s %s is not supported", r.Method, r.URL.Path))
+		return
+	}
+	t, ok := d.tables[parts[0]]
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("Not found: Table %s", parts[0]))
+		return
+	}
+	switch {
+	case len(parts) == 1 && r.Method == http.MethodGet:
+		t.created = false
+		writeJSON(w, t.resource)
+	case len(parts) == 1 && (r.Method == http.MethodPatch || r.Method == http.MethodPut):
+		patchTable(w, r, t)
+	case len(parts) == 2 && parts[1] == "insertAll" && r.Method == http.MethodPost:
+		insertRows(w, r, t)
+	case len(parts) == 2 && parts[1] == "data" && r.Method == http.MethodGet:
+		listRows(w, t)
+	default:
+		writeError(w, http.StatusNotImplemented, fmt.Sprintf("%s %s is not supported", r.Method, r.URL.Path))
+	}
+}
+
+func (s *Server) insertTable(w http.ResponseWriter, r *http.Request, d *bqDataset) {
+	var resource map[string]interface{}
+	if err := json.NewDecoder(r.Body).Decode(&resource); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	reference, _ := resource["tableReference"].(map[string]interface{})
+	id, _ := reference["tableId"].(string)
+	if id == "" {
+		writeError(w, http.StatusBadRequest, "Missing tableReference.tableId")
+		return
+	}
+	if _, ok := d.tables[id]; ok {
+		writeError(w, http.StatusConflict, fmt.Sprintf("Already Exists: Table %s", id))
+		return
+	}
+	schema, err := decodeSchema(resource["schema"])
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	resource["kind"] = "bigquery#table"
+	d.tables[id] = &bqTable{resource: resource, schema: schema, insertIDs: map[string]bool{}, created: true}
+	writeJSON(w, resource)
+}
+
+func decodeSchema(value interface{}) (*bqSchema, error) {
+	schema := &bqSchema{}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, schema); err != nil {
+		return nil, fmt.Errorf("Invalid schema: %s", err.Error())
+	}
+	return schema, nil
+}
+
+// patchTable replaces the schema of a table. Like BigQuery, it only allows
+// adding fields that are not required, and the added fields are not seen by
+// insertAll right away.
+func patchTable(w http.ResponseWriter, r *http.Request, t *bqTable) {
+	var patch map[string]interface{}
+	if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if value, ok := patch["schema"]; ok {
+		schema, err := decodeSchema(value)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		fields := map[string]*bqField{}
+		for _, field := range schema.Fields {
+			fields[field.Name] = field
+		}
+		for _, field := range t.schema.Fields {
+			updated, ok := fields[field.Name]
+			if !ok {
+				writeError(w, http.StatusBadRequest, fmt.Sprintf("Provided Schema does not match Table. Field %s is missing in new schema", field.Name))
+				return
+			}
+			if updated.Type != field.Type {
+				writeError(w, http.StatusBadRequest, fmt.Sprintf("Provided Schema does not match Table. Field %s has changed type from %s to %s", field.Name, field.Type, updated.Type))
+				return
+			}
+			delete(fields, field.Name)
+		}
+		t.pending = map[string]bool{}
+		for _, field := range fields {
+			if field.Mode == "REQUIRED" {
+				writeError(w, http.StatusBadRequest, fmt.Sprintf("Provided Schema does not match Table. Cannot add required field %s", field.Name))
+				return
+			}
+			t.pending[field.Name] = true
+		}
+		t.schema = schema
+		t.resource["schema"] = patch["schema"]
+	}
+	writeJSON(w, t.resource)
+}
+
+// insertRows appends the rows of an insertAll request. Like BigQuery, it does
+// not find a table created right before it. Rows with a field that is not in
+// the schema, or was added since the previous request, or without a required
+// field are rejected, and rows with an insert id seen before are dropped.
+func insertRows(w http.ResponseWriter, r *http.Request, t *bqTable) {
+	var request struct {
+		Rows []struct {
+			InsertID string                 `json:"insertId"`
+			JSON     map[string]interface{} `json:"json"`
+		} `json:"rows"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if t.created {
+		t.created = false
+		writeError(w, http.StatusNotFound, "Not found: Table")
+		return
+	}
+	fields := map[string]*bqField{}
+	for _, field := range t.schema.Fields {
+		fields[field.Name] = field
+	}
+	var insertErrors []map[string]interface{}
+	for i, row := range request.Rows {
+		var message string
+		for name := range row.JSON {
+			if _, ok := fields[name]; !ok || t.pending[name] {
+				message = "no such field: " + name
+			}
+		}
+		for _, field := range t.schema.Fields {
+			if field.Mode == "REQUIRED" && row.JSON[field.Name] == nil {
+				message = "missing required field: " + field.Name
+			}
+		}
+		if message != "" {
+			insertErrors = append(insertErrors, map[string]interface{}{
+				"index":  i,
+				"errors": []map[string]interface{}{{"reason": "invalid", "message": message}},
+			})
+		}
+	}
+	t.pending = nil
+	if len(insertErrors) == 0 {
+		for _, row := range request.Rows {
+			if row.InsertID != "" && t.insertIDs[row.InsertID] {
+				continue
+			}
+			t.insertIDs[row.InsertID] = true
+			t.rows = append(t.rows, row.JSON)
+		}
+	}
+	writeJSON(w, map[string]interface{}{
+		"kind":         "bigquery#tableDataInsertAllResponse",
+		"insertErrors": insertErrors,
+	})
+}
+
+// listRows answers with the rows of a table in the f/v form of the
+// tabledata.list API, with a cell per schema field.
+func listRows(w http.ResponseWriter, t *bqTable) {
+	rows := []map[string]interface{}{}
+	for _, row := range t.rows {
+		var cells []map[string]interface{}
+		for _, field := range t.schema.Fields {
+			cells = append(cells, map[string]interface{}{"v": row[field.Name]})
+		}
+		rows = append(rows, map[string]interface{}{"f": cells})
+	}
+	writeJSON(w, map[string]interface{}{
+		"kind":      "bigquery#tableDataList",
+		"totalRows": fmt.Sprintf("%d", len(t.rows)),
+		"rows":      rows,
+	})
+}
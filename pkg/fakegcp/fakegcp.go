@@ -0,0 +1,358 @@
+package fakegcp
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultFixtures holds a small organization with one project, served when
+// no other fixtures are given.
+//
+//go:embed fixtures
+var defaultFixtures embed.FS
+
+func DefaultFixtures() fs.FS {
+	fixtures, err := fs.Sub(defaultFixtures, "fixtures")
+	if err != nil {
+		panic(err)
+	}
+	return fixtures
+}
+
+type object struct {
+	contentType string
+	data        []byte
+	created     time.Time
+}
+
+// Server is a local stand-in for the Cloud Asset, Cloud Resource Manager,
+// Compute, Storage and BigQuery JSON APIs. GET requests are answered from JSON
+// fixture files laid out like the request paths, e.g. a request for
+// /compute/v1/projects/p/aggregated/instances is answered with
+// compute/v1/projects/p/aggregated/instances.json. The page for a pageToken t
+// is read from the same path with an "@t" suffix, e.g. instances@t.json. Cloud
+// Resource Manager lists of a parent are read from under the parent, e.g.
+// cloudresourcemanager/v3/folders/f/projects.json, and Cloud Asset lists from
+// under the asset type, e.g.
+// cloudasset/v1/organizations/o/assets/compute.googleapis.com/Instance.json.
+// Storage buckets and objects, and BigQuery datasets, tables and rows, can be
+// created and are kept in memory.
+type Server struct {
+	fixtures fs.FS
+	server   *httptest.Server
+	mutex    sync.Mutex
+	buckets  map[string]string
+	objects  map[string]map[string]*object
+	datasets map[string]*bqDataset
+}
+
+func NewServer(fixtures fs.FS) *Server {
+	if fixtures == nil {
+		fixtures = DefaultFixtures()
+	}
+	return &Server{
+		fixtures: fixtures,
+		buckets:  map[string]string{},
+		objects:  map[string]map[string]*object{},
+		datasets: map[string]*bqDataset{},
+	}
+}
+
+// Start serves on a random local port and returns the endpoint to use as the
+// exporter API endpoint.
+func (s *Server) Start() string {
+	s.server = httptest.NewServer(s)
+	return s.server.URL
+}
+
+func (s *Server) Close() {
+	if s.server != nil {
+		s.server.Close()
+	}
+}
+
+func (s *Server) URL() string {
+	if s.server == nil {
+		return ""
+	}
+	return s.server.URL
+}
+
+// PutObject adds an object to a bucket of the fake storage as if it was
+// uploaded at created, creating the bucket if needed.
+func (s *Server) PutObject(bucket, name, contentType string, data []byte, created time.Time) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, ok := s.buckets[bucket]; !ok {
+		s.buckets[bucket] = ""
+		s.objects[bucket] = map[string]*object{}
+	}
+	s.objects[bucket][name] = &object{
+		contentType: contentType,
+		data:        data,
+		created:     created,
+	}
+}
+
+// Object returns the content of an object uploaded to the fake storage.
+func (s *Server) Object(bucket, name string) ([]byte, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	o, ok := s.objects[bucket][name]
+	if !ok {
+		return nil, false
+	}
+	return o.data, true
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(r.URL.Path, "/")
+	switch {
+	case strings.HasPrefix(path, "upload/storage/v1/b/"):
+		s.uploadObject(w, r, strings.TrimPrefix(path, "upload/storage/v1/b/"))
+	case path == "storage/v1/b" && r.Method == http.MethodPost:
+		s.createBucket(w, r)
+	case strings.HasPrefix(path, "storage/v1/b/") && strings.HasSuffix(path, "/o") && r.Method == http.MethodGet:
+		s.listObjects(w, r, strings.TrimSuffix(strings.TrimPrefix(path, "storage/v1/b/"), "/o"))
+	case strings.HasPrefix(path, "storage/v1/b/") && strings.Contains(path, "/o/") && r.Method == http.MethodDelete:
+		s.deleteObject(w, strings.TrimPrefix(path, "storage/v1/b/"))
+	case strings.HasPrefix(path, "bigquery/v2/projects/"):
+		s.serveBigQuery(w, r, path)
+	case strings.HasPrefix(path, "storage/v1/b/") && r.Method == http.MethodGet:
+		s.getBucket(w, r, path)
+	case r.Method == http.MethodGet && s.isBucket(strings.SplitN(path, "/", 2)[0]):
+		s.readObject(w, path)
+	case r.Method == http.MethodGet:
+		s.serveFixture(w, r, path)
+	default:
+		writeError(w, http.StatusNotImplemented, fmt.Sprintf("%s %s is not supported", r.Method, r.URL.Path))
+	}
+}
+
+func fixturePath(r *http.Request, path string) string {
+	query := r.URL.Query()
+	if path == "storage/v1/b" && query.Get("project") != "" {
+		path = "storage/v1/projects/" + query.Get("project") + "/buckets"
+	}
+	if parent := query.Get("parent"); parent != "" && strings.HasPrefix(path, "cloudresourcemanager/v3/") {
+		path = "cloudresourcemanager/v3/" + parent + "/" + strings.TrimPrefix(path, "cloudresourcemanager/v3/")
+	}
+	if assetType := query.Get("assetTypes"); assetType != "" && strings.HasPrefix(path, "cloudasset/") {
+		path += "/" + assetType
+	}
+	if token := query.Get("pageToken"); token != "" {
+		path += "@" + token
+	}
+	return path + ".json"
+}
+
+func (s *Server) serveFixture(w http.ResponseWriter, r *http.Request, path string) {
+	data, err := fs.ReadFile(s.fixtures, fixturePath(r, path))
+	if err != nil {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("The resource '%s' was not found", r.URL.Path))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+func (s *Server) isBucket(name string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	_, ok := s.buckets[name]
+	return ok
+}
+
+func (s *Server) createBucket(w http.ResponseWriter, r *http.Request) {
+	var bucket struct {
+		Name string `json:"name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&bucket); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	s.mutex.Lock()
+	s.buckets[bucket.Name] = r.URL.Query().Get("project")
+	s.objects[bucket.Name] = map[string]*object{}
+	s.mutex.Unlock()
+	writeJSON(w, bucketResource(bucket.Name))
+}
+
+func (s *Server) getBucket(w http.ResponseWriter, r *http.Request, path string) {
+	name := strings.SplitN(strings.TrimPrefix(path, "storage/v1/b/"), "/", 2)[0]
+	if !s.isBucket(name) {
+		s.serveFixture(w, r, path)
+		return
+	}
+	writeJSON(w, bucketResource(name))
+}
+
+func (s *Server) uploadObject(w http.ResponseWriter, r *http.Request, path string) {
+	bucket := strings.TrimSuffix(path, "/o")
+	if !s.isBucket(bucket) {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("The specified bucket %s does not exist", bucket))
+		return
+	}
+	name := r.URL.Query().Get("name")
+	contentType := r.Header.Get("Content-Type")
+	var data []byte
+	var err error
+	mediaType, params, _ := mime.ParseMediaType(contentType)
+	if strings.HasPrefix(mediaType, "multipart/") {
+		name, contentType, data, err = readMultipart(r.Body, params["boundary"])
+	} else {
+		data, err = io.ReadAll(r.Body)
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	o := &object{
+		contentType: contentType,
+		data:        data,
+		created:     time.Now(),
+	}
+	s.mutex.Lock()
+	s.objects[bucket][name] = o
+	s.mutex.Unlock()
+	writeJSON(w, objectResource(bucket, name, o))
+}
+
+func readMultipart(body io.Reader, boundary string) (string, string, []byte, error) {
+	reader := multipart.NewReader(body, boundary)
+	metadataPart, err := reader.NextPart()
+	if err != nil {
+		return "", "", nil, err
+	}
+	var metadata struct {
+		Name        string `json:"name"`
+		ContentType string `json:"contentType"`
+	}
+	if err := json.NewDecoder(metadataPart).Decode(&metadata); err != nil {
+		return "", "", nil, err
+	}
+	mediaPart, err := reader.NextPart()
+	if err != nil {
+		return "", "", nil, err
+	}
+	data, err := io.ReadAll(mediaPart)
+	if err != nil {
+		return "", "", nil, err
+	}
+	contentType := metadata.ContentType
+	if contentType == "" {
+		contentType = mediaPart.Header.Get("Content-Type")
+	}
+	return metadata.Name, contentType, data, nil
+}
+
+func (s *Server) readObject(w http.ResponseWriter, path string) {
+	parts := strings.SplitN(path, "/", 2)
+	name := ""
+	if len(parts) == 2 {
+		name, _ = url.PathUnescape(parts[1])
+	}
+	s.mutex.Lock()
+	o, ok := s.objects[parts[0]][name]
+	s.mutex.Unlock()
+	if !ok {
+		writeError(w, http.StatusNotFound, "No such object")
+		return
+	}
+	w.Header().Set("Content-Type", o.contentType)
+	w.Write(o.data)
+}
+
+func (s *Server) listObjects(w http.ResponseWriter, r *http.Request, bucket string) {
+	if !s.isBucket(bucket) {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("The specified bucket %s does not exist", bucket))
+		return
+	}
+	prefix := r.URL.Query().Get("prefix")
+	s.mutex.Lock()
+	var names []string
+	for name := range s.objects[bucket] {
+		if strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	items := []map[string]interface{}{}
+	for _, name := range names {
+		items = append(items, objectResource(bucket, name, s.objects[bucket][name]))
+	}
+	s.mutex.Unlock()
+	writeJSON(w, map[string]interface{}{
+		"kind":  "storage#objects",
+		"items": items,
+	})
+}
+
+func (s *Server) deleteObject(w http.ResponseWriter, path string) {
+	parts := strings.SplitN(path, "/o/", 2)
+	name, _ := url.PathUnescape(parts[1])
+	s.mutex.Lock()
+	_, ok := s.objects[parts[0]][name]
+	delete(s.objects[parts[0]], name)
+	s.mutex.Unlock()
+	if !ok {
+		writeError(w, http.StatusNotFound, "No such object")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func objectResource(bucket, name string, o *object) map[string]interface{} {
+	return map[string]interface{}{
+		"kind":        "storage#object",
+		"bucket":      bucket,
+		"name":        name,
+		"contentType": o.contentType,
+		"size":        fmt.Sprintf("%d", len(o.data)),
+		"timeCreated": o.created.UTC().Format(time.RFC3339Nano),
+		"updated":     o.created.UTC().Format(time.RFC3339Nano),
+	}
+}
+
+func bucketResource(name string) map[string]interface{} {
+	return map[string]interface{}{
+		"kind":         "storage#bucket",
+		"id":           name,
+		"name":         name,
+		"location":     "US",
+		"storageClass": "STANDARD",
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeError answers in the error format of the Google JSON APIs so that
+// clients return a *googleapi.Error.
+func writeError(w http.ResponseWriter, code int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": map[string]interface{}{
+			"code":    code,
+			"message": message,
+			"errors": []map[string]interface{}{
+				{"message": message, "reason": http.StatusText(code)},
+			},
+		},
+	})
+}
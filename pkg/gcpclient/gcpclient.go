@@ -0,0 +1,65 @@
+package gcpclient
+
+import (
+	"google.golang.org/api/option"
+	"strings"
+)
+
+const (
+	CloudAsset      = "cloudasset"
+	Compute         = "compute"
+	ResourceManager = "cloudresourcemanager"
+	Storage         = "storage"
+	BigQuery        = "bigquery"
+)
+
+// basePaths are the paths under an endpoint override at which each API is
+// served, see pkg/fakegcp.
+var basePaths = map[string]string{
+	CloudAsset:      "cloudasset/",
+	Compute:         "compute/v1/",
+	ResourceManager: "cloudresourcemanager/",
+	Storage:         "storage/v1/",
+	BigQuery:        "bigquery/v2/",
+}
+
+// Options holds the client options used to create the GCP API services. With
+// an endpoint set, every API is sent unauthenticated to that endpoint instead
+// of the real Google APIs.
+type Options struct {
+	endpoint  string
+	endpoints map[string]string
+	extra     []option.ClientOption
+}
+
+func NewOptions(endpoint string, extra ...option.ClientOption) *Options {
+	return &Options{
+		endpoint:  strings.TrimSuffix(endpoint, "/"),
+		endpoints: map[string]string{},
+		extra:     extra,
+	}
+}
+
+// SetEndpoint sends a single API to an endpoint of its own, e.g. a local
+// BigQuery emulator, taking precedence over the endpoint of every API.
+func (o *Options) SetEndpoint(api, endpoint string) {
+	o.endpoints[api] = strings.TrimSuffix(endpoint, "/")
+}
+
+func (o *Options) For(api string) []option.ClientOption {
+	if o == nil {
+		return nil
+	}
+	var opts []option.ClientOption
+	endpoint := o.endpoint
+	if o.endpoints[api] != "" {
+		endpoint = o.endpoints[api]
+	}
+	if endpoint != "" {
+		opts = append(opts,
+			option.WithEndpoint(endpoint+"/"+basePaths[api]),
+			option.WithoutAuthentication(),
+		)
+	}
+	return append(opts, o.extra...)
+}
@@ -0,0 +1,98 @@
+package limiter
+
+import (
+	"context"
+	"strings"
+	"sync"
+)
+
+// Limiter bounds the number of in-flight API requests, both across all APIs
+// and per API. A limit of zero or less means unlimited.
+type Limiter struct {
+	global      chan struct{}
+	perAPILimit int
+	mutex       sync.Mutex
+	perAPI      map[string]chan struct{}
+}
+
+func NewLimiter(maxInFlight, maxInFlightPerAPI int) *Limiter {
+	l := &Limiter{
+		perAPILimit: maxInFlightPerAPI,
+		perAPI:      map[string]chan struct{}{},
+	}
+	if maxInFlight > 0 {
+		l.global = make(chan struct{}, maxInFlight)
+	}
+	return l
+}
+
+// apiKey maps a method name such as "compute.instances.list" to its API.
+func apiKey(method string) string {
+	return strings.SplitN(method, ".", 2)[0]
+}
+
+func (l *Limiter) apiSemaphore(method string) chan struct{} {
+	if l.perAPILimit <= 0 {
+		return nil
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	key := apiKey(method)
+	sem, ok := l.perAPI[key]
+	if !ok {
+		sem = make(chan struct{}, l.perAPILimit)
+		l.perAPI[key] = sem
+	}
+	return sem
+}
+
+func acquire(ctx context.Context, sem chan struct{}) error {
+	if sem == nil {
+		return nil
+	}
+	select {
+	case sem <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func release(sem chan struct{}) {
+	if sem == nil {
+		return
+	}
+	<-sem
+}
+
+func (l *Limiter) Acquire(ctx context.Context, method string) error {
+	if l == nil {
+		return nil
+	}
+	sem := l.apiSemaphore(method)
+	if err := acquire(ctx, sem); err != nil {
+		return err
+	}
+	if err := acquire(ctx, l.global); err != nil {
+		release(sem)
+		return err
+	}
+	return nil
+}
+
+func (l *Limiter) Release(method string) {
+	if l == nil {
+		return
+	}
+	release(l.global)
+	release(l.apiSemaphore(method))
+}
+
+// Do runs fn while holding a slot for method.
+func (l *Limiter) Do(ctx context.Context, method string, fn func() error) error {
+	if err := l.Acquire(ctx, method); err != nil {
+		return err
+	}
+	defer l.Release(method)
+	return fn()
+}
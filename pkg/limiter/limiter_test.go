@@ -0,0 +1,127 @@
+package limiter
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// inFlight tracks the highest number of concurrent calls per API.
+type inFlight struct {
+	mutex   sync.Mutex
+	current map[string]int
+	max     map[string]int
+	total   int
+	maxAll  int
+}
+
+func newInFlight() *inFlight {
+	return &inFlight{current: map[string]int{}, max: map[string]int{}}
+}
+
+func (f *inFlight) call(api string) {
+	f.mutex.Lock()
+	f.current[api]++
+	f.total++
+	if f.current[api] > f.max[api] {
+		f.max[api] = f.current[api]
+	}
+	if f.total > f.maxAll {
+		f.maxAll = f.total
+	}
+	f.mutex.Unlock()
+	time.Sleep(5 * time.Millisecond)
+	f.mutex.Lock()
+	f.current[api]--
+	f.total--
+	f.mutex.Unlock()
+}
+
+func TestLimiterDo(t *testing.T) {
+	tests := []struct {
+		name        string
+		maxInFlight int
+		maxPerAPI   int
+		wantAll     int
+		wantPerAPI  int
+	}{
+		{name: "global limit", maxInFlight: 3, maxPerAPI: 0, wantAll: 3, wantPerAPI: 3},
+		{name: "per API limit", maxInFlight: 0, maxPerAPI: 2, wantAll: 4, wantPerAPI: 2},
+		{name: "both limits", maxInFlight: 3, maxPerAPI: 2, wantAll: 3, wantPerAPI: 2},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			l := NewLimiter(test.maxInFlight, test.maxPerAPI)
+			f := newInFlight()
+			wg := &sync.WaitGroup{}
+			for i := 0; i < 10; i++ {
+				for _, method := range []string{"compute.instances.list", "storage.buckets.list"} {
+					wg.Add(1)
+					go func(method string) {
+						defer wg.Done()
+						l.Do(context.Background(), method, func() error {
+							f.call(apiKey(method))
+							return nil
+						})
+					}(method)
+				}
+			}
+			wg.Wait()
+			if f.maxAll > test.wantAll {
+				t.Errorf("got %d calls in flight, expected at most %d", f.maxAll, test.wantAll)
+			}
+			for api, max := range f.max {
+				if max > test.wantPerAPI {
+					t.Errorf("got %d %s calls in flight, expected at most %d", max, api, test.wantPerAPI)
+				}
+			}
+		})
+	}
+}
+
+func TestLimiterSharesAPISlots(t *testing.T) {
+	l := NewLimiter(0, 1)
+	ctx := context.Background()
+	if err := l.Acquire(ctx, "compute.instances.list"); err != nil {
+		t.Fatal(err)
+	}
+	// Methods of the same API wait for the slot, other APIs do not.
+	if err := l.Acquire(ctx, "storage.buckets.list"); err != nil {
+		t.Fatal(err)
+	}
+	timeout, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if err := l.Acquire(timeout, "compute.regions.list"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got %v while the compute slot was held, expected a deadline error", err)
+	}
+	l.Release("compute.instances.list")
+	if err := l.Acquire(ctx, "compute.regions.list"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLimiterCanceled(t *testing.T) {
+	l := NewLimiter(1, 0)
+	ctx := context.Background()
+	if err := l.Acquire(ctx, "compute.instances.list"); err != nil {
+		t.Fatal(err)
+	}
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	called := false
+	err := l.Do(canceled, "storage.buckets.list", func() error {
+		called = true
+		return nil
+	})
+	if !errors.Is(err, context.Canceled) || called {
+		t.Errorf("got error %v and called %t, expected a canceled call", err, called)
+	}
+
+	// A nil limiter does not limit.
+	var none *Limiter
+	if err := none.Do(ctx, "compute.instances.list", func() error { return nil }); err != nil {
+		t.Errorf("nil limiter: %s", err.Error())
+	}
+}
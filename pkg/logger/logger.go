@@ -76,6 +76,12 @@ func NewLogger(name string, level string) *Logger {
 	return l
 }
 
+// ValidateLevel returns an error when level is not a log level or filter
+// expression NewLogger accepts.
+func ValidateLevel(level string) error {
+	return new(filterSpec).fromString(level, false, levelInfo)
+}
+
 func NewLoggerWithConfig(cfg *Config) *Logger {
 	l := newLogger()
 	err := l.init(cfg)
@@ -0,0 +1,142 @@
+package retention
+
+import (
+	"sort"
+	"time"
+)
+
+// Policy selects the runs whose objects to keep: the KeepLast newest runs, the
+// newest run of each of the last KeepDaily days and the newest run of each of
+// the last KeepMonthly months, all in UTC. A run kept by any rule is kept.
+// Runs made only of extra objects, such as failed runs, are counted apart, so
+// they never take the place of a run that saved an export. With DryRun set
+// the objects to prune are only reported.
+type Policy struct {
+	KeepLast    int
+	KeepDaily   int
+	KeepMonthly int
+	DryRun      bool
+}
+
+// Enabled reports whether any rule is set. A policy without rules keeps
+// every object.
+func (p *Policy) Enabled() bool {
+	return p.KeepLast > 0 || p.KeepDaily > 0 || p.KeepMonthly > 0
+}
+
+type Object struct {
+	Name    string
+	Created time.Time
+	// Run is the run that saved the object. The objects of a run, such as
+	// its export, changes and manifest, are kept or pruned together. An
+	// object without a run is a run of its own.
+	Run string
+	// Extra marks an object that alone does not make its run count towards
+	// the rules, e.g. the manifest of a failed run.
+	Extra bool
+}
+
+// run is the group of objects saved by a run, created when its newest object
+// was.
+type run struct {
+	objects []*Object
+	created time.Time
+	extra   bool
+}
+
+// group returns the runs of the objects, newest first.
+func group(objects []*Object) []*run {
+	type key struct{ run, name string }
+	var runs []*run
+	byKey := map[key]*run{}
+	for _, o := range objects {
+		k := key{run: o.Run}
+		if o.Run == "" {
+			k.name = o.Name
+		}
+		r, ok := byKey[k]
+		if !ok {
+			r = &run{extra: true}
+			byKey[k] = r
+			runs = append(runs, r)
+		}
+		r.objects = append(r.objects, o)
+		r.extra = r.extra && o.Extra
+		if o.Created.After(r.created) {
+			r.created = o.Created
+		}
+	}
+	for _, r := range runs {
+		sort.SliceStable(r.objects, func(i, j int) bool {
+			return r.objects[i].Created.After(r.objects[j].Created)
+		})
+	}
+	sort.SliceStable(runs, func(i, j int) bool {
+		return runs[i].created.After(runs[j].created)
+	})
+	return runs
+}
+
+// Select splits objects into the ones of the runs the policy keeps and the
+// ones to prune, both newest run first.
+func (p *Policy) Select(objects []*Object, now time.Time) (keep, prune []*Object) {
+	runs := group(objects)
+	var counted, extras []*run
+	for _, r := range runs {
+		if r.extra {
+			extras = append(extras, r)
+		} else {
+			counted = append(counted, r)
+		}
+	}
+	kept := map[*run]bool{}
+	p.apply(counted, now, kept)
+	p.apply(extras, now, kept)
+	for _, r := range runs {
+		if kept[r] {
+			keep = append(keep, r.objects...)
+		} else {
+			prune = append(prune, r.objects...)
+		}
+	}
+	return keep, prune
+}
+
+// apply adds the runs the rules keep to kept, runs being newest first.
+func (p *Policy) apply(runs []*run, now time.Time, kept map[*run]bool) {
+	now = now.UTC()
+	today := day(now)
+	thisMonth := month(now)
+	days := map[time.Time]bool{}
+	months := map[time.Time]bool{}
+	for i, r := range runs {
+		created := r.created.UTC()
+		if !p.Enabled() || i < p.KeepLast {
+			kept[r] = true
+		}
+		if d := day(created); daysBetween(d, today) < p.KeepDaily && !days[d] {
+			days[d] = true
+			kept[r] = true
+		}
+		if m := month(created); monthsBetween(m, thisMonth) < p.KeepMonthly && !months[m] {
+			months[m] = true
+			kept[r] = true
+		}
+	}
+}
+
+func day(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+func month(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+func daysBetween(from, to time.Time) int {
+	return int(to.Sub(from).Hours() / 24)
+}
+
+func monthsBetween(from, to time.Time) int {
+	return (to.Year()-from.Year())*12 + int(to.Month()-from.Month())
+}
@@ -0,0 +1,161 @@
+package retention
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func at(value string) time.Time {
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func names(objects []*Object) []string {
+	names := []string{}
+	for _, o := range objects {
+		names = append(names, o.Name)
+	}
+	return names
+}
+
+func TestSelect(t *testing.T) {
+	now := at("2026-10-15T12:00:00Z")
+	tests := []struct {
+		name    string
+		policy  Policy
+		now     time.Time
+		objects []*Object
+		keep    []string
+		prune   []string
+	}{
+		{
+			name:   "no rules",
+			policy: Policy{},
+			objects: []*Object{
+				{Name: "a", Created: at("2026-10-15T10:00:00Z")},
+				{Name: "b", Created: at("2020-01-01T10:00:00Z")},
+			},
+			keep:  []string{"a", "b"},
+			prune: []string{},
+		},
+		{
+			name:   "keep last counts runs",
+			policy: Policy{KeepLast: 2},
+			objects: []*Object{
+				{Name: "export-1", Created: at("2026-10-15T09:00:00Z"), Run: "1"},
+				{Name: "manifest-1", Created: at("2026-10-15T09:00:01Z"), Run: "1"},
+				{Name: "export-2", Created: at("2026-10-15T10:00:00Z"), Run: "2"},
+				{Name: "changes-2", Created: at("2026-10-15T10:00:01Z"), Run: "2"},
+				{Name: "manifest-2", Created: at("2026-10-15T10:00:02Z"), Run: "2"},
+				{Name: "export-3", Created: at("2026-10-15T11:00:00Z"), Run: "3"},
+				{Name: "manifest-3", Created: at("2026-10-15T11:00:01Z"), Run: "3"},
+			},
+			keep:  []string{"manifest-3", "export-3", "manifest-2", "changes-2", "export-2"},
+			prune: []string{"manifest-1", "export-1"},
+		},
+		{
+			name:   "keep daily keeps the newest run of each day",
+			policy: Policy{KeepDaily: 2},
+			objects: []*Object{
+				{Name: "13th", Created: at("2026-10-13T23:00:00Z")},
+				{Name: "14th-early", Created: at("2026-10-14T01:00:00Z")},
+				{Name: "14th-late", Created: at("2026-10-14T22:00:00Z")},
+				{Name: "15th", Created: at("2026-10-15T08:00:00Z")},
+			},
+			keep:  []string{"15th", "14th-late"},
+			prune: []string{"14th-early", "13th"},
+		},
+		{
+			name:   "keep daily uses UTC days",
+			policy: Policy{KeepDaily: 2},
+			now:    at("2026-10-15T01:00:00+03:00"),
+			objects: []*Object{
+				// Both are on the 14th in UTC, though late is on the 15th
+				// in its zone.
+				{Name: "late", Created: at("2026-10-15T00:30:00+03:00")},
+				{Name: "early", Created: at("2026-10-14T20:00:00Z")},
+			},
+			keep:  []string{"late"},
+			prune: []string{"early"},
+		},
+		{
+			name:   "keep monthly keeps the newest run of each month",
+			policy: Policy{KeepMonthly: 2},
+			objects: []*Object{
+				{Name: "august", Created: at("2026-08-31T10:00:00Z")},
+				{Name: "september", Created: at("2026-09-15T10:00:00Z")},
+				// On September 30 in UTC.
+				{Name: "september-end", Created: at("2026-10-01T00:30:00+02:00")},
+				{Name: "october", Created: at("2026-10-02T10:00:00Z")},
+			},
+			keep:  []string{"october", "september-end"},
+			prune: []string{"september", "august"},
+		},
+		{
+			name:   "rules combine",
+			policy: Policy{KeepLast: 1, KeepMonthly: 2},
+			objects: []*Object{
+				{Name: "september", Created: at("2026-09-15T10:00:00Z")},
+				{Name: "october-1", Created: at("2026-10-14T10:00:00Z")},
+				{Name: "october-2", Created: at("2026-10-15T10:00:00Z")},
+			},
+			keep:  []string{"october-2", "september"},
+			prune: []string{"october-1"},
+		},
+		{
+			name:   "failed run does not take the day of an export",
+			policy: Policy{KeepDaily: 1},
+			objects: []*Object{
+				{Name: "export-good", Created: at("2026-10-15T10:00:00Z"), Run: "good"},
+				{Name: "manifest-good", Created: at("2026-10-15T10:00:01Z"), Run: "good"},
+				{Name: "manifest-failed", Created: at("2026-10-15T11:00:00Z"), Run: "failed", Extra: true},
+				{Name: "export-old", Created: at("2026-10-14T10:00:00Z"), Run: "old"},
+			},
+			keep:  []string{"manifest-failed", "manifest-good", "export-good"},
+			prune: []string{"export-old"},
+		},
+		{
+			name:   "failed runs are counted apart",
+			policy: Policy{KeepLast: 2},
+			objects: []*Object{
+				{Name: "export-1", Created: at("2026-10-15T07:00:00Z"), Run: "1"},
+				{Name: "export-2", Created: at("2026-10-15T08:00:00Z"), Run: "2"},
+				{Name: "export-3", Created: at("2026-10-15T09:00:00Z"), Run: "3"},
+				{Name: "failed-1", Created: at("2026-10-15T09:30:00Z"), Run: "f1", Extra: true},
+				{Name: "failed-2", Created: at("2026-10-15T10:00:00Z"), Run: "f2", Extra: true},
+				{Name: "failed-3", Created: at("2026-10-15T11:00:00Z"), Run: "f3", Extra: true},
+			},
+			keep:  []string{"failed-3", "failed-2", "export-3", "export-2"},
+			prune: []string{"failed-1", "export-1"},
+		},
+		{
+			name:   "dry run selects the same objects",
+			policy: Policy{KeepLast: 1, DryRun: true},
+			objects: []*Object{
+				{Name: "a", Created: at("2026-10-15T10:00:00Z")},
+				{Name: "b", Created: at("2026-10-15T11:00:00Z")},
+			},
+			keep:  []string{"b"},
+			prune: []string{"a"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			testNow := now
+			if !test.now.IsZero() {
+				testNow = test.now
+			}
+			keep, prune := test.policy.Select(test.objects, testNow)
+			if !reflect.DeepEqual(names(keep), test.keep) {
+				t.Errorf("kept %v, expected %v", names(keep), test.keep)
+			}
+			if !reflect.DeepEqual(names(prune), test.prune) {
+				t.Errorf("pruned %v, expected %v", names(prune), test.prune)
+			}
+		})
+	}
+}
@@ -0,0 +1,179 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"github.com/liornabat/gcp_inventory_exporter/inventory"
+	"github.com/liornabat/gcp_inventory_exporter/pkg/logger"
+	"google.golang.org/api/googleapi"
+	"io"
+	"math"
+	"math/rand"
+	"net"
+	"sync"
+	"syscall"
+	"time"
+)
+
+type Policy struct {
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	Multiplier     float64
+	// Jitter is the fraction, between 0 and 1, by which each backoff is
+	// randomly shortened or extended.
+	Jitter float64
+}
+
+func DefaultPolicy() *Policy {
+	return &Policy{
+		MaxAttempts:    5,
+		InitialBackoff: time.Second,
+		MaxBackoff:     30 * time.Second,
+		Multiplier:     2,
+		Jitter:         0.2,
+	}
+}
+
+func (p *Policy) Backoff(attempt int) time.Duration {
+	backoff := float64(p.InitialBackoff) * math.Pow(p.Multiplier, float64(attempt-1))
+	if p.MaxBackoff > 0 && backoff > float64(p.MaxBackoff) {
+		backoff = float64(p.MaxBackoff)
+	}
+	if p.Jitter > 0 {
+		backoff += backoff * p.Jitter * (2*rand.Float64() - 1)
+	}
+	return time.Duration(backoff)
+}
+
+var retryableReasons = map[string]bool{
+	"rateLimitExceeded":     true,
+	"userRateLimitExceeded": true,
+	"backendError":          true,
+	"internalError":         true,
+}
+
+// retryableError marks an error the caller knows to be transient.
+type retryableError struct {
+	error
+}
+
+func (e *retryableError) Unwrap() error {
+	return e.error
+}
+
+// Retryable marks err as worth retrying, for transient failures that are not
+// reported as such by the API, e.g. a field not yet seen after a schema
+// update.
+func Retryable(err error) error {
+	return &retryableError{err}
+}
+
+// IsRetryable reports whether err is a quota or transient error worth
+// retrying: HTTP 429 and 5xx responses, rate limit reasons, network failures
+// and errors marked by Retryable.
+func IsRetryable(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var retryable *retryableError
+	if errors.As(err, &retryable) {
+		return true
+	}
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) {
+		if apiErr.Code == 429 || apiErr.Code >= 500 {
+			return true
+		}
+		for _, item := range apiErr.Errors {
+			if retryableReasons[item.Reason] {
+				return true
+			}
+		}
+		return false
+	}
+	if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	return false
+}
+
+// Retrier applies a Policy to API calls and counts the retries per method.
+type Retrier struct {
+	policy *Policy
+	log    *logger.Logger
+	mutex  sync.Mutex
+	counts map[string]int
+}
+
+func NewRetrier(policy *Policy, log *logger.Logger) *Retrier {
+	return &Retrier{
+		policy: policy,
+		log:    log,
+		counts: map[string]int{},
+	}
+}
+
+// Do calls fn until it succeeds, returns a non retryable error or the policy
+// runs out of attempts. A nil Retrier calls fn once.
+func (r *Retrier) Do(ctx context.Context, method string, fn func() error) error {
+	if r == nil {
+		return fn()
+	}
+	attempt := 1
+	for {
+		err := fn()
+		if err == nil || !IsRetryable(err) || attempt >= r.policy.MaxAttempts {
+			return err
+		}
+		wait := r.policy.Backoff(attempt)
+		r.log.Warnf("Retrying %s after attempt %d/%d in %s, error: %s", method, attempt, r.policy.MaxAttempts, wait, err.Error())
+		r.mutex.Lock()
+		r.counts[method]++
+		r.mutex.Unlock()
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+		attempt++
+	}
+}
+
+func (r *Retrier) Count() int {
+	if r == nil {
+		return 0
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	total := 0
+	for _, count := range r.counts {
+		total += count
+	}
+	return total
+}
+
+func (r *Retrier) Counts() map[string]int {
+	counts := map[string]int{}
+	if r == nil {
+		return counts
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for method, count := range r.counts {
+		counts[method] = count
+	}
+	return counts
+}
+
+// Summary returns a one line description of the retries, e.g.
+// "3 retries (compute.instances.list: 2, storage.buckets.list: 1)".
+func (r *Retrier) Summary() string {
+	return inventory.CountSummary(r.Counts(), "retry", "retries")
+}
@@ -0,0 +1,190 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/liornabat/gcp_inventory_exporter/pkg/logger"
+	"google.golang.org/api/googleapi"
+	"io"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// timeoutError is a net.Error that timed out.
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+var _ net.Error = timeoutError{}
+
+func TestIsRetryable(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"nil", nil, false},
+		{"plain error", errors.New("invalid argument"), false},
+		{"too many requests", &googleapi.Error{Code: 429}, true},
+		{"server error", &googleapi.Error{Code: 503}, true},
+		{"not found", &googleapi.Error{Code: 404}, false},
+		{"rate limit reason", &googleapi.Error{Code: 403, Errors: []googleapi.ErrorItem{{Reason: "rateLimitExceeded"}}}, true},
+		{"user rate limit reason", &googleapi.Error{Code: 403, Errors: []googleapi.ErrorItem{{Reason: "userRateLimitExceeded"}}}, true},
+		{"permission denied", &googleapi.Error{Code: 403, Errors: []googleapi.ErrorItem{{Reason: "forbidden"}}}, false},
+		{"wrapped api error", fmt.Errorf("list failed: %w", &googleapi.Error{Code: 500}), true},
+		{"unexpected EOF", io.ErrUnexpectedEOF, true},
+		{"connection reset", &net.OpError{Op: "read", Err: syscall.ECONNRESET}, true},
+		{"connection refused", &net.OpError{Op: "dial", Err: syscall.ECONNREFUSED}, true},
+		{"network timeout", timeoutError{}, true},
+		{"marked retryable", Retryable(errors.New("field not found")), true},
+		{"wrapped marked retryable", fmt.Errorf("insert failed: %w", Retryable(errors.New("not found"))), true},
+		{"canceled", context.Canceled, false},
+		{"deadline exceeded", fmt.Errorf("list failed: %w", context.DeadlineExceeded), false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := IsRetryable(test.err); got != test.want {
+				t.Errorf("IsRetryable(%v) = %t, expected %t", test.err, got, test.want)
+			}
+		})
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	policy := &Policy{InitialBackoff: time.Second, MaxBackoff: 5 * time.Second, Multiplier: 2}
+	for attempt, want := range map[int]time.Duration{
+		1:  time.Second,
+		2:  2 * time.Second,
+		3:  4 * time.Second,
+		4:  5 * time.Second,
+		10: 5 * time.Second,
+	} {
+		if got := policy.Backoff(attempt); got != want {
+			t.Errorf("attempt %d: got backoff %s, expected %s", attempt, got, want)
+		}
+	}
+
+	policy.Jitter = 0.2
+	for i := 0; i < 100; i++ {
+		if got := policy.Backoff(2); got < 1600*time.Millisecond || got > 2400*time.Millisecond {
+			t.Fatalf("got backoff %s with jitter, expected 2s ± 20%%", got)
+		}
+	}
+}
+
+func newTestRetrier(maxAttempts int) *Retrier {
+	return NewRetrier(&Policy{MaxAttempts: maxAttempts, InitialBackoff: time.Millisecond, Multiplier: 2}, logger.NewLogger("test", "error"))
+}
+
+func TestRetrierDo(t *testing.T) {
+	unavailable := &googleapi.Error{Code: 503}
+	tests := []struct {
+		name     string
+		errs     []error
+		attempts int
+		retries  int
+		wantErr  error
+	}{
+		{
+			name:     "success",
+			errs:     []error{nil},
+			attempts: 1,
+		},
+		{
+			name:     "retryable error then success",
+			errs:     []error{unavailable, unavailable, nil},
+			attempts: 3,
+			retries:  2,
+		},
+		{
+			name:     "non retryable error",
+			errs:     []error{&googleapi.Error{Code: 404}},
+			attempts: 1,
+			wantErr:  &googleapi.Error{Code: 404},
+		},
+		{
+			name:     "attempts run out",
+			errs:     []error{unavailable, unavailable, unavailable, nil},
+			attempts: 3,
+			retries:  2,
+			wantErr:  unavailable,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := newTestRetrier(3)
+			attempts := 0
+			err := r.Do(context.Background(), "compute.instances.list", func() error {
+				err := test.errs[attempts]
+				attempts++
+				return err
+			})
+			if fmt.Sprint(err) != fmt.Sprint(test.wantErr) {
+				t.Errorf("got error %v, expected %v", err, test.wantErr)
+			}
+			if attempts != test.attempts {
+				t.Errorf("got %d attempts, expected %d", attempts, test.attempts)
+			}
+			if count := r.Counts()["compute.instances.list"]; count != test.retries {
+				t.Errorf("got %d retries, expected %d", count, test.retries)
+			}
+		})
+	}
+}
+
+func TestRetrierDoCanceled(t *testing.T) {
+	r := NewRetrier(&Policy{MaxAttempts: 5, InitialBackoff: time.Hour, Multiplier: 2}, logger.NewLogger("test", "error"))
+	ctx, cancel := context.WithCancel(context.Background())
+	attempts := 0
+	done := make(chan error)
+	go func() {
+		done <- r.Do(ctx, "storage.objects.list", func() error {
+			attempts++
+			return &googleapi.Error{Code: 429}
+		})
+	}()
+	cancel()
+	select {
+	case err := <-done:
+		var apiErr *googleapi.Error
+		if !errors.As(err, &apiErr) || apiErr.Code != 429 {
+			t.Errorf("got error %v, expected the last attempt error", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("Do kept waiting after the context was canceled")
+	}
+	if attempts != 1 {
+		t.Errorf("got %d attempts, expected 1", attempts)
+	}
+}
+
+func TestRetrierCounts(t *testing.T) {
+	r := newTestRetrier(2)
+	fail := func() error { return &googleapi.Error{Code: 500} }
+	r.Do(context.Background(), "compute.instances.list", fail)
+	r.Do(context.Background(), "compute.instances.list", fail)
+	r.Do(context.Background(), "storage.buckets.list", fail)
+	if r.Count() != 3 {
+		t.Errorf("got %d retries, expected 3", r.Count())
+	}
+	want := "3 retries (compute.instances.list: 2, storage.buckets.list: 1)"
+	if r.Summary() != want {
+		t.Errorf("got summary %q, expected %q", r.Summary(), want)
+	}
+
+	// A nil retrier calls fn once and counts nothing.
+	var none *Retrier
+	attempts := 0
+	none.Do(context.Background(), "compute.instances.list", func() error {
+		attempts++
+		return fail()
+	})
+	if attempts != 1 || none.Count() != 0 || len(none.Counts()) != 0 {
+		t.Errorf("nil retrier made %d attempts and counted %d retries", attempts, none.Count())
+	}
+}
@@ -1,9 +1,8 @@
 package xls
 
 import (
-	"fmt"
-	excelize2 "github.com/360EntSecGroup-Skylar/excelize"
 	"github.com/xuri/excelize/v2"
+	"io"
 )
 
 type Xls struct {
@@ -16,8 +15,19 @@ func NewXls() *Xls {
 	}
 }
 
+// Open reads a workbook, e.g. a previous export.
+func Open(r io.Reader) (*Xls, error) {
+	file, err := excelize.OpenReader(r)
+	if err != nil {
+		return nil, err
+	}
+	return &Xls{
+		file: file,
+	}, nil
+}
+
 func (x *Xls) Close() error {
-	return x.Close()
+	return x.file.Close()
 }
 
 func (x *Xls) NewSheet(name string) error {
@@ -28,28 +38,49 @@ func (x *Xls) NewSheet(name string) error {
 	return nil
 }
 
+// SetDataToSheet adds a sheet with the rows of data. The rows are streamed
+// row by row, so large sheets are spilled to a temporary file instead of
+// being held in memory.
 func (x *Xls) SetDataToSheet(sheet string, data [][]string) error {
 	if err := x.NewSheet(sheet); err != nil {
 		return err
 	}
+	stream, err := x.file.NewStreamWriter(sheet)
+	if err != nil {
+		return err
+	}
 	for i, row := range data {
+		cellAxis, err := excelize.CoordinatesToCellName(1, i+1)
+		if err != nil {
+			return err
+		}
+		values := make([]interface{}, len(row))
 		for j, cell := range row {
-			cellAxis := fmt.Sprintf("%s%d", excelize2.ToAlphaString(j), i+1)
-			if err := x.file.SetCellValue(sheet, cellAxis, cell); err != nil {
-				return err
-			}
+			values[j] = cell
+		}
+		if err := stream.SetRow(cellAxis, values); err != nil {
+			return err
 		}
 	}
-	return nil
+	return stream.Flush()
+}
+
+func (x *Xls) Sheets() []string {
+	return x.file.GetSheetList()
+}
+
+// GetDataFromSheet returns the rows of a sheet. Trailing empty cells of a
+// row are left out.
+func (x *Xls) GetDataFromSheet(sheet string) ([][]string, error) {
+	return x.file.GetRows(sheet)
 }
 
 func (x *Xls) DeleteSheet(sheet string) error {
 	return x.file.DeleteSheet(sheet)
 }
-func (x *Xls) GetBytes() ([]byte, error) {
-	buffer, err := x.file.WriteToBuffer()
-	if err != nil {
-		return nil, err
-	}
-	return buffer.Bytes(), nil
+
+// Write writes the workbook to w as it is zipped, e.g. straight into a
+// storage object writer.
+func (x *Xls) Write(w io.Writer) error {
+	return x.file.Write(w)
 }
@@ -0,0 +1,181 @@
+package project
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// ActiveState is the lifecycle state of a project that is not being deleted.
+const ActiveState = "ACTIVE"
+
+type labelSelector struct {
+	key    string
+	value  string
+	negate bool
+	exists bool
+}
+
+// parseLabelSelector parses "key=value", "key!=value", "key" (the label is
+// set) or "!key" (the label is not set).
+func parseLabelSelector(selector string) (*labelSelector, error) {
+	s := &labelSelector{}
+	switch {
+	case strings.Contains(selector, "!="):
+		parts := strings.SplitN(selector, "!=", 2)
+		s.key, s.value, s.negate = parts[0], parts[1], true
+	case strings.Contains(selector, "="):
+		parts := strings.SplitN(selector, "=", 2)
+		s.key, s.value = parts[0], parts[1]
+	case strings.HasPrefix(selector, "!"):
+		s.key, s.exists, s.negate = strings.TrimPrefix(selector, "!"), true, true
+	default:
+		s.key, s.exists = selector, true
+	}
+	if s.key == "" {
+		return nil, fmt.Errorf("invalid label selector %q", selector)
+	}
+	return s, nil
+}
+
+func (s *labelSelector) match(labels map[string]string) bool {
+	value, ok := labels[s.key]
+	if s.exists {
+		return ok != s.negate
+	}
+	return (ok && value == s.value) != s.negate
+}
+
+func (s *labelSelector) String() string {
+	switch {
+	case s.exists && s.negate:
+		return "!" + s.key
+	case s.exists:
+		return s.key
+	case s.negate:
+		return s.key + "!=" + s.value
+	default:
+		return s.key + "=" + s.value
+	}
+}
+
+// Filter selects projects by ID or name, labels, folder and lifecycle state.
+type Filter struct {
+	include []*regexp.Regexp
+	exclude []*regexp.Regexp
+	labels  []*labelSelector
+	folders map[string]bool
+	states  map[string]bool
+}
+
+// NewFilter compiles the filter rules:
+//   - include: regular expressions, a project must match one by ID or name
+//   - exclude: regular expressions, a project matching one by ID or name is skipped
+//   - labels: label selectors that a project must all match
+//   - folders: folder IDs, a project must be under one of them at any depth
+//   - states: lifecycle states to keep, ACTIVE when empty
+func NewFilter(include, exclude, labels, folders, states []string) (*Filter, error) {
+	f := &Filter{
+		folders: map[string]bool{},
+		states:  map[string]bool{},
+	}
+	for _, pattern := range include {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid include pattern %q: %s", pattern, err.Error())
+		}
+		f.include = append(f.include, re)
+	}
+	for _, pattern := range exclude {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid exclude pattern %q: %s", pattern, err.Error())
+		}
+		f.exclude = append(f.exclude, re)
+	}
+	for _, selector := range labels {
+		s, err := parseLabelSelector(selector)
+		if err != nil {
+			return nil, err
+		}
+		f.labels = append(f.labels, s)
+	}
+	for _, folder := range folders {
+		f.folders[strings.TrimPrefix(folder, "folders/")] = true
+	}
+	if len(states) == 0 {
+		states = []string{ActiveState}
+	}
+	for _, state := range states {
+		f.states[state] = true
+	}
+	return f, nil
+}
+
+func matchProject(patterns []*regexp.Regexp, p *Project) *regexp.Regexp {
+	for _, re := range patterns {
+		if re.MatchString(p.ID) || re.MatchString(p.Name) {
+			return re
+		}
+	}
+	return nil
+}
+
+// Match reports whether the project passes the filter, and the reason when
+// it does not.
+func (f *Filter) Match(p *Project) (bool, string) {
+	if !f.states[p.LifecycleState] {
+		return false, fmt.Sprintf("lifecycle state is %s", p.LifecycleState)
+	}
+	if len(f.include) > 0 && matchProject(f.include, p) == nil {
+		return false, "not matched by an include pattern"
+	}
+	if re := matchProject(f.exclude, p); re != nil {
+		return false, fmt.Sprintf("matched by exclude pattern %s", re.String())
+	}
+	for _, s := range f.labels {
+		if !s.match(p.Labels) {
+			return false, fmt.Sprintf("labels do not match %s", s.String())
+		}
+	}
+	if len(f.folders) > 0 && !f.inFolders(p) {
+		return false, "not in the selected folders"
+	}
+	return true, ""
+}
+
+func (f *Filter) inFolders(p *Project) bool {
+	for _, id := range p.FolderIDs {
+		if f.folders[id] {
+			return true
+		}
+	}
+	return false
+}
+
+// Skipped is a project left out of the export by a filter.
+type Skipped struct {
+	Project *Project
+	Reason  string
+}
+
+// Apply keeps the projects that pass all the filters and returns the others
+// with the reason of the first filter that skipped them.
+func Apply(projects []*Project, filters []*Filter) ([]*Project, []*Skipped) {
+	var kept []*Project
+	var skipped []*Skipped
+	for _, p := range projects {
+		ok, reason := true, ""
+		for _, f := range filters {
+			if ok, reason = f.Match(p); !ok {
+				break
+			}
+		}
+		if ok {
+			kept = append(kept, p)
+		} else {
+			skipped = append(skipped, &Skipped{Project: p, Reason: reason})
+		}
+	}
+	return kept, skipped
+}
@@ -0,0 +1,150 @@
+package project
+
+import (
+	"reflect"
+	"testing"
+)
+
+type filterRules struct {
+	include, exclude, labels, folders, states []string
+}
+
+func TestApply(t *testing.T) {
+	projects := []*Project{
+		{ID: "demo-project", Name: "Demo", LifecycleState: ActiveState, Labels: map[string]string{"env": "dev", "team": "infra"}, FolderIDs: []string{"222", "333"}},
+		{ID: "prod-api", Name: "API", LifecycleState: ActiveState, Labels: map[string]string{"env": "prod"}, FolderIDs: []string{"222"}},
+		{ID: "sys-12345", Name: "Apps Script", LifecycleState: ActiveState},
+		{ID: "old-project", Name: "Old", LifecycleState: "DELETE_REQUESTED", Labels: map[string]string{"env": "dev"}},
+	}
+	tests := []struct {
+		name    string
+		rules   []filterRules
+		kept    []string
+		skipped map[string]string
+	}{
+		{
+			name:  "active projects by default",
+			rules: []filterRules{{}},
+			kept:  []string{"demo-project", "prod-api", "sys-12345"},
+			skipped: map[string]string{
+				"old-project": "lifecycle state is DELETE_REQUESTED",
+			},
+		},
+		{
+			name:  "listed states",
+			rules: []filterRules{{states: []string{"ACTIVE", "DELETE_REQUESTED"}}},
+			kept:  []string{"demo-project", "prod-api", "sys-12345", "old-project"},
+		},
+		{
+			name:  "include and exclude by ID or name",
+			rules: []filterRules{{include: []string{"-", "^API$"}, exclude: []string{"^sys-"}}},
+			kept:  []string{"demo-project", "prod-api"},
+			skipped: map[string]string{
+				"sys-12345":   "matched by exclude pattern ^sys-",
+				"old-project": "lifecycle state is DELETE_REQUESTED",
+			},
+		},
+		{
+			name:  "include by name",
+			rules: []filterRules{{include: []string{"^Demo$"}}},
+			kept:  []string{"demo-project"},
+			skipped: map[string]string{
+				"prod-api":    "not matched by an include pattern",
+				"sys-12345":   "not matched by an include pattern",
+				"old-project": "lifecycle state is DELETE_REQUESTED",
+			},
+		},
+		{
+			name:  "label selectors",
+			rules: []filterRules{{labels: []string{"env", "env!=prod"}, states: []string{"ACTIVE", "DELETE_REQUESTED"}}},
+			kept:  []string{"demo-project", "old-project"},
+			skipped: map[string]string{
+				"prod-api":  "labels do not match env!=prod",
+				"sys-12345": "labels do not match env",
+			},
+		},
+		{
+			name:  "label absent and value selectors",
+			rules: []filterRules{{labels: []string{"!team", "env=prod"}}},
+			kept:  []string{"prod-api"},
+			skipped: map[string]string{
+				"demo-project": "labels do not match !team",
+				"sys-12345":    "labels do not match env=prod",
+				"old-project":  "lifecycle state is DELETE_REQUESTED",
+			},
+		},
+		{
+			name:  "folders at any depth",
+			rules: []filterRules{{folders: []string{"folders/333"}}},
+			kept:  []string{"demo-project"},
+			skipped: map[string]string{
+				"prod-api":    "not in the selected folders",
+				"sys-12345":   "not in the selected folders",
+				"old-project": "lifecycle state is DELETE_REQUESTED",
+			},
+		},
+		{
+			name:  "all filters must pass",
+			rules: []filterRules{{folders: []string{"222"}}, {exclude: []string{"^prod-"}}},
+			kept:  []string{"demo-project"},
+			skipped: map[string]string{
+				"prod-api":    "matched by exclude pattern ^prod-",
+				"sys-12345":   "not in the selected folders",
+				"old-project": "lifecycle state is DELETE_REQUESTED",
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var filters []*Filter
+			for _, r := range test.rules {
+				f, err := NewFilter(r.include, r.exclude, r.labels, r.folders, r.states)
+				if err != nil {
+					t.Fatal(err)
+				}
+				filters = append(filters, f)
+			}
+			kept, skipped := Apply(projects, filters)
+			var keptIDs []string
+			for _, p := range kept {
+				keptIDs = append(keptIDs, p.ID)
+			}
+			if !reflect.DeepEqual(keptIDs, test.kept) {
+				t.Errorf("kept %v, expected %v", keptIDs, test.kept)
+			}
+			reasons := map[string]string{}
+			for _, s := range skipped {
+				reasons[s.Project.ID] = s.Reason
+			}
+			if len(reasons) > 0 || len(test.skipped) > 0 {
+				if !reflect.DeepEqual(reasons, test.skipped) {
+					t.Errorf("skipped %v, expected %v", reasons, test.skipped)
+				}
+			}
+		})
+	}
+}
+
+func TestNewFilterInvalid(t *testing.T) {
+	for _, r := range []filterRules{
+		{include: []string{"("}},
+		{exclude: []string{"[a-"}},
+		{labels: []string{"=dev"}},
+		{labels: []string{"!"}},
+	} {
+		if _, err := NewFilter(r.include, r.exclude, r.labels, r.folders, r.states); err == nil {
+			t.Errorf("rules %+v were accepted", r)
+		}
+	}
+}
+
+func TestFilterByID(t *testing.T) {
+	projects := []*Project{{ID: "a"}, {ID: "b"}, {ID: "c"}}
+	if got := FilterByID(projects, nil); !reflect.DeepEqual(got, projects) {
+		t.Errorf("no IDs filtered the projects to %v", got)
+	}
+	got := FilterByID(projects, []string{"c", "a", "unknown"})
+	if len(got) != 2 || got[0].ID != "a" || got[1].ID != "c" {
+		t.Errorf("got %v, expected projects a and c", got)
+	}
+}
@@ -2,39 +2,191 @@ package project
 
 import (
 	"context"
+	"fmt"
+	"github.com/liornabat/gcp_inventory_exporter/inventory"
 	"github.com/liornabat/gcp_inventory_exporter/pkg/logger"
-	"google.golang.org/api/cloudresourcemanager/v1"
+	"github.com/liornabat/gcp_inventory_exporter/pkg/retry"
+	"google.golang.org/api/cloudresourcemanager/v3"
+	"google.golang.org/api/option"
+	"sort"
+	"strings"
 )
 
+const (
+	ProjectKind   = "cloudresourcemanager#project"
+	ProjectsSheet = "Projects"
+	CollectorName = "projects"
+)
+
+var ProjectSchema = &inventory.Schema{
+	Kind: ProjectKind,
+	Key:  []string{"Project ID"},
+	Columns: []inventory.Column{
+		{Name: "Name", Type: inventory.StringColumn},
+		{Name: "Project ID", Type: inventory.StringColumn},
+		{Name: "Project Number", Type: inventory.StringColumn},
+		{Name: "Parent", Type: inventory.StringColumn},
+		{Name: "Folder Path", Type: inventory.StringColumn},
+		{Name: "Lifecycle State", Type: inventory.StringColumn},
+		{Name: "Labels", Type: inventory.StringListColumn, Separator: ", "},
+		{Name: "Creation Time", Type: inventory.TimestampColumn},
+	},
+}
+
 type Project struct {
-	ID   string
-	Name string
+	ID     string
+	Name   string
+	Number string
+	// Parent is the resource name of the folder or organization that
+	// directly contains the project, e.g. "folders/123".
+	Parent string
+	// FolderPath holds the display names of the folders from the
+	// organization down to the project, and FolderIDs their IDs.
+	FolderPath     []string
+	FolderIDs      []string
+	Labels         map[string]string
+	LifecycleState string
+	CreateTime     string
+}
+
+// FilterByID keeps the projects whose ID is in ids. An empty ids keeps all
+// projects.
+func FilterByID(projects []*Project, ids []string) []*Project {
+	if len(ids) == 0 {
+		return projects
+	}
+	wanted := map[string]bool{}
+	for _, id := range ids {
+		wanted[id] = true
+	}
+	var filtered []*Project
+	for _, p := range projects {
+		if wanted[p.ID] {
+			filtered = append(filtered, p)
+		}
+	}
+	return filtered
+}
+
+func (p *Project) Resource() *inventory.Resource {
+	var labels []string
+	for key, value := range p.Labels {
+		labels = append(labels, key+"="+value)
+	}
+	sort.Strings(labels)
+	return inventory.NewResource(ProjectKind, p.ID, "", p.ID).
+		Set("Name", p.Name).
+		Set("Project ID", p.ID).
+		Set("Project Number", p.Number).
+		Set("Parent", p.Parent).
+		Set("Folder Path", strings.Join(p.FolderPath, "/")).
+		Set("Lifecycle State", p.LifecycleState).
+		Set("Labels", labels).
+		Set("Creation Time", inventory.ParseTimestamp(p.CreateTime)).
+		SetLabels(p.Labels).
+		SetRaw(p)
+}
+
+// Resources renders the projects as rows of the Projects sheet.
+func Resources(projects []*Project) []*inventory.Resource {
+	var resources []*inventory.Resource
+	for _, p := range projects {
+		resources = append(resources, p.Resource())
+	}
+	return resources
 }
 
-func GetProjects(ctx context.Context, log *logger.Logger) ([]*Project, error) {
-	log.Infof("Getting projects list")
+type walker struct {
+	service  *cloudresourcemanager.Service
+	log      *logger.Logger
+	retrier  *retry.Retrier
+	errors   *inventory.Errors
+	root     string
+	projects []*Project
+}
+
+// GetProjects returns the projects of the organization, walking its folder
+// tree depth first. A folder that cannot be listed is recorded in errors and
+// skipped; only a failure to list the organization itself is returned.
+func GetProjects(ctx context.Context, log *logger.Logger, orgId string, retrier *retry.Retrier, errors *inventory.Errors, clientOptions ...option.ClientOption) ([]*Project, error) {
+	log.Infof("Getting projects list for organization %s", orgId)
 	defer log.Infof("Done getting projects list")
-	service, err := cloudresourcemanager.NewService(ctx)
+	service, err := cloudresourcemanager.NewService(ctx, clientOptions...)
 	if err != nil {
 		return nil, err
 	}
+	w := &walker{
+		service: service,
+		log:     log,
+		retrier: retrier,
+		errors:  errors,
+		root:    "organizations/" + orgId,
+	}
+	if err := w.walk(ctx, w.root, nil, nil); err != nil {
+		return nil, err
+	}
+	log.Infof("Found %d projects", len(w.projects))
+	return w.projects, nil
+}
 
-	req := service.Projects.List()
+func (w *walker) walk(ctx context.Context, parent string, folderPath, folderIDs []string) error {
 	var projects []*Project
-
-	if err := req.Pages(ctx, func(page *cloudresourcemanager.ListProjectsResponse) error {
-		for _, project := range page.Projects {
-			log.Infof("Found project %s", project.Name)
-			p := &Project{
-				Name: project.Name,
-				ID:   project.ProjectId,
+	req := w.service.Projects.List().Parent(parent).ShowDeleted(true)
+	if err := w.retrier.Do(ctx, "cloudresourcemanager.projects.list", func() error {
+		projects = nil
+		return req.Pages(ctx, func(page *cloudresourcemanager.ListProjectsResponse) error {
+			for _, project := range page.Projects {
+				w.log.Infof("Found project %s", project.DisplayName)
+				projects = append(projects, &Project{
+					ID:             project.ProjectId,
+					Name:           project.DisplayName,
+					Number:         strings.TrimPrefix(project.Name, "projects/"),
+					Parent:         project.Parent,
+					FolderPath:     folderPath,
+					FolderIDs:      folderIDs,
+					Labels:         project.Labels,
+					LifecycleState: project.State,
+					CreateTime:     project.CreateTime,
+				})
 			}
-			projects = append(projects, p)
+			return nil
+		})
+	}); err != nil {
+		if err := w.fail(parent, "cloudresourcemanager.projects.list", err); err != nil {
+			return err
 		}
-		return nil
+	}
+	w.projects = append(w.projects, projects...)
+
+	var folders []*cloudresourcemanager.Folder
+	folderReq := w.service.Folders.List().Parent(parent)
+	if err := w.retrier.Do(ctx, "cloudresourcemanager.folders.list", func() error {
+		folders = nil
+		return folderReq.Pages(ctx, func(page *cloudresourcemanager.ListFoldersResponse) error {
+			folders = append(folders, page.Folders...)
+			return nil
+		})
 	}); err != nil {
-		return nil, err
+		return w.fail(parent, "cloudresourcemanager.folders.list", err)
+	}
+	for _, folder := range folders {
+		path := append(append([]string{}, folderPath...), folder.DisplayName)
+		ids := append(append([]string{}, folderIDs...), strings.TrimPrefix(folder.Name, "folders/"))
+		if err := w.walk(ctx, folder.Name, path, ids); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fail returns the error of a list call on the organization. Failures on a
+// folder are recorded in the run errors instead, so the rest of the tree is
+// still walked.
+func (w *walker) fail(parent, api string, err error) error {
+	if parent == w.root {
+		return fmt.Errorf("%s failed for %s: %s", api, parent, err.Error())
 	}
-	log.Infof("Found %d projects", len(projects))
-	return projects, nil
+	w.log.Errorf("Failed to list %s, skipping it: %s", parent, err.Error())
+	w.errors.Add(inventory.NewError(CollectorName, "", parent, api, err))
+	return nil
 }
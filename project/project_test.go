@@ -0,0 +1,116 @@
+package project
+
+import (
+	"context"
+	"github.com/liornabat/gcp_inventory_exporter/inventory"
+	"github.com/liornabat/gcp_inventory_exporter/pkg/fakegcp"
+	"github.com/liornabat/gcp_inventory_exporter/pkg/gcpclient"
+	"github.com/liornabat/gcp_inventory_exporter/pkg/logger"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+const crm = "cloudresourcemanager/v3/"
+
+// The organization lists its projects over two pages and has two folders:
+// Engineering, with Platform under it, and Sandbox, whose projects cannot be
+// listed.
+var fixtures = fstest.MapFS{
+	crm + "organizations/111111111111/projects.json": {Data: []byte(`{
+  "projects": [
+    {"name": "projects/100", "parent": "organizations/111111111111", "projectId": "org-project", "displayName": "Org Project", "state": "ACTIVE"}
+  ],
+  "nextPageToken": "page-2"
+}`)},
+	crm + "organizations/111111111111/projects@page-2.json": {Data: []byte(`{
+  "projects": [
+    {"name": "projects/101", "parent": "organizations/111111111111", "projectId": "old-project", "displayName": "Old Project", "state": "DELETE_REQUESTED"}
+  ]
+}`)},
+	crm + "organizations/111111111111/folders.json": {Data: []byte(`{
+  "folders": [
+    {"name": "folders/222", "parent": "organizations/111111111111", "displayName": "Engineering"},
+    {"name": "folders/444", "parent": "organizations/111111111111", "displayName": "Sandbox"}
+  ]
+}`)},
+	crm + "folders/222/projects.json": {Data: []byte(`{}`)},
+	crm + "folders/222/folders.json": {Data: []byte(`{
+  "folders": [
+    {"name": "folders/333", "parent": "folders/222", "displayName": "Platform"}
+  ]
+}`)},
+	crm + "folders/333/projects.json": {Data: []byte(`{
+  "projects": [
+    {"name": "projects/200", "parent": "folders/333", "projectId": "demo-project", "displayName": "Demo Project", "state": "ACTIVE", "labels": {"env": "dev"}, "createTime": "2022-01-10T08:00:00.000Z"}
+  ]
+}`)},
+	crm + "folders/333/folders.json": {Data: []byte(`{}`)},
+	crm + "folders/444/folders.json": {Data: []byte(`{}`)},
+}
+
+func getProjects(t *testing.T, fixtures fstest.MapFS) ([]*Project, *inventory.Errors, error) {
+	t.Helper()
+	srv := fakegcp.NewServer(fixtures)
+	endpoint := srv.Start()
+	t.Cleanup(srv.Close)
+	errors := inventory.NewErrors()
+	projects, err := GetProjects(context.Background(), logger.NewLogger("test", "error"), "111111111111", nil, errors,
+		gcpclient.NewOptions(endpoint).For(gcpclient.ResourceManager)...)
+	return projects, errors, err
+}
+
+func TestGetProjects(t *testing.T) {
+	projects, errors, err := getProjects(t, fixtures)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []*Project{
+		{ID: "org-project", Name: "Org Project", Number: "100", Parent: "organizations/111111111111", LifecycleState: "ACTIVE"},
+		{ID: "old-project", Name: "Old Project", Number: "101", Parent: "organizations/111111111111", LifecycleState: "DELETE_REQUESTED"},
+		{
+			ID:             "demo-project",
+			Name:           "Demo Project",
+			Number:         "200",
+			Parent:         "folders/333",
+			FolderPath:     []string{"Engineering", "Platform"},
+			FolderIDs:      []string{"222", "333"},
+			Labels:         map[string]string{"env": "dev"},
+			LifecycleState: "ACTIVE",
+			CreateTime:     "2022-01-10T08:00:00.000Z",
+		},
+	}
+	if !reflect.DeepEqual(projects, want) {
+		for _, p := range projects {
+			t.Logf("got %+v", *p)
+		}
+		t.Errorf("projects do not match")
+	}
+	// The folder that cannot be listed is recorded, the rest of the tree is
+	// still walked.
+	list := errors.List()
+	if len(list) != 1 {
+		t.Fatalf("got errors %s, expected 1", errors.Summary())
+	}
+	if e := list[0]; e.Collector != CollectorName || e.Location != "folders/444" || e.API != "cloudresourcemanager.projects.list" || e.HTTPStatus != http.StatusNotFound {
+		t.Errorf("got error %+v", *e)
+	}
+}
+
+func TestGetProjectsOrganizationError(t *testing.T) {
+	failing := fstest.MapFS{}
+	for name, file := range fixtures {
+		if !strings.HasPrefix(name, crm+"organizations/111111111111/folders") {
+			failing[name] = file
+		}
+	}
+	projects, errors, err := getProjects(t, failing)
+	if err == nil || !strings.Contains(err.Error(), "cloudresourcemanager.folders.list failed for organizations/111111111111") {
+		t.Errorf("got error %v, expected the organization folders list to fail the walk", err)
+	}
+	if projects != nil || errors.Len() > 0 {
+		t.Errorf("got %d projects and errors %s after a failed walk", len(projects), errors.Summary())
+	}
+}
@@ -0,0 +1,82 @@
+package gcp_inventory_exporter
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/liornabat/gcp_inventory_exporter/exporter"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// parseExportOptions reads the export options from a JSON request body and
+// from the query parameters, which take precedence over the body.
+func parseExportOptions(r *http.Request) (*exporter.Options, error) {
+	opts := &exporter.Options{}
+	if r.Body != nil {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			return nil, err
+		}
+		if err := decodeExportOptions(body, opts); err != nil {
+			return nil, err
+		}
+	}
+	if err := setExportParams(r.URL.Query().Get, opts); err != nil {
+		return nil, err
+	}
+	return opts, nil
+}
+
+// decodeExportOptions decodes JSON export options, rejecting unknown fields.
+// An empty body leaves opts unchanged.
+func decodeExportOptions(body []byte, opts *exporter.Options) error {
+	if len(bytes.TrimSpace(body)) == 0 {
+		return nil
+	}
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(opts); err != nil {
+		return fmt.Errorf("invalid export options: %s", err.Error())
+	}
+	return nil
+}
+
+// setExportParams overrides opts with the string parameters returned by get,
+// such as query parameters or Pub/Sub message attributes.
+func setExportParams(get func(key string) string, opts *exporter.Options) error {
+	setListFromQuery(get("collectors"), &opts.Collectors)
+	setListFromQuery(get("projects"), &opts.Projects)
+	setListFromQuery(get("regions"), &opts.Regions)
+	setListFromQuery(get("zones"), &opts.Zones)
+	if value := get("format"); value != "" {
+		opts.Format = value
+	}
+	if value := get("objectName"); value != "" {
+		opts.ObjectName = value
+	}
+	if value := get("async"); value != "" {
+		async, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("invalid async %q", value)
+		}
+		opts.Async = async
+	}
+	if value := get("diff"); value != "" {
+		diff, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("invalid diff %q", value)
+		}
+		opts.Diff = diff
+	}
+	return nil
+}
+
+func setListFromQuery(value string, list *[]string) {
+	if value == "" {
+		return
+	}
+	*list = strings.Split(value, ",")
+}
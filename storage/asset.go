@@ -0,0 +1,41 @@
+package storage
+
+import (
+	"cloud.google.com/go/storage"
+	"context"
+	"encoding/json"
+	"github.com/liornabat/gcp_inventory_exporter/collector"
+	"github.com/liornabat/gcp_inventory_exporter/inventory"
+	"time"
+)
+
+const BucketAssetType = "storage.googleapis.com/Bucket"
+
+// bucketAsset is the part of the storage#bucket JSON resource of a bucket
+// asset that the inventory uses.
+type bucketAsset struct {
+	Name         string            `json:"name"`
+	Location     string            `json:"location"`
+	StorageClass string            `json:"storageClass"`
+	TimeCreated  time.Time         `json:"timeCreated"`
+	Labels       map[string]string `json:"labels"`
+}
+
+func GetStorageAssetInventory(ctx context.Context, assets []*collector.Asset, opts *collector.Options) ([]*inventory.Resource, error) {
+	var resources []*inventory.Resource
+	for _, asset := range assets {
+		bucket := &bucketAsset{}
+		if err := json.Unmarshal(asset.Data, bucket); err != nil {
+			opts.Errors.Add(inventory.NewError(BucketCollectorName, asset.Project.ID, asset.Location, "cloudasset.assets.list", err))
+			continue
+		}
+		resources = append(resources, newBucketResource(asset.Project, &storage.BucketAttrs{
+			Name:         bucket.Name,
+			Location:     bucket.Location,
+			StorageClass: bucket.StorageClass,
+			Created:      bucket.TimeCreated,
+			Labels:       bucket.Labels,
+		}))
+	}
+	return resources, nil
+}
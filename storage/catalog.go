@@ -0,0 +1,221 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/liornabat/gcp_inventory_exporter/inventory"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	SnapshotSucceeded = "succeeded"
+	SnapshotFailed    = "failed"
+
+	// CatalogPrefix holds a manifest per run and the latest pointer.
+	CatalogPrefix = "snapshots/"
+	// LatestObjectName holds the manifest of the last successful run.
+	LatestObjectName = CatalogPrefix + "latest.json"
+)
+
+// runIDExpr matches a run id. The random suffix is optional, as runs saved
+// before it was added do not have it.
+const runIDExpr = `[0-9]{4}-[0-9]{2}-[0-9]{2}-[0-9]{2}-[0-9]{2}-[0-9]{2}(?:-[0-9a-f]{8})?`
+
+var (
+	runIDPattern = regexp.MustCompile(`^` + runIDExpr + `$`)
+	// objectRunIDPattern finds the run id at the end of an object name,
+	// before the extension.
+	objectRunIDPattern = regexp.MustCompile(`(?:^|[-/])(` + runIDExpr + `)\.[^/]+$`)
+)
+
+// RunIDOf returns the id of the run that saved an object, e.g. of the export
+// inventory-<run id>.xlsx or of the manifest snapshots/<run id>.json, or ""
+// for an object not named after its run.
+func RunIDOf(objectName string) string {
+	match := objectRunIDPattern.FindStringSubmatch(objectName)
+	if match == nil {
+		return ""
+	}
+	return match[1]
+}
+
+// Manifest describes a run of the exporter: when it ran, with which config,
+// what it collected and where its objects were saved.
+type Manifest struct {
+	RunID      string         `json:"runId"`
+	OrgId      string         `json:"orgId"`
+	State      string         `json:"state"`
+	StartTime  time.Time      `json:"startTime"`
+	EndTime    time.Time      `json:"endTime"`
+	ConfigHash string         `json:"configHash"`
+	Collectors map[string]int `json:"collectors"`
+	Errors     map[string]int `json:"errors"`
+	Retries    map[string]int `json:"retries"`
+	ObjectURIs []string       `json:"objectUris"`
+	Error      string         `json:"error,omitempty"`
+}
+
+// NewManifest returns the manifest of a successful run, with the resource
+// and error counts of each collector of the snapshot.
+func NewManifest(snapshot *inventory.Snapshot, configHash string, objectURIs ...string) *Manifest {
+	m := &Manifest{
+		RunID:      snapshot.RunID,
+		OrgId:      snapshot.OrgId,
+		State:      SnapshotSucceeded,
+		StartTime:  snapshot.StartTime,
+		EndTime:    snapshot.EndTime,
+		ConfigHash: configHash,
+		Collectors: map[string]int{},
+		Errors:     snapshot.Errors.CountByCollector(),
+		Retries:    snapshot.Retries,
+		ObjectURIs: objectURIs,
+	}
+	for _, table := range snapshot.Tables {
+		m.Collectors[table.Collector] = len(table.Resources)
+	}
+	return m
+}
+
+// NewFailedManifest returns the manifest of a run that failed with err.
+func NewFailedManifest(orgId string, startTime time.Time, configHash string, err error) *Manifest {
+	return &Manifest{
+		RunID:      inventory.RunID(startTime),
+		OrgId:      orgId,
+		State:      SnapshotFailed,
+		StartTime:  startTime,
+		EndTime:    time.Now(),
+		ConfigHash: configHash,
+		Collectors: map[string]int{},
+		Errors:     map[string]int{},
+		Retries:    map[string]int{},
+		Error:      err.Error(),
+	}
+}
+
+func ValidRunID(id string) bool {
+	return runIDPattern.MatchString(id)
+}
+
+// ManifestObjectName returns the name of the object holding the manifest of
+// a run.
+func ManifestObjectName(runID string) string {
+	return CatalogPrefix + runID + ".json"
+}
+
+// SaveManifest adds the manifest to the catalog of the bucket. The latest
+// pointer is moved to it only when the run succeeded.
+func (s *Storage) SaveManifest(ctx context.Context, bucketName string, m *Manifest) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := s.SaveFile(ctx, bucketName, ManifestObjectName(m.RunID), "application/json", data); err != nil {
+		return err
+	}
+	if m.State != SnapshotSucceeded {
+		return nil
+	}
+	return s.SaveFile(ctx, bucketName, LatestObjectName, "application/json", data)
+}
+
+// ListSnapshots returns the run ids of the catalog, newest first.
+func (s *Storage) ListSnapshots(ctx context.Context, bucketName string) ([]string, error) {
+	objects, err := s.ListObjects(ctx, bucketName, CatalogPrefix)
+	if err != nil {
+		return nil, err
+	}
+	var runIDs []string
+	for _, o := range objects {
+		runID := strings.TrimSuffix(strings.TrimPrefix(o.Name, CatalogPrefix), ".json")
+		if ValidRunID(runID) {
+			runIDs = append(runIDs, runID)
+		}
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(runIDs)))
+	return runIDs, nil
+}
+
+// GetManifest returns the manifest of a run, or ErrObjectNotExist when the
+// catalog has no such run.
+func (s *Storage) GetManifest(ctx context.Context, bucketName, runID string) (*Manifest, error) {
+	if !ValidRunID(runID) {
+		return nil, fmt.Errorf("invalid run id %q", runID)
+	}
+	return s.readManifest(ctx, bucketName, ManifestObjectName(runID))
+}
+
+// LatestManifest returns the manifest of the last successful run, or
+// ErrObjectNotExist when no run succeeded yet.
+func (s *Storage) LatestManifest(ctx context.Context, bucketName string) (*Manifest, error) {
+	return s.readManifest(ctx, bucketName, LatestObjectName)
+}
+
+// LatestManifestWithConfig returns the manifest of the last successful run
+// with the config hash, or ErrObjectNotExist when there is none. The latest
+// pointer is read first, since the last run usually has the same config.
+func (s *Storage) LatestManifestWithConfig(ctx context.Context, bucketName, configHash string) (*Manifest, error) {
+	m, err := s.LatestManifest(ctx, bucketName)
+	if err != nil && err != ErrObjectNotExist {
+		return nil, err
+	}
+	if m != nil && m.ConfigHash == configHash {
+		return m, nil
+	}
+	runIDs, err := s.ListSnapshots(ctx, bucketName)
+	if err != nil {
+		return nil, err
+	}
+	for _, runID := range runIDs {
+		m, err := s.GetManifest(ctx, bucketName, runID)
+		if err == ErrObjectNotExist {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if m.State == SnapshotSucceeded && m.ConfigHash == configHash {
+			return m, nil
+		}
+	}
+	return nil, ErrObjectNotExist
+}
+
+// ListManifests returns the manifests of up to limit runs, newest first. A
+// limit of 0 returns every run.
+func (s *Storage) ListManifests(ctx context.Context, bucketName string, limit int) ([]*Manifest, error) {
+	runIDs, err := s.ListSnapshots(ctx, bucketName)
+	if err != nil {
+		return nil, err
+	}
+	if limit > 0 && len(runIDs) > limit {
+		runIDs = runIDs[:limit]
+	}
+	manifests := []*Manifest{}
+	for _, runID := range runIDs {
+		m, err := s.GetManifest(ctx, bucketName, runID)
+		if err == ErrObjectNotExist {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		manifests = append(manifests, m)
+	}
+	return manifests, nil
+}
+
+func (s *Storage) readManifest(ctx context.Context, bucketName, objectName string) (*Manifest, error) {
+	data, err := s.ReadFile(ctx, bucketName, objectName)
+	if err != nil {
+		return nil, err
+	}
+	m := &Manifest{}
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, fmt.Errorf("invalid manifest %s: %s", objectName, err.Error())
+	}
+	return m, nil
+}
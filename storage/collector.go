@@ -0,0 +1,59 @@
+package storage
+
+import (
+	"context"
+	"github.com/liornabat/gcp_inventory_exporter/collector"
+	"github.com/liornabat/gcp_inventory_exporter/inventory"
+	"github.com/liornabat/gcp_inventory_exporter/pkg/gcpclient"
+	"github.com/liornabat/gcp_inventory_exporter/project"
+)
+
+const BucketCollectorName = "cloud_storage"
+
+func init() {
+	collector.Register(BucketCollectorName, NewBucketCollector)
+}
+
+type BucketCollector struct {
+	opts *collector.Options
+}
+
+func NewBucketCollector(opts *collector.Options) collector.Collector {
+	return &BucketCollector{
+		opts: opts,
+	}
+}
+
+func (c *BucketCollector) Name() string {
+	return BucketCollectorName
+}
+
+func (c *BucketCollector) Sheet() string {
+	return "Cloud Storage"
+}
+
+func (c *BucketCollector) Schema() *inventory.Schema {
+	return BucketSchema
+}
+
+func (c *BucketCollector) RequiredAPIs() []string {
+	return []string{"storage.googleapis.com"}
+}
+
+func (c *BucketCollector) Collect(ctx context.Context, projects []*project.Project) ([]*inventory.Resource, error) {
+	s, err := NewStorage(ctx, c.opts.Config.ExportProjectId, c.opts.Clients.For(gcpclient.Storage)...)
+	if err != nil {
+		return nil, err
+	}
+	defer s.Close()
+	s.SetRetrier(c.opts.Retrier)
+	return s.GetStorageInventory(ctx, projects, c.opts)
+}
+
+func (c *BucketCollector) AssetTypes() []string {
+	return []string{BucketAssetType}
+}
+
+func (c *BucketCollector) CollectAssets(ctx context.Context, assets []*collector.Asset) ([]*inventory.Resource, error) {
+	return GetStorageAssetInventory(ctx, assets, c.opts)
+}
@@ -4,28 +4,46 @@ import (
 	"bytes"
 	"cloud.google.com/go/storage"
 	"context"
+	"github.com/liornabat/gcp_inventory_exporter/collector"
+	"github.com/liornabat/gcp_inventory_exporter/inventory"
 	"github.com/liornabat/gcp_inventory_exporter/pkg/logger"
+	"github.com/liornabat/gcp_inventory_exporter/pkg/retention"
+	"github.com/liornabat/gcp_inventory_exporter/pkg/retry"
 	"github.com/liornabat/gcp_inventory_exporter/project"
 	"google.golang.org/api/iterator"
+	"google.golang.org/api/option"
 	"io"
+	"strings"
 	"sync"
+	"time"
 )
 
-var bucketHeader = []string{
-	"Project",
-	"Name",
-	"Location",
-	"Storage Class",
-	"Creation Timestamp",
+const BucketKind = "storage#bucket"
+
+var BucketSchema = &inventory.Schema{
+	Kind: BucketKind,
+	Key:  []string{"Name"},
+	Columns: []inventory.Column{
+		{Name: "Project", Type: inventory.StringColumn},
+		{Name: "Project ID", Type: inventory.StringColumn},
+		{Name: "Name", Type: inventory.StringColumn},
+		{Name: "Location", Type: inventory.StringColumn},
+		{Name: "Storage Class", Type: inventory.StringColumn},
+		{Name: "Creation Timestamp", Type: inventory.TimestampColumn},
+	},
 }
 
+// ErrObjectNotExist is returned by ReadFile for a missing object.
+var ErrObjectNotExist = storage.ErrObjectNotExist
+
 type Storage struct {
 	client    *storage.Client
 	projectID string
+	retrier   *retry.Retrier
 }
 
-func NewStorage(ctx context.Context, projectId string) (*Storage, error) {
-	client, err := storage.NewClient(ctx)
+func NewStorage(ctx context.Context, projectId string, clientOptions ...option.ClientOption) (*Storage, error) {
+	client, err := storage.NewClient(ctx, clientOptions...)
 	if err != nil {
 		return nil, err
 	}
@@ -35,12 +53,23 @@ func NewStorage(ctx context.Context, projectId string) (*Storage, error) {
 	}, nil
 }
 
+// SetRetrier replaces the client's built-in retries with the retrier, so
+// storage calls follow the same retry policy as the collectors.
+func (s *Storage) SetRetrier(retrier *retry.Retrier) *Storage {
+	s.retrier = retrier
+	s.client.SetRetry(storage.WithPolicy(storage.RetryNever))
+	return s
+}
+
 func (s *Storage) Close() error {
 	return s.client.Close()
 }
 
 func (s *Storage) BucketExistsOrCreate(ctx context.Context, bucketName string) error {
-	_, err := s.client.Bucket(bucketName).Attrs(ctx)
+	err := s.retrier.Do(ctx, "storage.buckets.get", func() error {
+		_, err := s.client.Bucket(bucketName).Attrs(ctx)
+		return err
+	})
 	if err == storage.ErrBucketNotExist {
 		return s.CreateBucket(ctx, bucketName)
 	}
@@ -51,76 +80,235 @@ func (s *Storage) BucketExistsOrCreate(ctx context.Context, bucketName string) e
 }
 
 func (s *Storage) CreateBucket(ctx context.Context, bucketName string) error {
-	return s.client.Bucket(bucketName).Create(ctx, s.projectID, nil)
+	return s.retrier.Do(ctx, "storage.buckets.insert", func() error {
+		return s.client.Bucket(bucketName).Create(ctx, s.projectID, nil)
+	})
 }
 
 func (s *Storage) ListBuckets(ctx context.Context) ([]string, error) {
 	var buckets []string
-	it := s.client.Buckets(ctx, s.projectID)
-	for {
-		bucketAttrs, err := it.Next()
-		if err == iterator.Done {
-			break
-		}
-		if err != nil {
-			return nil, err
+	err := s.retrier.Do(ctx, "storage.buckets.list", func() error {
+		buckets = nil
+		it := s.client.Buckets(ctx, s.projectID)
+		for {
+			bucketAttrs, err := it.Next()
+			if err == iterator.Done {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			buckets = append(buckets, bucketAttrs.Name)
 		}
-		buckets = append(buckets, bucketAttrs.Name)
+	})
+	if err != nil {
+		return nil, err
 	}
 	return buckets, nil
+}
 
+func (s *Storage) SaveFile(ctx context.Context, bucketName, objectName, contentType string, objectData []byte) error {
+	return s.WriteFile(ctx, bucketName, objectName, contentType, func(w io.Writer) error {
+		_, err := io.Copy(w, bytes.NewReader(objectData))
+		return err
+	})
 }
-func (s *Storage) SaveFile(ctx context.Context, bucketName, objectName string, objectData []byte) error {
-	bucket := s.client.Bucket(bucketName)
-	wc := bucket.Object(objectName).NewWriter(ctx)
-	wc.ContentType = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
 
-	if _, err := io.Copy(wc, bytes.NewReader(objectData)); err != nil {
+// WriteFile streams the content written by write into an object, without
+// holding it in memory. The object is created only when write succeeds, and
+// write is called again when the upload is retried.
+func (s *Storage) WriteFile(ctx context.Context, bucketName, objectName, contentType string, write func(w io.Writer) error) error {
+	return s.retrier.Do(ctx, "storage.objects.insert", func() error {
+		// Canceling the context of the writer aborts the upload, where
+		// closing it would create a partial object.
+		writeCtx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		wc := s.client.Bucket(bucketName).Object(objectName).NewWriter(writeCtx)
+		wc.ContentType = contentType
+		if err := write(wc); err != nil {
+			cancel()
+			wc.Close()
+			return err
+		}
+		return wc.Close()
+	})
+}
+
+// ReadFile returns the content of an object, or ErrObjectNotExist when there
+// is no such object.
+func (s *Storage) ReadFile(ctx context.Context, bucketName, objectName string) ([]byte, error) {
+	var data []byte
+	err := s.retrier.Do(ctx, "storage.objects.get", func() error {
+		reader, err := s.client.Bucket(bucketName).Object(objectName).NewReader(ctx)
+		if err != nil {
+			return err
+		}
+		defer reader.Close()
+		data, err = io.ReadAll(reader)
 		return err
+	})
+	if err != nil {
+		return nil, err
 	}
-	if err := wc.Close(); err != nil {
-		return err
+	return data, nil
+}
+
+// ListObjects returns the objects of a bucket whose names start with prefix.
+func (s *Storage) ListObjects(ctx context.Context, bucketName, prefix string) ([]*retention.Object, error) {
+	var objects []*retention.Object
+	err := s.retrier.Do(ctx, "storage.objects.list", func() error {
+		objects = nil
+		it := s.client.Bucket(bucketName).Objects(ctx, &storage.Query{Prefix: prefix})
+		for {
+			objectAttrs, err := it.Next()
+			if err == iterator.Done {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			objects = append(objects, &retention.Object{
+				Name:    objectAttrs.Name,
+				Created: objectAttrs.Created,
+				Run:     RunIDOf(objectAttrs.Name),
+			})
+		}
+	})
+	if err != nil {
+		return nil, err
 	}
+	return objects, nil
+}
+
+func (s *Storage) DeleteObject(ctx context.Context, bucketName, objectName string) error {
+	return s.retrier.Do(ctx, "storage.objects.delete", func() error {
+		return s.client.Bucket(bucketName).Object(objectName).Delete(ctx)
+	})
+}
 
+// EnforceRetention deletes the objects under prefixes that the policy does not
+// keep and returns their names. The objects of a run are counted once, so a
+// run saved in several objects is kept or pruned whole. A run that saved only
+// its manifest counts towards the policy only when it succeeded, so failed
+// runs do not push out the exports. The latest manifest pointer is never
+// pruned. In dry run nothing is deleted.
+func (s *Storage) EnforceRetention(ctx context.Context, log *logger.Logger, bucketName string, policy *retention.Policy, prefixes ...string) ([]string, error) {
+	if !policy.Enabled() {
+		return nil, nil
+	}
+	var objects []*retention.Object
+	for _, prefix := range prefixes {
+		listed, err := s.ListObjects(ctx, bucketName, prefix)
+		if err != nil {
+			return nil, err
+		}
+		for _, o := range listed {
+			if o.Name != LatestObjectName {
+				objects = append(objects, o)
+			}
+		}
+	}
+	if err := s.readRuns(ctx, bucketName, objects); err != nil {
+		return nil, err
+	}
+	_, prune := policy.Select(objects, time.Now())
+	var pruned []string
+	for _, o := range prune {
+		if policy.DryRun {
+			log.Infof("Retention dry run, would prune gs://%s/%s created at %s", bucketName, o.Name, o.Created.Format(time.RFC3339))
+			pruned = append(pruned, o.Name)
+			continue
+		}
+		if err := s.DeleteObject(ctx, bucketName, o.Name); err != nil && err != storage.ErrObjectNotExist {
+			return pruned, err
+		}
+		log.Infof("Retention pruned gs://%s/%s created at %s", bucketName, o.Name, o.Created.Format(time.RFC3339))
+		pruned = append(pruned, o.Name)
+	}
+	return pruned, nil
+}
+
+// readRuns reads the manifests of the runs that saved no object named after
+// them. The objects a manifest lists join its run, e.g. an export saved under
+// a custom name, and the manifest of a failed run is marked as extra.
+func (s *Storage) readRuns(ctx context.Context, bucketName string, objects []*retention.Object) error {
+	saved := map[string]bool{}
+	byName := map[string]*retention.Object{}
+	for _, o := range objects {
+		byName[o.Name] = o
+		if o.Run != "" && !strings.HasPrefix(o.Name, CatalogPrefix) {
+			saved[o.Run] = true
+		}
+	}
+	for _, o := range objects {
+		if o.Run == "" || saved[o.Run] || !strings.HasPrefix(o.Name, CatalogPrefix) {
+			continue
+		}
+		m, err := s.readManifest(ctx, bucketName, o.Name)
+		if err == ErrObjectNotExist {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		o.Extra = m.State != SnapshotSucceeded
+		for _, uri := range m.ObjectURIs {
+			listed, ok := byName[strings.TrimPrefix(uri, "gs://"+bucketName+"/")]
+			if ok && listed.Run == "" {
+				listed.Run = o.Run
+			}
+		}
+	}
 	return nil
 }
 
-func (s *Storage) GetStorageInventory(ctx context.Context, projectsId []*project.Project, log *logger.Logger) ([][]string, error) {
+func newBucketResource(projectId *project.Project, bucketAttrs *storage.BucketAttrs) *inventory.Resource {
+	return inventory.NewResource(BucketKind, projectId.ID, bucketAttrs.Location, bucketAttrs.Name).
+		Set("Project", projectId.Name).
+		Set("Project ID", projectId.ID).
+		Set("Name", bucketAttrs.Name).
+		Set("Location", bucketAttrs.Location).
+		Set("Storage Class", bucketAttrs.StorageClass).
+		Set("Creation Timestamp", bucketAttrs.Created).
+		SetLabels(bucketAttrs.Labels).
+		SetRaw(bucketAttrs)
+}
+
+func (s *Storage) GetStorageInventory(ctx context.Context, projectsId []*project.Project, opts *collector.Options) ([]*inventory.Resource, error) {
+	log := opts.Log
 	log.Infof("Getting Cloud Store inventory")
 	defer log.Infof("Done Cloud Store inventory")
-	var inventory [][]string
-	inventory = append(inventory, bucketHeader)
+	var resources []*inventory.Resource
 	mutex := &sync.Mutex{}
 	wg := &sync.WaitGroup{}
 	wg.Add(len(projectsId))
 	for _, projectId := range projectsId {
 		go func(projectId *project.Project) {
 			defer wg.Done()
-			var localInventory [][]string
+			var localResources []*inventory.Resource
 			log.Infof("Getting Cloud Store inventory for project %s", projectId.Name)
-			bucketsIterator := s.client.Buckets(ctx, projectId.ID)
-			for {
-				bucketAttrs, err := bucketsIterator.Next()
-				if err == iterator.Done {
-					break
-				}
-				if err != nil {
-					log.Errorf("Error getting bucket attributes for project %s: %v", projectId.Name, err)
-					break
+			if err := opts.Call(ctx, "storage.buckets.list", func() error {
+				localResources = nil
+				bucketsIterator := s.client.Buckets(ctx, projectId.ID)
+				for {
+					bucketAttrs, err := bucketsIterator.Next()
+					if err == iterator.Done {
+						return nil
+					}
+					if err != nil {
+						return err
+					}
+					localResources = append(localResources, newBucketResource(projectId, bucketAttrs))
 				}
-				localInventory = append(localInventory, []string{
-					projectId.Name,
-					bucketAttrs.Name,
-					bucketAttrs.Location,
-					bucketAttrs.StorageClass,
-					bucketAttrs.Created.String(),
-				})
+			}); err != nil {
+				log.Errorf("Error getting bucket attributes for project %s: %v", projectId.Name, err)
+				opts.Errors.Add(inventory.NewError(BucketCollectorName, projectId.ID, "", "storage.buckets.list", err))
 			}
 			mutex.Lock()
-			inventory = append(inventory, localInventory...)
+			resources = append(resources, localResources...)
 			mutex.Unlock()
 		}(projectId)
 	}
 	wg.Wait()
-	return inventory, nil
+	return resources, nil
 }
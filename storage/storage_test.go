@@ -0,0 +1,120 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/liornabat/gcp_inventory_exporter/pkg/fakegcp"
+	"github.com/liornabat/gcp_inventory_exporter/pkg/gcpclient"
+	"github.com/liornabat/gcp_inventory_exporter/pkg/logger"
+	"github.com/liornabat/gcp_inventory_exporter/pkg/retention"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+const testBucket = "inv"
+
+func newTestStorage(t *testing.T) (*Storage, *fakegcp.Server) {
+	t.Helper()
+	srv := fakegcp.NewServer(nil)
+	endpoint := srv.Start()
+	t.Cleanup(srv.Close)
+	s, err := NewStorage(context.Background(), "demo-project", gcpclient.NewOptions(endpoint).For(gcpclient.Storage)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s, srv
+}
+
+func putManifest(t *testing.T, srv *fakegcp.Server, runID, state string, created time.Time, objectURIs ...string) {
+	t.Helper()
+	data, err := json.Marshal(&Manifest{RunID: runID, State: state, ObjectURIs: objectURIs})
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.PutObject(testBucket, ManifestObjectName(runID), "application/json", data, created)
+}
+
+func TestEnforceRetention(t *testing.T) {
+	hour := func(n int) time.Time {
+		return time.Now().Add(time.Duration(-n) * time.Hour)
+	}
+	// From oldest to newest: two runs with an export, a successful run
+	// saved under a custom object name and a failed run.
+	setup := func(t *testing.T) (*Storage, *fakegcp.Server) {
+		s, srv := newTestStorage(t)
+		for _, run := range []struct {
+			id      string
+			created time.Time
+		}{
+			{"2026-10-15-06-00-00-aaaaaaaa", hour(6)},
+			{"2026-10-15-07-00-00-bbbbbbbb", hour(5)},
+		} {
+			srv.PutObject(testBucket, "inventory-"+run.id+".xlsx", "", []byte("xlsx"), run.created)
+			putManifest(t, srv, run.id, SnapshotSucceeded, run.created)
+		}
+		srv.PutObject(testBucket, "inventory-custom.xlsx", "", []byte("xlsx"), hour(4))
+		putManifest(t, srv, "2026-10-15-08-00-00-cccccccc", SnapshotSucceeded, hour(4), "gs://inv/inventory-custom.xlsx")
+		putManifest(t, srv, "2026-10-15-09-00-00-dddddddd", SnapshotFailed, hour(3))
+		srv.PutObject(testBucket, LatestObjectName, "application/json", []byte("{}"), hour(4))
+		return s, srv
+	}
+	// The failed run does not take a slot, and the custom export is kept with
+	// the manifest that lists it.
+	expected := []string{
+		"inventory-2026-10-15-06-00-00-aaaaaaaa.xlsx",
+		"snapshots/2026-10-15-06-00-00-aaaaaaaa.json",
+	}
+	log := logger.NewLogger("test", "error")
+	policy := &retention.Policy{KeepLast: 2}
+
+	t.Run("prune", func(t *testing.T) {
+		s, srv := setup(t)
+		pruned, err := s.EnforceRetention(context.Background(), log, testBucket, policy, "inventory-", CatalogPrefix)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sort.Strings(pruned)
+		if !reflect.DeepEqual(pruned, expected) {
+			t.Errorf("pruned %v, expected %v", pruned, expected)
+		}
+		for _, name := range pruned {
+			if _, ok := srv.Object(testBucket, name); ok {
+				t.Errorf("%s was not deleted", name)
+			}
+		}
+		for _, name := range []string{
+			"inventory-2026-10-15-07-00-00-bbbbbbbb.xlsx",
+			"snapshots/2026-10-15-07-00-00-bbbbbbbb.json",
+			"inventory-custom.xlsx",
+			"snapshots/2026-10-15-08-00-00-cccccccc.json",
+			"snapshots/2026-10-15-09-00-00-dddddddd.json",
+			LatestObjectName,
+		} {
+			if _, ok := srv.Object(testBucket, name); !ok {
+				t.Errorf("%s was deleted", name)
+			}
+		}
+	})
+
+	t.Run("dry run", func(t *testing.T) {
+		s, srv := setup(t)
+		dryRun := *policy
+		dryRun.DryRun = true
+		pruned, err := s.EnforceRetention(context.Background(), log, testBucket, &dryRun, "inventory-", CatalogPrefix)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sort.Strings(pruned)
+		if !reflect.DeepEqual(pruned, expected) {
+			t.Errorf("would prune %v, expected %v", pruned, expected)
+		}
+		for _, name := range pruned {
+			if _, ok := srv.Object(testBucket, name); !ok {
+				t.Errorf("%s was deleted in dry run", name)
+			}
+		}
+	})
+}